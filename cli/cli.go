@@ -2,50 +2,163 @@ package cli
 
 import (
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
 	"syscall"
 	"time"
 
+	"meshnet/config"
 	"meshnet/core"
 	"meshnet/dht"
+	"meshnet/pairing"
+	"meshnet/statedir"
+	"meshnet/yggadmin"
 )
 
+// state is the profile every command works against
+// set from --data-dir and --profile before dispatch
+var state *statedir.Dir
+
+// cfg is the profile's configuration — defaults, overlaid by the config
+// file, overlaid by command flags
+var cfg *config.Config
+
+// configPath is the config file in use, from --config or the state directory
+var configPath string
+
+// globalFlags come before the command: meshnet --profile work start
+type globalFlags struct {
+	dataDir string
+	profile string
+	config  string
+}
+
 // Run is the entry point for the CLI
 func Run() {
-	if len(os.Args) < 2 {
+	args, globals := parseGlobalFlags(os.Args[1:])
+	if len(args) == 0 {
 		printHelp()
 		os.Exit(0)
 	}
 
-	switch os.Args[1] {
+	switch args[0] {
+	case "help", "--help", "-h":
+		printHelp()
+		return
+	}
+
+	var err error
+	state, err = statedir.Open(globals.dataDir, globals.profile)
+	if err != nil {
+		fmt.Println("Failed to open state directory:", err)
+		os.Exit(1)
+	}
+
+	// config problems are reported by `meshnet config` itself so a
+	// broken file can still be inspected and replaced
+	configPath = globals.config
+	if configPath == "" {
+		configPath = state.File(config.FileName)
+	}
+	cfgErr := loadConfig()
+	if cfgErr != nil && args[0] != "config" {
+		fmt.Println("Failed to load config:", cfgErr)
+		os.Exit(1)
+	}
+
+	switch args[0] {
 	case "start":
-		cmdStart(os.Args[2:])
+		cmdStart(args[1:])
 	case "lookup":
-		cmdLookup(os.Args[2:])
+		cmdLookup(args[1:])
 	case "status":
-		cmdStatus(os.Args[2:])
+		cmdStatus(args[1:])
 	case "peers":
-		cmdPeers(os.Args[2:])
+		cmdPeers(args[1:])
 	case "peer":
-		cmdPeer(os.Args[2:])
-	case "help", "--help", "-h":
-		printHelp()
+		cmdPeer(args[1:])
+	case "identity":
+		cmdIdentity(args[1:])
+	case "contacts":
+		cmdContacts(args[1:])
+	case "config":
+		cmdConfig(args[1:], cfgErr)
 	default:
-		fmt.Fprintf(os.Stderr, "Unknown command: %s\n\n", os.Args[1])
+		fmt.Fprintf(os.Stderr, "Unknown command: %s\n\n", args[0])
 		printHelp()
 		os.Exit(1)
 	}
 }
 
+// parseGlobalFlags strips the global flags from the front of args
+func parseGlobalFlags(args []string) ([]string, globalFlags) {
+	var g globalFlags
+	for len(args) > 0 {
+		name, value, hasValue := strings.Cut(strings.TrimLeft(args[0], "-"), "=")
+		if !strings.HasPrefix(args[0], "-") ||
+			(name != "data-dir" && name != "profile" && name != "config") {
+			break
+		}
+		if !hasValue {
+			if len(args) < 2 {
+				fmt.Fprintf(os.Stderr, "flag --%s needs a value\n", name)
+				os.Exit(1)
+			}
+			value = args[1]
+			args = args[1:]
+		}
+		args = args[1:]
+
+		switch name {
+		case "data-dir":
+			g.dataDir = value
+		case "profile":
+			g.profile = value
+		case "config":
+			g.config = value
+		}
+	}
+	return args, g
+}
+
+// loadConfig builds cfg from the defaults, the profile's ports and the
+// config file. cfg is always usable afterwards, even on error.
+func loadConfig() error {
+	cfg = config.Default()
+	cfg.DHTPort = state.DHTPort
+	cfg.APIAddress = fmt.Sprintf("127.0.0.1:%d", state.APIPort)
+
+	if err := cfg.LoadFile(configPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return cfg.Validate()
+}
+
+// apiURL builds a URL on the local API of this profile's node
+func apiURL(format string, args ...interface{}) string {
+	return "http://" + cfg.APIAddress + fmt.Sprintf(format, args...)
+}
+
 func printHelp() {
 	fmt.Println(`MeshNet — decentralized peer-to-peer network
 
 USAGE:
-  meshnet <command> [flags]
+  meshnet [--profile <name>] [--data-dir <dir>] [--config <file>] <command> [flags]
+
+GLOBAL FLAGS:
+  --profile   Run against a named profile with its own identity and ports
+  --data-dir  State directory (default: ~/.local/share/meshnet on Linux)
+  --config    Config file (default: meshnet.conf in the profile directory)
 
 COMMANDS:
   start     Start the MeshNet node
@@ -53,6 +166,9 @@ COMMANDS:
   status    Show node status
   peers     List known DHT peers
   peer      Manage peers
+  identity  Manage the node identity
+  contacts  Manage paired contacts
+  config    Show, create or check the config file
   help      Show this help
 
 Run 'meshnet <command> --help' for command-specific flags.`)
@@ -62,13 +178,22 @@ Run 'meshnet <command> --help' for command-specific flags.`)
 
 func cmdStart(args []string) {
 	fs := flag.NewFlagSet("start", flag.ExitOnError)
-	name := fs.String("name", "", "Name to register on the mesh")
-	port := fs.Int("port", 9001, "DHT listen port")
-	identity := fs.String("identity", "identity.json", "Path to identity file")
-	peer := fs.String("peer", "", "Bootstrap peer address e.g. [::1]:9002")
-	services := fs.String("services", "", "Comma-separated services e.g. ssh:22,http:80")
+	name := fs.String("name", cfg.Name, "Name to register on the mesh")
+	port := fs.Int("port", cfg.DHTPort, "DHT listen port")
+	identity := fs.String("identity", state.IdentityFile(), "Path to identity file")
+	peer := fs.String("peer", "", "Bootstrap peer address e.g. [200:1234::1]:9001 — must be the peer's Yggdrasil address")
+	services := fs.String("services", strings.Join(cfg.Services, ","), "Comma-separated services e.g. ssh:22,http:80")
+	group := fs.String("group", cfg.Group, "Register in a private group (name from config or key)")
+	network := fs.String("network", cfg.Network, "Network ID to join, empty for the public network")
+	udp := fs.Bool("udp", cfg.DHTUDP, "Also send DHT lookups over UDP to peers that take it (--udp=false for TCP only)")
 	tun := fs.Bool("tun", false, "Enable TUN interface for browser/OS access (requires admin)")
-	yggBin := fs.String("yggdrasil", "bin/yggdrasil.exe", "Path to yggdrasil binary")
+	listen := fs.String("listen", strings.Join(cfg.Listen, ","), "Comma-separated Yggdrasil URIs to accept peerings on e.g. tls://[::]:9443")
+	lan := fs.Bool("lan", false, "Discover peers on the local network by multicast (all interfaces)")
+	private := fs.Bool("private", cfg.Private, "Accept inbound peerings only from contacts and AllowedPublicKeys")
+	yggBin := fs.String("yggdrasil", core.DefaultYggdrasilBinary(), "Path to yggdrasil binary")
+	yggConf := fs.String("yggdrasil-conf", "", "Yggdrasil config to take the identity from (default: $YGGDRASIL_CONF, then /etc)")
+	passphraseFile := fs.String("passphrase-file", "", "Read identity passphrase from first line of file")
+	passphraseFD := fs.Int("passphrase-fd", -1, "Read identity passphrase from file descriptor")
 	fs.Usage = func() {
 		fmt.Println(`Start the MeshNet node
 
@@ -81,16 +206,92 @@ FLAGS:`)
 EXAMPLES:
   meshnet start --name alice
   meshnet start --name alice --tun
-  meshnet start --name myserver --services ssh:22,http:80`)
+  meshnet start --name myserver --services ssh:22,http:80
+  meshnet start --name alice --lan --listen tls://[::]:9443
+  meshnet start --name alice --private --listen tls://[::]:9443
+  meshnet --profile work start --name alice-work
+  MESHNET_PASSPHRASE=... meshnet start --name alice`)
 	}
 	fs.Parse(args)
 
-	os.Setenv("IDENTITY", *identity)
+	if dht.IsNodeRunning(cfg.APIAddress) {
+		fmt.Printf("A node is already running for profile %q.\n", state.Profile)
+		os.Exit(1)
+	}
+
+	groupKey, err := cfg.GroupKey(*group)
+	if err != nil {
+		fmt.Println("Invalid group:", err)
+		os.Exit(1)
+	}
+	if err := config.CheckNetwork(*network); err != nil {
+		fmt.Println("Invalid network:", err)
+		os.Exit(1)
+	}
+
+	listenURIs := splitList(*listen)
+	mcast := multicastInterfaces(*lan)
+	nodeInfo := dht.NodeInfo{
+		Name:    *name,
+		DHTPort: *port,
+		Version: dht.ProtocolVersion,
+		Network: *network,
+	}.Map()
+
+	var allowed []string
+	if *private {
+		if allowed, err = allowedKeys(); err != nil {
+			fmt.Println("Failed to load contacts:", err)
+			os.Exit(1)
+		}
+	}
 
 	fmt.Println("MeshNet Starting...")
+	fmt.Printf("Profile:    %s (%s)\n", state.Profile, state.Path)
+	if _, err := os.Stat(configPath); err == nil {
+		fmt.Printf("Config:     %s\n", configPath)
+	}
+	if *network != "" {
+		fmt.Printf("Network:    %s\n", *network)
+	}
 
 	// ── identity + node ──────────────────────────────────────────────────────
-	node := core.NewNode()
+	pass, err := core.DefaultPassphraseSource(*passphraseFile, *passphraseFD)
+	if err != nil {
+		fmt.Println("Failed to read passphrase:", err)
+		os.Exit(1)
+	}
+
+	logOut := io.Writer(os.Stderr)
+	if cfg.Log.File != "" {
+		logPath := cfg.Log.File
+		if !filepath.IsAbs(logPath) {
+			logPath = state.File(logPath)
+		}
+		logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			fmt.Println("Failed to open log file:", err)
+			os.Exit(1)
+		}
+		defer logFile.Close()
+		logOut = logFile
+	}
+
+	node := core.NewNode(*identity)
+	node.SetPassphraseSource(pass)
+	node.SetYggdrasilConfig(*yggConf)
+	node.SetPeers(cfg.Peers)
+	node.SetLogging(cfg.Log.Level, logOut)
+	node.SetNodeInfo(nodeInfo)
+	if !*tun {
+		// in TUN mode the subprocess listens and serves admin — see below
+		node.SetListen(listenURIs)
+		node.SetMulticast(mcast)
+		node.SetAdminListen("unix://" + state.AdminSocketFile())
+		if *private {
+			node.SetPrivate(allowed)
+		}
+	}
 	if err := node.Start(); err != nil {
 		fmt.Println("Failed to start node:", err)
 		os.Exit(1)
@@ -109,7 +310,15 @@ EXAMPLES:
 	if *tun {
 		fmt.Print("Starting TUN interface")
 
-		yggSvc = core.NewYggService(*yggBin)
+		yggSvc = core.NewYggService(*yggBin, state.YggdrasilConfigFile(), state.YggdrasilLogFile())
+		yggSvc.SetInstance(state.Offset, state.YggAdminPort)
+		yggSvc.SetPeers(cfg.Peers)
+		yggSvc.SetListen(listenURIs)
+		yggSvc.SetMulticast(mcast)
+		yggSvc.SetNodeInfo(nodeInfo)
+		if *private {
+			yggSvc.SetPrivate(allowed)
+		}
 
 		if !yggSvc.IsInstalled() {
 			if err := yggSvc.WriteConfig(core.PrivKeyHex(node.PrivateKey())); err != nil {
@@ -120,13 +329,41 @@ EXAMPLES:
 
 		if err := yggSvc.Start(); err != nil {
 			fmt.Println("\nFailed to start TUN:", err)
-			fmt.Println("Hint: Run PowerShell as Administrator")
+			fmt.Println("Hint:", yggSvc.PrivilegeHint())
 			os.Exit(1)
 		}
 
 		fmt.Println(" ready.")
+
+		// the DHT signs with the node's key and announces the node's
+		// address — an installed service whose config we couldn't read
+		// runs on another key, and the TUN address would not match
+		tunKey, err := yggSvc.GetPublicKey()
+		if err != nil {
+			fmt.Println("Failed to read the TUN's key from its admin socket:", err)
+			fmt.Println("Hint: run with access to the Yggdrasil admin socket, or pass --yggdrasil-conf")
+			yggSvc.Stop()
+			os.Exit(1)
+		}
+		if tunKey != node.PublicKey() {
+			fmt.Printf("The TUN runs on key %s..., not this node's %s...\n", str16(tunKey), node.PublicKey()[:16])
+			fmt.Println("Hint: make the Yggdrasil config readable or pass it with --yggdrasil-conf, so both use one key")
+			yggSvc.Stop()
+			os.Exit(1)
+		}
 		fmt.Println("TUN active — browser can reach Yggdrasil addresses directly.")
 
+		// restart the subprocess if it crashes — the DHT keeps announcing
+		// our address, so the TUN has to stay up behind it
+		yggSvc.SetStateHandler(func(ev core.YggEvent) {
+			if ev.Detail != "" {
+				fmt.Printf("[%s] Yggdrasil %s: %s\n", ev.Time.Format("15:04:05"), ev.State, ev.Detail)
+			} else {
+				fmt.Printf("[%s] Yggdrasil %s\n", ev.Time.Format("15:04:05"), ev.State)
+			}
+		})
+		yggSvc.Supervise()
+
 	} else {
 		fmt.Print("Connecting to mesh")
 		node.BootstrapPeers()
@@ -134,6 +371,13 @@ EXAMPLES:
 		fmt.Println(" done.")
 	}
 
+	if *private {
+		fmt.Printf("Private:    inbound peerings limited to %d contact key(s)\n", len(allowed))
+		if len(mcast) > 0 {
+			fmt.Println("Warning: LAN discovery links bypass the allow-list — set a multicast Password")
+		}
+	}
+
 	// ── DHT ─────────────────────────────────────────────────────────────────
 	selfID, err := dht.NodeIDFromHex(node.PublicKey())
 	if err != nil {
@@ -142,6 +386,21 @@ EXAMPLES:
 	}
 
 	d := dht.New(node.Address(), selfID, *port)
+	d.SetNetwork(*network)
+	d.SetPrivateKey(node.PrivateKey())
+	d.SetUDP(*udp)
+	d.SetPeersFile(state.PeersFile())
+	if yggSvc != nil {
+		d.SetAdminEndpoint(yggSvc.AdminEndpoint())
+		d.SetTUNStatus(func() interface{} { return yggSvc.Status() })
+		d.SetListeners(listenURIs)
+	} else {
+		// no TUN — the OS can't route 200::/7, so DHT traffic goes
+		// through the embedded core instead
+		d.SetTransport(node.MeshTransport())
+		d.SetAdminEndpoint(node.AdminEndpoint())
+		d.SetListeners(node.Listeners())
+	}
 	if err := d.Start(); err != nil {
 		fmt.Println("Failed to start DHT:", err)
 		os.Exit(1)
@@ -155,13 +414,28 @@ EXAMPLES:
 		}
 	}
 
+	// any Yggdrasil node we can see may run MeshNet — its NodeInfo says
+	// so, which lets a fresh node fill its table with no known addresses
+	discovery := dht.NewDiscovery(d)
+	discovery.Start()
+
+	// pairing or unpairing while running updates who may peer with us
+	contactsDone := make(chan struct{})
+	if *private {
+		update := node.UpdateAllowedKeys
+		if yggSvc != nil {
+			update = yggSvc.UpdateAllowedKeys
+		}
+		go watchContacts(allowed, update, contactsDone)
+	}
+
 	// ── name + announce ──────────────────────────────────────────────────────
 	nodeName := *name
 	if nodeName == "" {
 		nodeName = "node-" + node.PublicKey()[:8]
 	}
 
-	d.StartAPI(nodeName, node.Address(), node.PublicKey())
+	d.StartAPI(cfg.APIAddress, nodeName, node.Address(), node.PublicKey())
 
 	var serviceList []string
 	if *services != "" {
@@ -177,25 +451,43 @@ EXAMPLES:
 		}
 	}
 
-	record, err := dht.CreateRecord(dht.RegisterOptions{
+	// a rotated identity carries proof that it inherited the old key's names
+	succession, err := dht.LoadSuccession(state.SuccessionFile())
+	if err != nil {
+		fmt.Println("Warning:", err)
+	}
+	if succession != nil && succession.NewKey != node.PublicKey() {
+		succession = nil
+	}
+
+	// records number up from the last one signed, so replays of older
+	// ones are refused
+	counter, err := dht.LoadSequenceCounter(state.SequenceFile())
+	if err != nil {
+		fmt.Println("Failed to load record sequence:", err)
+		os.Exit(1)
+	}
+
+	// each announce signs a fresh record from these
+	reannouncer := dht.NewReannouncer(d, dht.RegisterOptions{
 		Name:       nodeName,
 		Address:    node.Address(),
 		Services:   serviceList,
-		GroupKey:   "",
+		GroupKey:   groupKey,
+		Network:    *network,
 		PrivateKey: node.PrivateKey(),
+		TTL:        time.Duration(cfg.RecordTTL),
+		Succession: succession,
+		Counter:    counter,
 	})
-	if err != nil {
-		fmt.Println("Failed to create record:", err)
-		os.Exit(1)
-	}
+	reannouncer.SetInterval(cfg.Reannounce())
+	reannouncer.SetAddressSource(node.Address)
 
 	time.Sleep(1 * time.Second)
 
-	if err := d.Announce(record); err != nil {
+	if err := reannouncer.Announce(); err != nil {
 		fmt.Println("Failed to announce:", err)
 	}
-
-	reannouncer := dht.NewReannouncer(d, record)
 	reannouncer.Start()
 
 	// ── ready ────────────────────────────────────────────────────────────────
@@ -221,6 +513,8 @@ EXAMPLES:
 
 	fmt.Println("\nShutting down...")
 	reannouncer.Stop()
+	discovery.Stop()
+	close(contactsDone)
 	if yggSvc != nil {
 		yggSvc.Stop()
 	}
@@ -234,7 +528,7 @@ EXAMPLES:
 
 func cmdLookup(args []string) {
 	fs := flag.NewFlagSet("lookup", flag.ExitOnError)
-	group := fs.String("group", "", "Group key for private record lookup")
+	group := fs.String("group", "", "Group for private record lookup (name from config or key)")
 	fs.Usage = func() {
 		fmt.Println(`Look up a name on the mesh
 
@@ -254,16 +548,21 @@ EXAMPLES:
 
 	name := fs.Arg(0)
 
-	if !dht.IsNodeRunning() {
+	if !dht.IsNodeRunning(cfg.APIAddress) {
 		fmt.Println("No MeshNet node is running. Start one with: meshnet start")
 		os.Exit(1)
 	}
 
-	url := fmt.Sprintf("http://127.0.0.1:%d/lookup?name=%s&group=%s",
-		dht.APIPort, name, *group)
+	groupKey, err := cfg.GroupKey(*group)
+	if err != nil {
+		fmt.Println("Invalid group:", err)
+		os.Exit(1)
+	}
+
+	lookupURL := apiURL("/lookup?name=%s&group=%s", url.QueryEscape(name), url.QueryEscape(groupKey))
 
 	client := &http.Client{Timeout: 15 * time.Second}
-	resp, err := client.Get(url)
+	resp, err := client.Get(lookupURL)
 	if err != nil {
 		fmt.Println("Lookup failed:", err)
 		os.Exit(1)
@@ -300,14 +599,14 @@ func cmdStatus(args []string) {
 	fs := flag.NewFlagSet("status", flag.ExitOnError)
 	fs.Parse(args)
 
-	if !dht.IsNodeRunning() {
+	if !dht.IsNodeRunning(cfg.APIAddress) {
 		fmt.Println("No MeshNet node is running.")
 		fmt.Println("Start one with: meshnet start")
 		os.Exit(1)
 	}
 
 	client := &http.Client{Timeout: 3 * time.Second}
-	resp, err := client.Get(fmt.Sprintf("http://127.0.0.1:%d/status", dht.APIPort))
+	resp, err := client.Get(apiURL("/status"))
 	if err != nil {
 		fmt.Println("Failed to reach node:", err)
 		os.Exit(1)
@@ -325,25 +624,142 @@ func cmdStatus(args []string) {
 	fmt.Printf("  Name:    %v\n", status["name"])
 	fmt.Printf("  Address: %v\n", status["address"])
 	fmt.Printf("  Key:     %v...\n", str16(status["public_key"]))
+	if network, _ := status["network"].(string); network != "" {
+		fmt.Printf("  Network: %s\n", network)
+	}
 	fmt.Printf("  Peers:   %v\n", status["peers"])
+	if hs, ok := status["handshakes"].(map[string]interface{}); ok {
+		unsigned, _ := hs["rejected_unsigned"].(float64)
+		badSig, _ := hs["rejected_signature"].(float64)
+		wrongAddr, _ := hs["rejected_address"].(float64)
+		if rejected := unsigned + badSig + wrongAddr; rejected > 0 {
+			fmt.Printf("  Rejected handshakes: %.0f (%.0f unsigned, %.0f bad signature, %.0f wrong address)\n",
+				rejected, unsigned, badSig, wrongAddr)
+		}
+	}
 	fmt.Printf("  Records: %v\n", status["records"])
+	if udp, ok := status["udp"].(map[string]interface{}); ok && udp["enabled"] == true {
+		requests, _ := udp["requests"].(float64)
+		resent, _ := udp["retransmits"].(float64)
+		truncated, _ := udp["truncated"].(float64)
+		unanswered, _ := udp["unanswered"].(float64)
+		fmt.Printf("  UDP:     %.0f requests, %.0f resent, %.0f moved to TCP\n",
+			requests, resent, truncated+unanswered)
+	}
+	if cache, ok := status["cache"].(map[string]interface{}); ok {
+		hits, _ := cache["hits"].(float64)
+		missHits, _ := cache["negative_hits"].(float64)
+		misses, _ := cache["misses"].(float64)
+		if asked := hits + missHits + misses; asked > 0 {
+			fmt.Printf("  Cache:   %.0f of %.0f lookups answered (%.0f not found)\n",
+				hits+missHits, asked, missHits)
+		}
+	}
+	if listen, _ := status["listen"].([]interface{}); len(listen) > 0 {
+		fmt.Printf("  Listen:  %v\n", listen[0])
+		for _, l := range listen[1:] {
+			fmt.Printf("           %v\n", l)
+		}
+	}
+	if tun, ok := status["tun"].(map[string]interface{}); ok {
+		printTUNStatus(tun)
+	}
+	if endpoint, _ := status["admin"].(string); endpoint != "" {
+		printMeshStatus(endpoint)
+	}
 	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
 }
 
+// printTUNStatus shows the supervised Yggdrasil subprocess
+func printTUNStatus(tun map[string]interface{}) {
+	line := fmt.Sprint(tun["state"])
+	if since, err := time.Parse(time.RFC3339Nano, fmt.Sprint(tun["since"])); err == nil {
+		line += fmt.Sprintf(" for %s", time.Since(since).Round(time.Second))
+	}
+	fmt.Printf("  TUN:     %s\n", line)
+	if restarts, _ := tun["restarts"].(float64); restarts > 0 {
+		fmt.Printf("  Restarts: %.0f\n", restarts)
+	}
+	if lastErr, _ := tun["last_error"].(string); lastErr != "" {
+		fmt.Printf("  Last error: %s\n", lastErr)
+	}
+}
+
+// printMeshStatus adds what the Yggdrasil layer underneath reports
+func printMeshStatus(endpoint string) {
+	admin, err := yggadmin.Dial(endpoint, 3*time.Second)
+	if err != nil {
+		fmt.Printf("  Mesh:    unavailable (%v)\n", err)
+		return
+	}
+	defer admin.Close()
+
+	self, err := admin.GetSelf()
+	if err != nil {
+		fmt.Printf("  Mesh:    unavailable (%v)\n", err)
+		return
+	}
+	peers, err := admin.GetPeers()
+	if err != nil {
+		fmt.Printf("  Mesh:    unavailable (%v)\n", err)
+		return
+	}
+	sessions, err := admin.GetSessions()
+	if err != nil {
+		fmt.Printf("  Mesh:    unavailable (%v)\n", err)
+		return
+	}
+
+	up := 0
+	for _, p := range peers {
+		if p.Up {
+			up++
+		}
+	}
+
+	fmt.Println()
+	fmt.Printf("  Yggdrasil: %s %s\n", self.BuildName, self.BuildVersion)
+	fmt.Printf("  Mesh peers: %d up of %d\n", up, len(peers))
+	for _, p := range peers {
+		link := "down"
+		if p.Up {
+			link = fmt.Sprintf("up %s", p.Latency.Round(time.Millisecond))
+		}
+		fmt.Printf("    %-40s %s\n", p.URI, link)
+	}
+	fmt.Printf("  Sessions:   %d\n", len(sessions))
+	fmt.Printf("  Routes:     %d\n", self.RoutingEntries)
+
+	// only there when LAN discovery is on
+	if ifaces, err := admin.GetMulticastInterfaces(); err == nil && len(ifaces) > 0 {
+		fmt.Println("  LAN discovery:")
+		for _, m := range ifaces {
+			var modes []string
+			if m.Beacon {
+				modes = append(modes, "beacon")
+			}
+			if m.Listen {
+				modes = append(modes, "listen")
+			}
+			fmt.Printf("    %-16s %-40s %s\n", m.Name, m.Address, strings.Join(modes, ","))
+		}
+	}
+}
+
 // ── peers ────────────────────────────────────────────────────────────────────
 
 func cmdPeers(args []string) {
 	fs := flag.NewFlagSet("peers", flag.ExitOnError)
 	fs.Parse(args)
 
-	if !dht.IsNodeRunning() {
+	if !dht.IsNodeRunning(cfg.APIAddress) {
 		fmt.Println("No MeshNet node is running.")
 		fmt.Println("Start one with: meshnet start")
 		os.Exit(1)
 	}
 
 	client := &http.Client{Timeout: 15 * time.Second}
-	resp, err := client.Get(fmt.Sprintf("http://127.0.0.1:%d/peers", dht.APIPort))
+	resp, err := client.Get(apiURL("/peers"))
 	if err != nil {
 		fmt.Println("Failed to reach node:", err)
 		os.Exit(1)
@@ -371,8 +787,15 @@ func cmdPeers(args []string) {
 			status = "✗"
 			latency = "unreachable"
 		}
-		fmt.Printf("  %s  %s...  [%s]:%d  %s\n",
-			status, p.ID[:12], p.Addr, p.Port, latency)
+		history := ""
+		if !p.LastSeen.IsZero() {
+			history = fmt.Sprintf("  seen %s ago", time.Since(p.LastSeen).Round(time.Second))
+		}
+		if p.Failures > 0 {
+			history += fmt.Sprintf(", %d failed", p.Failures)
+		}
+		fmt.Printf("  %s  %s...  [%s]:%d  %s%s\n",
+			status, p.ID[:12], p.Addr, p.Port, latency, history)
 	}
 }
 
@@ -395,13 +818,13 @@ USAGE:
 			fmt.Println("Usage: meshnet peer add <address>")
 			os.Exit(1)
 		}
-		if !dht.IsNodeRunning() {
+		if !dht.IsNodeRunning(cfg.APIAddress) {
 			fmt.Println("No MeshNet node is running.")
 			os.Exit(1)
 		}
 		client := &http.Client{Timeout: 15 * time.Second}
 		resp, err := client.Post(
-			fmt.Sprintf("http://127.0.0.1:%d/peer?addr=%s", dht.APIPort, args[1]),
+			apiURL("/peer?addr=%s", args[1]),
 			"", nil,
 		)
 		if err != nil {
@@ -416,7 +839,7 @@ USAGE:
 		fmt.Println("Peer added.")
 
 	case "list":
-		data, err := os.ReadFile("peers.json")
+		data, err := os.ReadFile(state.PeersFile())
 		if err != nil {
 			fmt.Println("No saved peers.")
 			return
@@ -424,7 +847,7 @@ USAGE:
 		fmt.Println(string(data))
 
 	case "clear":
-		if err := os.Remove("peers.json"); err != nil {
+		if err := os.Remove(state.PeersFile()); err != nil {
 			fmt.Println("No peers file to clear.")
 			return
 		}
@@ -437,6 +860,68 @@ USAGE:
 	}
 }
 
+// contactsPollInterval is how often contacts.json is checked for changes
+const contactsPollInterval = 5 * time.Second
+
+// allowedKeys is the private-mode allow-list: every contact's key plus
+// the config's AllowedPublicKeys, sorted and without duplicates
+func allowedKeys() ([]string, error) {
+	book, err := pairing.LoadContacts(state.ContactsFile())
+	if err != nil {
+		return nil, err
+	}
+	keys := book.Keys()
+	for _, k := range cfg.AllowedPublicKeys {
+		if !slices.Contains(keys, k) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// watchContacts reapplies the allow-list whenever contacts.json changes
+func watchContacts(current []string, update func([]string) error, done <-chan struct{}) {
+	var lastMod time.Time
+	if info, err := os.Stat(state.ContactsFile()); err == nil {
+		lastMod = info.ModTime()
+	}
+	ticker := time.NewTicker(contactsPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		var mod time.Time
+		if info, err := os.Stat(state.ContactsFile()); err == nil {
+			mod = info.ModTime()
+		}
+		if mod.Equal(lastMod) {
+			continue
+		}
+		lastMod = mod
+
+		keys, err := allowedKeys()
+		if err != nil {
+			fmt.Println("Warning: contacts not reloaded:", err)
+			continue
+		}
+		if slices.Equal(keys, current) {
+			continue
+		}
+		if err := update(keys); err != nil {
+			fmt.Println("Warning: allow-list not updated:", err)
+			continue
+		}
+		current = keys
+		fmt.Printf("[%s] Contacts changed — %d key(s) may peer with us\n", time.Now().Format("15:04:05"), len(keys))
+	}
+}
+
 // ── helpers ───────────────────────────────────────────────────────────────────
 
 func str16(v interface{}) string {
@@ -446,3 +931,28 @@ func str16(v interface{}) string {
 	}
 	return s
 }
+
+// splitList splits a comma-separated flag value, dropping empty entries
+func splitList(v string) []string {
+	var out []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// multicastInterfaces converts the config's LAN discovery settings,
+// adding every interface when --lan is given and none are configured
+func multicastInterfaces(lan bool) []core.MulticastInterface {
+	ifaces := cfg.MulticastInterfaces
+	if lan && len(ifaces) == 0 {
+		ifaces = []config.MulticastInterface{config.LANDiscovery}
+	}
+	var out []core.MulticastInterface
+	for _, m := range ifaces {
+		out = append(out, core.MulticastInterface(m))
+	}
+	return out
+}
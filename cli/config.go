@@ -0,0 +1,120 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"meshnet/config"
+)
+
+// ── config ───────────────────────────────────────────────────────────────────
+
+// cmdConfig handles `meshnet config`. loadErr is whatever went wrong
+// loading the profile's config — reported here rather than in Run so a
+// broken file can still be inspected and replaced.
+func cmdConfig(args []string, loadErr error) {
+	if len(args) == 0 {
+		printConfigHelp()
+		return
+	}
+
+	switch args[0] {
+	case "show":
+		cmdConfigShow(args[1:], loadErr)
+	case "init":
+		cmdConfigInit(args[1:])
+	case "validate":
+		cmdConfigValidate(args[1:])
+	case "help", "--help", "-h":
+		printConfigHelp()
+	default:
+		fmt.Printf("Unknown subcommand: %s\n", args[0])
+		fmt.Println("Use: show, init, or validate")
+		os.Exit(1)
+	}
+}
+
+func printConfigHelp() {
+	fmt.Println(`Manage the node config file
+
+USAGE:
+  meshnet config show              Print the effective configuration
+  meshnet config init              Write a commented config with the defaults
+  meshnet config validate [file]   Check a config file for errors
+
+The config is HJSON, like Yggdrasil's. It lives at meshnet.conf in the
+profile directory unless --config is given. Flags to 'meshnet start'
+override the values in the file.`)
+}
+
+func cmdConfigShow(args []string, loadErr error) {
+	fs := flag.NewFlagSet("config show", flag.ExitOnError)
+	fs.Parse(args)
+
+	if loadErr != nil {
+		fmt.Println("Failed to load config:", loadErr)
+		os.Exit(1)
+	}
+
+	data, err := cfg.Marshal()
+	if err != nil {
+		fmt.Println("Failed to encode config:", err)
+		os.Exit(1)
+	}
+
+	if _, err := os.Stat(configPath); err == nil {
+		fmt.Println("# from", configPath)
+	} else {
+		fmt.Println("# built-in defaults — no file at", configPath)
+	}
+	fmt.Println(string(data))
+}
+
+func cmdConfigInit(args []string) {
+	fs := flag.NewFlagSet("config init", flag.ExitOnError)
+	force := fs.Bool("force", false, "Overwrite an existing config file")
+	fs.Parse(args)
+
+	if _, err := os.Stat(configPath); err == nil && !*force {
+		fmt.Printf("%s already exists. Use --force to overwrite it.\n", configPath)
+		os.Exit(1)
+	}
+
+	// start from the defaults for this profile, not whatever the old file said
+	base := config.Default()
+	base.DHTPort = state.DHTPort
+	base.APIAddress = fmt.Sprintf("127.0.0.1:%d", state.APIPort)
+
+	if err := os.WriteFile(configPath, config.Template(base), 0644); err != nil {
+		fmt.Println("Failed to write config:", err)
+		os.Exit(1)
+	}
+	fmt.Println("Config written to", configPath)
+}
+
+func cmdConfigValidate(args []string) {
+	fs := flag.NewFlagSet("config validate", flag.ExitOnError)
+	fs.Parse(args)
+
+	path := configPath
+	if fs.NArg() > 0 {
+		path = fs.Arg(0)
+	}
+
+	c := config.Default()
+	if err := c.LoadFile(path); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			fmt.Println("No config file at", path)
+		} else {
+			fmt.Println("Invalid:", err)
+		}
+		os.Exit(1)
+	}
+	if err := c.Validate(); err != nil {
+		fmt.Printf("Invalid: %s: %v\n", path, err)
+		os.Exit(1)
+	}
+	fmt.Println("OK:", path)
+}
@@ -0,0 +1,126 @@
+package cli
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"meshnet/core"
+	"meshnet/pairing"
+)
+
+// ── contacts ─────────────────────────────────────────────────────────────────
+
+func cmdContacts(args []string) {
+	if len(args) == 0 {
+		printContactsHelp()
+		return
+	}
+
+	switch args[0] {
+	case "list":
+		cmdContactsList()
+	case "add":
+		cmdContactsAdd(args[1:])
+	case "remove":
+		cmdContactsRemove(args[1:])
+	case "help", "--help", "-h":
+		printContactsHelp()
+	default:
+		fmt.Printf("Unknown subcommand: %s\n", args[0])
+		fmt.Println("Use: list, add, or remove")
+		os.Exit(1)
+	}
+}
+
+func printContactsHelp() {
+	fmt.Println(`Manage paired contacts
+
+In private mode (meshnet start --private) only contacts and the config's
+AllowedPublicKeys may peer with this node. A running node picks up
+changes within a few seconds.
+
+USAGE:
+  meshnet contacts list                  List contacts
+  meshnet contacts add <name> <key>      Add a contact by public key (hex)
+  meshnet contacts remove <name|key>     Remove a contact`)
+}
+
+func loadContactBook() *pairing.ContactBook {
+	book, err := pairing.LoadContacts(state.ContactsFile())
+	if err != nil {
+		fmt.Println("Failed to load contacts:", err)
+		os.Exit(1)
+	}
+	return book
+}
+
+func cmdContactsList() {
+	contacts := loadContactBook().All()
+	if len(contacts) == 0 {
+		fmt.Println("No contacts.")
+		return
+	}
+	sort.Slice(contacts, func(i, j int) bool { return contacts[i].Name < contacts[j].Name })
+
+	fmt.Printf("Contacts (%d):\n", len(contacts))
+	for _, c := range contacts {
+		fmt.Printf("  %-16s %s...  %s\n", c.Name, str16(c.PublicKey), c.Address)
+	}
+}
+
+func cmdContactsAdd(args []string) {
+	if len(args) != 2 {
+		fmt.Println("Usage: meshnet contacts add <name> <key>")
+		os.Exit(1)
+	}
+	name, keyHex := args[0], args[1]
+
+	key, err := hex.DecodeString(keyHex)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		fmt.Println("Invalid key: expected 64 hex characters")
+		os.Exit(1)
+	}
+
+	book := loadContactBook()
+	if c := book.FindByName(name); c != nil && c.PublicKey != keyHex {
+		fmt.Printf("A contact named %q already exists with another key.\n", name)
+		os.Exit(1)
+	}
+	book.Add(pairing.Contact{
+		Name:      name,
+		Address:   core.AddressForKey(ed25519.PublicKey(key)),
+		PublicKey: keyHex,
+		PairedAt:  time.Now(),
+	})
+	if err := book.Save(); err != nil {
+		fmt.Println("Failed to save contacts:", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Added %s.\n", name)
+}
+
+func cmdContactsRemove(args []string) {
+	if len(args) != 1 {
+		fmt.Println("Usage: meshnet contacts remove <name|key>")
+		os.Exit(1)
+	}
+
+	book := loadContactBook()
+	key := args[0]
+	if c := book.FindByName(args[0]); c != nil {
+		key = c.PublicKey
+	}
+	if !book.Remove(key) {
+		fmt.Printf("No contact %q.\n", args[0])
+		os.Exit(1)
+	}
+	if err := book.Save(); err != nil {
+		fmt.Println("Failed to save contacts:", err)
+		os.Exit(1)
+	}
+	fmt.Println("Removed.")
+}
@@ -0,0 +1,602 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"os/signal"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+
+	"meshnet/core"
+	"meshnet/dht"
+)
+
+// ── identity ─────────────────────────────────────────────────────────────────
+
+func cmdIdentity(args []string) {
+	if len(args) == 0 {
+		printIdentityHelp()
+		return
+	}
+
+	switch args[0] {
+	case "encrypt":
+		cmdIdentityEncrypt(args[1:])
+	case "decrypt":
+		cmdIdentityDecrypt(args[1:])
+	case "change-passphrase":
+		cmdIdentityChangePassphrase(args[1:])
+	case "export":
+		cmdIdentityExport(args[1:])
+	case "restore":
+		cmdIdentityRestore(args[1:])
+	case "rotate":
+		cmdIdentityRotate(args[1:])
+	case "generate":
+		cmdIdentityGenerate(args[1:])
+	case "help", "--help", "-h":
+		printIdentityHelp()
+	default:
+		fmt.Printf("Unknown subcommand: %s\n", args[0])
+		fmt.Println("Use: encrypt, decrypt, change-passphrase, export, restore, rotate, or generate")
+		os.Exit(1)
+	}
+}
+
+func printIdentityHelp() {
+	fmt.Println(`Manage the node identity
+
+USAGE:
+  meshnet identity encrypt              Protect identity.json with a passphrase
+  meshnet identity decrypt              Store identity.json as plaintext
+  meshnet identity change-passphrase    Re-encrypt with a new passphrase
+  meshnet identity export --mnemonic    Print a 24-word backup of the key
+  meshnet identity restore              Rebuild identity.json from the words
+  meshnet identity rotate               Move your names to a fresh key
+  meshnet identity generate             Search for a key with a chosen address
+
+Passphrases are read from --passphrase-fd, --passphrase-file,
+$MESHNET_PASSPHRASE, or an interactive prompt — in that order.`)
+}
+
+// identityFlags registers the flags shared by identity subcommands
+type identityFlags struct {
+	identity       *string
+	passphraseFile *string
+	passphraseFD   *int
+}
+
+func addIdentityFlags(fs *flag.FlagSet) identityFlags {
+	return identityFlags{
+		identity:       fs.String("identity", state.IdentityFile(), "Path to identity file"),
+		passphraseFile: fs.String("passphrase-file", "", "Read passphrase from first line of file"),
+		passphraseFD:   fs.Int("passphrase-fd", -1, "Read passphrase from file descriptor"),
+	}
+}
+
+func (f identityFlags) source() core.PassphraseSource {
+	src, err := core.DefaultPassphraseSource(*f.passphraseFile, *f.passphraseFD)
+	if err != nil {
+		fmt.Println("Failed to read passphrase:", err)
+		os.Exit(1)
+	}
+	return src
+}
+
+func cmdIdentityEncrypt(args []string) {
+	fs := flag.NewFlagSet("identity encrypt", flag.ExitOnError)
+	flags := addIdentityFlags(fs)
+	fs.Parse(args)
+
+	identity := readIdentityOrExit(*flags.identity)
+	if identity.IsEncrypted() {
+		fmt.Println("Identity is already encrypted. Use 'meshnet identity change-passphrase'.")
+		os.Exit(1)
+	}
+
+	privKey, err := identity.Unlock(nil)
+	if err != nil {
+		fmt.Println("Failed to load identity:", err)
+		os.Exit(1)
+	}
+
+	passphrase := newPassphraseOrExit(flags.source())
+	if err := core.SaveIdentity(*flags.identity, privKey, passphrase); err != nil {
+		fmt.Println("Failed to encrypt identity:", err)
+		os.Exit(1)
+	}
+	fmt.Println("Identity encrypted.")
+}
+
+func cmdIdentityDecrypt(args []string) {
+	fs := flag.NewFlagSet("identity decrypt", flag.ExitOnError)
+	flags := addIdentityFlags(fs)
+	fs.Parse(args)
+
+	identity := readIdentityOrExit(*flags.identity)
+	if !identity.IsEncrypted() {
+		fmt.Println("Identity is not encrypted.")
+		return
+	}
+
+	privKey := unlockOrExit(identity, flags.source())
+	if err := core.SaveIdentity(*flags.identity, privKey, nil); err != nil {
+		fmt.Println("Failed to decrypt identity:", err)
+		os.Exit(1)
+	}
+	fmt.Println("Identity decrypted. Anyone who can read the file can now use your key.")
+}
+
+func cmdIdentityChangePassphrase(args []string) {
+	fs := flag.NewFlagSet("identity change-passphrase", flag.ExitOnError)
+	flags := addIdentityFlags(fs)
+	newFile := fs.String("new-passphrase-file", "", "Read new passphrase from first line of file")
+	fs.Parse(args)
+
+	identity := readIdentityOrExit(*flags.identity)
+	if !identity.IsEncrypted() {
+		fmt.Println("Identity is not encrypted. Use 'meshnet identity encrypt'.")
+		os.Exit(1)
+	}
+
+	privKey := unlockOrExit(identity, flags.source())
+
+	// the new passphrase always comes from the terminal or its own file
+	// so $MESHNET_PASSPHRASE only ever answers for the current one
+	var newSource core.PassphraseSource = core.TerminalPassphrase{}
+	if *newFile != "" {
+		src, err := core.PassphraseFromFile(*newFile)
+		if err != nil {
+			fmt.Println("Failed to read new passphrase:", err)
+			os.Exit(1)
+		}
+		newSource = src
+	}
+
+	passphrase := newPassphraseOrExit(newSource)
+	if err := core.SaveIdentity(*flags.identity, privKey, passphrase); err != nil {
+		fmt.Println("Failed to re-encrypt identity:", err)
+		os.Exit(1)
+	}
+	fmt.Println("Passphrase changed.")
+}
+
+func cmdIdentityExport(args []string) {
+	fs := flag.NewFlagSet("identity export", flag.ExitOnError)
+	flags := addIdentityFlags(fs)
+	mnemonic := fs.Bool("mnemonic", false, "Export as a 24-word mnemonic")
+	fs.Parse(args)
+
+	if !*mnemonic {
+		fmt.Println("Usage: meshnet identity export --mnemonic")
+		os.Exit(1)
+	}
+
+	identity := readIdentityOrExit(*flags.identity)
+	privKey := unlockOrExit(identity, flags.source())
+
+	words, err := core.SeedToMnemonic(privKey.Seed())
+	if err != nil {
+		fmt.Println("Failed to encode identity:", err)
+		os.Exit(1)
+	}
+
+	fmt.Println()
+	fmt.Println("Write these words down and keep them offline.")
+	fmt.Println("Anyone with them owns your address and names.")
+	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+	list := strings.Fields(words)
+	for i := 0; i < len(list); i += 4 {
+		fmt.Printf("  %2d. %-10s %2d. %-10s %2d. %-10s %2d. %s\n",
+			i+1, list[i], i+2, list[i+1], i+3, list[i+2], i+4, list[i+3])
+	}
+	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+	fmt.Printf("  Address: %s\n", core.AddressForKey(privKey.Public().(ed25519.PublicKey)))
+}
+
+func cmdIdentityRestore(args []string) {
+	fs := flag.NewFlagSet("identity restore", flag.ExitOnError)
+	flags := addIdentityFlags(fs)
+	wordsFile := fs.String("mnemonic-file", "", "Read the words from a file instead of stdin")
+	encrypt := fs.Bool("encrypt", false, "Encrypt the restored identity with a passphrase")
+	force := fs.Bool("force", false, "Overwrite an existing identity file")
+	fs.Parse(args)
+
+	if _, err := os.Stat(*flags.identity); err == nil && !*force {
+		fmt.Printf("%s already exists. Use --force to overwrite it.\n", *flags.identity)
+		os.Exit(1)
+	}
+
+	var words string
+	if *wordsFile != "" {
+		data, err := os.ReadFile(*wordsFile)
+		if err != nil {
+			fmt.Println("Failed to read words:", err)
+			os.Exit(1)
+		}
+		words = string(data)
+	} else {
+		fmt.Println("Enter your 24 words, separated by spaces:")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			fmt.Println("Failed to read words:", err)
+			os.Exit(1)
+		}
+		words = line
+	}
+
+	seed, err := core.MnemonicToSeed(words)
+	if err != nil {
+		fmt.Println("Invalid mnemonic:", err)
+		os.Exit(1)
+	}
+	privKey := ed25519.NewKeyFromSeed(seed)
+	pubKey := privKey.Public().(ed25519.PublicKey)
+
+	var passphrase []byte
+	if *encrypt {
+		passphrase = newPassphraseOrExit(flags.source())
+	}
+
+	if err := core.SaveIdentity(*flags.identity, privKey, passphrase); err != nil {
+		fmt.Println("Failed to save identity:", err)
+		os.Exit(1)
+	}
+
+	fmt.Println()
+	fmt.Println("Identity restored to", *flags.identity)
+	fmt.Printf("  Address: %s\n", core.AddressForKey(pubKey))
+	fmt.Printf("  Node ID: %s\n", dht.NodeIDFromPublicKey(pubKey))
+}
+
+func cmdIdentityRotate(args []string) {
+	fs := flag.NewFlagSet("identity rotate", flag.ExitOnError)
+	flags := addIdentityFlags(fs)
+	fs.Parse(args)
+
+	if !dht.IsNodeRunning(cfg.APIAddress) {
+		fmt.Println("No MeshNet node is running. Rotation re-announces your names through it.")
+		fmt.Println("Start one with: meshnet start")
+		os.Exit(1)
+	}
+
+	identity := readIdentityOrExit(*flags.identity)
+
+	// keep the passphrase so the new identity is protected the same way
+	var passphrase []byte
+	var oldKey ed25519.PrivateKey
+	var err error
+	if identity.IsEncrypted() {
+		passphrase, err = flags.source().Passphrase("Identity passphrase: ")
+		if err != nil {
+			fmt.Println("Failed to read passphrase:", err)
+			os.Exit(1)
+		}
+		oldKey, err = identity.Decrypt(passphrase)
+	} else {
+		oldKey, err = identity.Unlock(nil)
+	}
+	if err != nil {
+		fmt.Println("Failed to unlock identity:", err)
+		os.Exit(1)
+	}
+	oldPub := hex.EncodeToString(oldKey.Public().(ed25519.PublicKey))
+
+	// the node may run on another key, e.g. one from a Yggdrasil config —
+	// handing over this one would move nothing
+	running, err := fetchNodeStatus()
+	if err != nil {
+		fmt.Println("Failed to reach node:", err)
+		os.Exit(1)
+	}
+	if running.PublicKey != oldPub {
+		fmt.Printf("The running node uses key %s..., not the %s... in %s.\n",
+			str16(running.PublicKey), oldPub[:16], *flags.identity)
+		fmt.Println("Point --identity at the node's identity file, or start the node on this one first.")
+		os.Exit(1)
+	}
+
+	owned, err := fetchOwnedRecords(oldPub)
+	if err != nil {
+		fmt.Println("Failed to list owned names:", err)
+		os.Exit(1)
+	}
+
+	newPub, newKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		fmt.Println("Failed to generate key:", err)
+		os.Exit(1)
+	}
+
+	succession, err := dht.CreateSuccession(oldKey, newPub, time.Now(), running.Network)
+	if err != nil {
+		fmt.Println("Failed to sign succession:", err)
+		os.Exit(1)
+	}
+
+	newAddr := core.AddressForKey(newPub)
+	var fresh []dht.Record
+	for _, rec := range owned {
+		record, err := dht.CreateRecord(dht.RegisterOptions{
+			Name:       rec.Name,
+			Address:    newAddr,
+			Services:   rec.Services,
+			GroupKey:   rec.GroupKey,
+			Network:    rec.Network,
+			PrivateKey: newKey,
+			Succession: &succession,
+		})
+		if err != nil {
+			fmt.Printf("Failed to create record for %q: %v\n", rec.Name, err)
+			os.Exit(1)
+		}
+		fresh = append(fresh, record)
+	}
+
+	// persist before announcing — if the announce fails, the restarted
+	// node still carries the succession and moves the names itself. The
+	// succession goes first and comes back out if the identity can't be
+	// swapped, so a failure leaves both files as they were.
+	successionFile := state.SuccessionFile()
+	prevSuccession, prevErr := os.ReadFile(successionFile)
+	restoreSuccession := func() {
+		if prevErr == nil {
+			os.WriteFile(successionFile, prevSuccession, 0644)
+		} else {
+			os.Remove(successionFile)
+		}
+	}
+	if err := dht.SaveSuccession(successionFile, succession); err != nil {
+		fmt.Println("Failed to save succession:", err)
+		os.Exit(1)
+	}
+	backup := fmt.Sprintf("%s.rotated-%d", *flags.identity, time.Now().Unix())
+	if err := os.Rename(*flags.identity, backup); err != nil {
+		fmt.Println("Failed to back up old identity:", err)
+		restoreSuccession()
+		os.Exit(1)
+	}
+	if err := core.SaveIdentity(*flags.identity, newKey, passphrase); err != nil {
+		fmt.Println("Failed to save new identity:", err)
+		os.Rename(backup, *flags.identity)
+		restoreSuccession()
+		os.Exit(1)
+	}
+
+	fmt.Println("New identity saved. Old identity kept at", backup)
+	fmt.Printf("  Old key: %s...\n", oldPub[:16])
+	fmt.Printf("  New key: %s...\n", succession.NewKey[:16])
+	fmt.Printf("  Address: %s\n", newAddr)
+
+	if len(fresh) > 0 {
+		if err := postAnnounce(fresh); err != nil {
+			fmt.Println("Warning: re-announce failed:", err)
+			fmt.Println("Names will move when the node restarts with the new identity.")
+		} else {
+			for _, rec := range fresh {
+				fmt.Printf("  ✓ %s\n", rec.Name)
+			}
+		}
+	}
+
+	fmt.Println()
+	fmt.Println("Restart the node to come up under the new identity.")
+}
+
+// ── identity helpers ─────────────────────────────────────────────────────────
+
+// fetchOwnedRecords asks the running node for the records signed by owner
+func fetchOwnedRecords(owner string) ([]dht.Record, error) {
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(apiURL("/records?owner=%s", owner))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var records []dht.Record
+	if err := json.NewDecoder(resp.Body).Decode(&records); err != nil {
+		return nil, fmt.Errorf("failed to decode records: %w", err)
+	}
+	return records, nil
+}
+
+// nodeStatus is the part of the running node's /status rotation checks
+type nodeStatus struct {
+	PublicKey string `json:"public_key"`
+	Network   string `json:"network"`
+}
+
+// fetchNodeStatus asks the running node which key and network it runs on
+func fetchNodeStatus() (nodeStatus, error) {
+	client := &http.Client{Timeout: 3 * time.Second}
+	resp, err := client.Get(apiURL("/status"))
+	if err != nil {
+		return nodeStatus{}, err
+	}
+	defer resp.Body.Close()
+
+	var status nodeStatus
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return nodeStatus{}, fmt.Errorf("failed to decode status: %w", err)
+	}
+	return status, nil
+}
+
+// postAnnounce hands signed records to the running node for announcement
+func postAnnounce(records []dht.Record) error {
+	body, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: 60 * time.Second}
+	resp, err := client.Post(apiURL("/announce"), "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("status %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+func cmdIdentityGenerate(args []string) {
+	fs := flag.NewFlagSet("identity generate", flag.ExitOnError)
+	flags := addIdentityFlags(fs)
+	prefix := fs.String("prefix", "", "Address prefix to search for, x matches any digit e.g. 2xx:abcd")
+	minStrength := fs.Int("min-strength", 0, "Minimum address strength (the xx in 2xx)")
+	workers := fs.Int("workers", runtime.NumCPU(), "Number of search goroutines")
+	force := fs.Bool("force", false, "Overwrite an existing identity file")
+	fs.Usage = func() {
+		fmt.Println(`Search for a key whose Yggdrasil address has a chosen prefix or strength
+
+USAGE:
+  meshnet identity generate [flags]
+
+FLAGS:`)
+		fs.PrintDefaults()
+		fmt.Println(`
+EXAMPLES:
+  meshnet identity generate --min-strength 12
+  meshnet identity generate --prefix 2xx:cafe
+  meshnet identity generate --prefix 210:beef --force
+
+Each hex digit of prefix makes the search about 16 times longer,
+each point of strength about twice as long.`)
+	}
+	fs.Parse(args)
+
+	if _, err := os.Stat(*flags.identity); err == nil && !*force {
+		fmt.Printf("%s already exists. Use --force to overwrite it.\n", *flags.identity)
+		os.Exit(1)
+	}
+
+	criteria := core.VanityCriteria{Prefix: *prefix, MinStrength: *minStrength}
+	if err := criteria.Validate(); err != nil {
+		fmt.Println("Invalid search:", err)
+		os.Exit(1)
+	}
+
+	// resolve the passphrase before the search, not after minutes of work
+	pass := flags.source()
+
+	fmt.Printf("Searching with %d workers — about %s keys expected\n",
+		*workers, humanCount(criteria.ExpectedAttempts()))
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	start := time.Now()
+	privKey, err := core.SearchVanityKey(ctx, criteria, *workers, func(p core.VanityProgress) {
+		eta := "any moment"
+		if p.ETA > 0 {
+			eta = p.ETA.Round(time.Second).String()
+		}
+		fmt.Printf("\r  %s keys  %s/s  %3.0f%%  ETA %s    ",
+			humanCount(float64(p.Attempts)), humanCount(p.Rate), p.Chance*100, eta)
+	})
+	fmt.Println()
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			fmt.Println("Search cancelled.")
+		} else {
+			fmt.Println("Search failed:", err)
+		}
+		os.Exit(1)
+	}
+
+	if err := core.SaveNewIdentity(*flags.identity, privKey, pass); err != nil {
+		fmt.Println("Failed to save identity:", err)
+		os.Exit(1)
+	}
+
+	pubKey := privKey.Public().(ed25519.PublicKey)
+	fmt.Printf("Found in %s\n\n", time.Since(start).Round(time.Second))
+	fmt.Println("Identity saved to", *flags.identity)
+	fmt.Printf("  Address:  %s\n", core.AddressForKey(pubKey))
+	fmt.Printf("  Strength: %d\n", core.AddressStrength(pubKey))
+	fmt.Printf("  Node ID:  %s\n", dht.NodeIDFromPublicKey(pubKey))
+	if identity, err := core.ReadIdentity(*flags.identity); err == nil && !identity.IsEncrypted() {
+		fmt.Println("Warning: identity file is not encrypted — run 'meshnet identity encrypt'")
+	}
+}
+
+// humanCount formats a count with a k/M/G suffix
+func humanCount(n float64) string {
+	switch {
+	case math.IsInf(n, 0):
+		return "∞"
+	case n >= 1e9:
+		return fmt.Sprintf("%.1fG", n/1e9)
+	case n >= 1e6:
+		return fmt.Sprintf("%.1fM", n/1e6)
+	case n >= 1e3:
+		return fmt.Sprintf("%.1fk", n/1e3)
+	}
+	return fmt.Sprintf("%.0f", n)
+}
+
+func readIdentityOrExit(path string) *core.Identity {
+	identity, err := core.ReadIdentity(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			fmt.Printf("No identity file at %s\n", path)
+		} else {
+			fmt.Println("Failed to read identity:", err)
+		}
+		os.Exit(1)
+	}
+	return identity
+}
+
+func unlockOrExit(identity *core.Identity, pass core.PassphraseSource) ed25519.PrivateKey {
+	privKey, err := identity.Unlock(pass)
+	if err != nil {
+		fmt.Println("Failed to unlock identity:", err)
+		os.Exit(1)
+	}
+	return privKey
+}
+
+// newPassphraseOrExit reads a new passphrase, asking twice when interactive
+func newPassphraseOrExit(src core.PassphraseSource) []byte {
+	passphrase, err := src.Passphrase("New passphrase: ")
+	if err != nil {
+		fmt.Println("Failed to read passphrase:", err)
+		os.Exit(1)
+	}
+	if len(passphrase) == 0 {
+		fmt.Println("Passphrase cannot be empty.")
+		os.Exit(1)
+	}
+
+	if src.Interactive() {
+		confirm, err := src.Passphrase("Confirm passphrase: ")
+		if err != nil {
+			fmt.Println("Failed to read passphrase:", err)
+			os.Exit(1)
+		}
+		if !bytes.Equal(passphrase, confirm) {
+			fmt.Println("Passphrases do not match.")
+			os.Exit(1)
+		}
+	}
+	return passphrase
+}
@@ -0,0 +1,310 @@
+package config
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"meshnet/hjson"
+)
+
+// FileName is the config file kept in each profile's state directory
+const FileName = "meshnet.conf"
+
+// DefaultPeers are the public Yggdrasil peers a fresh node connects to
+var DefaultPeers = []string{
+	"tls://62.210.85.80:39575",  // france
+	"tls://51.15.204.214:54321", // france
+	"tls://n.ygg.yt:443",        // germany
+	"tls://ygg7.mk16.de:1338?key=000000086278b5f3ba1eb63acb5b7f6e406f04ce83990dee9c07f49011e375ae", // austria
+	"tls://syd.joel.net.au:8443", // australia
+	"tls://95.217.35.92:1337",    // finland
+	"tls://37.205.14.171:993",    // czechia
+}
+
+// Config is everything about a node that can be set without code changes.
+// Keys follow Yggdrasil's config style so one editor session covers both.
+type Config struct {
+	// Yggdrasil peer URIs to connect to
+	Peers []string `json:"Peers"`
+	// Yggdrasil URIs to accept incoming peerings on e.g. tls://[::]:9443
+	Listen []string `json:"Listen"`
+	// MulticastInterfaces enables LAN peer discovery, empty means off
+	MulticastInterfaces []MulticastInterface `json:"MulticastInterfaces"`
+	// Private accepts inbound peerings only from contacts and AllowedPublicKeys
+	Private bool `json:"Private"`
+	// AllowedPublicKeys are hex keys allowed to peer in private mode besides contacts
+	AllowedPublicKeys []string `json:"AllowedPublicKeys"`
+
+	// Network separates MeshNet deployments, empty means the public network
+	Network string `json:"Network"`
+	// DHTPort is the TCP port the DHT listens on over the mesh
+	DHTPort int `json:"DHTPort"`
+	// DHTUDP also runs lookups over UDP on DHTPort with peers that take it
+	DHTUDP bool `json:"DHTUDP"`
+	// APIAddress is where the local CLI API listens — must be loopback
+	APIAddress string `json:"APIAddress"`
+
+	// Name registered on the mesh, empty means node-<key prefix>
+	Name string `json:"Name"`
+	// Services advertised in our record e.g. ssh:22
+	Services []string `json:"Services"`
+	// Groups maps friendly names to group keys, usable wherever --group is
+	Groups map[string]string `json:"Groups"`
+	// Group the node's own record is registered in, empty means public
+	Group string `json:"Group"`
+
+	// RecordTTL is how long our record stays valid on other nodes
+	RecordTTL Duration `json:"RecordTTL"`
+	// ReannounceInterval must be shorter than RecordTTL, 0 means 3/4 of it
+	ReannounceInterval Duration `json:"ReannounceInterval"`
+
+	Log LogConfig `json:"Log"`
+}
+
+// MulticastInterface picks interfaces for LAN discovery, as in Yggdrasil
+type MulticastInterface struct {
+	// Regex matches interface names e.g. "eth.*" or ".*"
+	Regex string `json:"Regex"`
+	// Beacon advertises us on the interface, Listen peers with beacons heard
+	Beacon bool `json:"Beacon"`
+	Listen bool `json:"Listen"`
+	// Port for the peering listener, 0 means random
+	Port uint16 `json:"Port"`
+	// Priority breaks ties between links to the same peer, lower wins
+	Priority uint8 `json:"Priority"`
+	// Password restricts peering to nodes with the same password
+	Password string `json:"Password"`
+}
+
+// LANDiscovery is the MulticastInterfaces entry --lan adds: every interface
+var LANDiscovery = MulticastInterface{Regex: ".*", Beacon: true, Listen: true}
+
+// LogConfig controls the embedded Yggdrasil logger
+type LogConfig struct {
+	// Level is one of error, warn, info, debug
+	Level string `json:"Level"`
+	// File to log to instead of stderr, relative to the state directory
+	File string `json:"File"`
+}
+
+// Default returns the built-in configuration
+func Default() *Config {
+	return &Config{
+		Peers:               append([]string(nil), DefaultPeers...),
+		Listen:              []string{},
+		MulticastInterfaces: []MulticastInterface{},
+		AllowedPublicKeys:   []string{},
+		DHTPort:             9001,
+		DHTUDP:              true,
+		APIAddress:          "127.0.0.1:9099",
+		Services:            []string{},
+		Groups:              map[string]string{},
+		RecordTTL:           Duration(time.Hour),
+		Log:                 LogConfig{Level: "warn"},
+	}
+}
+
+// LoadFile overlays the settings in path onto c — keys missing from
+// the file keep their current value. Returns an error wrapping
+// os.ErrNotExist if there is no file.
+func (c *Config) LoadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return fmt.Errorf("failed to read config: %w", err)
+	}
+	if err := c.Parse(data); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// Parse overlays an HJSON document onto c
+func (c *Config) Parse(data []byte) error {
+	doc, err := hjson.Parse(data)
+	if err != nil {
+		return err
+	}
+	if _, ok := doc.(map[string]interface{}); !ok {
+		return fmt.Errorf("top level is not an object")
+	}
+
+	// round-trip through JSON so the struct tags do the field mapping
+	// and typos are reported instead of silently ignored
+	normalized, err := json.Marshal(doc)
+	if err != nil {
+		return fmt.Errorf("failed to normalize config: %w", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(normalized))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(c); err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+	return nil
+}
+
+// Validate checks the settings are usable
+func (c *Config) Validate() error {
+	for _, p := range c.Peers {
+		if err := checkURI(p); err != nil {
+			return fmt.Errorf("Peers: %w", err)
+		}
+	}
+	for _, l := range c.Listen {
+		if err := checkURI(l); err != nil {
+			return fmt.Errorf("Listen: %w", err)
+		}
+	}
+
+	for _, m := range c.MulticastInterfaces {
+		if m.Regex == "" {
+			return fmt.Errorf("MulticastInterfaces: Regex is required")
+		}
+		if _, err := regexp.Compile(m.Regex); err != nil {
+			return fmt.Errorf("MulticastInterfaces: %w", err)
+		}
+		if !m.Beacon && !m.Listen {
+			return fmt.Errorf("MulticastInterfaces: %q has neither Beacon nor Listen set", m.Regex)
+		}
+	}
+
+	for _, k := range c.AllowedPublicKeys {
+		if b, err := hex.DecodeString(k); err != nil || len(b) != 32 {
+			return fmt.Errorf("AllowedPublicKeys: %q is not a 64 character hex key", k)
+		}
+	}
+
+	if err := CheckNetwork(c.Network); err != nil {
+		return fmt.Errorf("Network: %w", err)
+	}
+	if c.DHTPort < 1 || c.DHTPort > 65535 {
+		return fmt.Errorf("DHTPort %d out of range", c.DHTPort)
+	}
+
+	host, port, err := net.SplitHostPort(c.APIAddress)
+	if err != nil {
+		return fmt.Errorf("APIAddress: %w", err)
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return fmt.Errorf("APIAddress must be a loopback address, got %q", host)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("APIAddress port %q out of range", port)
+	}
+
+	for _, s := range c.Services {
+		if name, port, ok := strings.Cut(s, ":"); !ok || name == "" || port == "" {
+			return fmt.Errorf("Services: %q must look like name:port", s)
+		}
+	}
+	if c.Group != "" {
+		if _, err := c.GroupKey(c.Group); err != nil {
+			return fmt.Errorf("Group: %w", err)
+		}
+	}
+
+	if c.RecordTTL < Duration(time.Minute) {
+		return fmt.Errorf("RecordTTL must be at least 1m")
+	}
+	if c.ReannounceInterval != 0 && c.ReannounceInterval >= c.RecordTTL {
+		return fmt.Errorf("ReannounceInterval %s must be shorter than RecordTTL %s",
+			c.ReannounceInterval, c.RecordTTL)
+	}
+
+	switch c.Log.Level {
+	case "error", "warn", "info", "debug":
+	default:
+		return fmt.Errorf("Log.Level must be error, warn, info or debug")
+	}
+	return nil
+}
+
+var networkPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,63}$`)
+
+// CheckNetwork validates a network ID — lowercase letters, digits and
+// . _ - up to 64 characters, or empty for the public network
+func CheckNetwork(id string) error {
+	if id != "" && !networkPattern.MatchString(id) {
+		return fmt.Errorf("%q must be up to 64 lowercase letters, digits, '.', '_' or '-'", id)
+	}
+	return nil
+}
+
+// GroupKey resolves a --group value: a name from Groups, or the key itself
+func (c *Config) GroupKey(group string) (string, error) {
+	if key, ok := c.Groups[group]; ok {
+		if key == "" {
+			return "", fmt.Errorf("group %q has an empty key", group)
+		}
+		return key, nil
+	}
+	return group, nil
+}
+
+// Reannounce returns the effective re-announce interval
+func (c *Config) Reannounce() time.Duration {
+	if c.ReannounceInterval != 0 {
+		return time.Duration(c.ReannounceInterval)
+	}
+	return time.Duration(c.RecordTTL) * 3 / 4
+}
+
+// Marshal renders the config as an indented document that Parse accepts
+func (c *Config) Marshal() ([]byte, error) {
+	return json.MarshalIndent(c, "", "  ")
+}
+
+func checkURI(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return fmt.Errorf("invalid URI %q: %w", s, err)
+	}
+	switch u.Scheme {
+	case "tcp", "tls", "quic", "ws", "wss", "socks", "sockstls", "unix":
+	default:
+		return fmt.Errorf("URI %q has unsupported scheme %q", s, u.Scheme)
+	}
+	return nil
+}
+
+// Duration is a time.Duration written as "45m" or "1h30m" in the file
+type Duration time.Duration
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		// plain numbers are seconds
+		var secs float64
+		if err := json.Unmarshal(data, &secs); err != nil {
+			return fmt.Errorf("duration must be a string like \"45m\" or a number of seconds")
+		}
+		*d = Duration(secs * float64(time.Second))
+		return nil
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
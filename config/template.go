@@ -0,0 +1,40 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Template renders c as a commented HJSON file for `meshnet config init`
+func Template(c *Config) []byte {
+	var b strings.Builder
+	field := func(comment, key string, value interface{}) {
+		for _, line := range strings.Split(comment, "\n") {
+			fmt.Fprintf(&b, "  # %s\n", line)
+		}
+		data, _ := json.MarshalIndent(value, "  ", "  ")
+		fmt.Fprintf(&b, "  %s: %s\n\n", key, data)
+	}
+
+	b.WriteString("# MeshNet node configuration (HJSON — comments and quoteless values allowed)\n")
+	b.WriteString("# command-line flags override anything set here\n")
+	b.WriteString("{\n")
+	field("Yggdrasil peers to connect to", "Peers", c.Peers)
+	field("Yggdrasil URIs to accept peerings on e.g. tls://[::]:9443", "Listen", c.Listen)
+	field("LAN peer discovery, off when empty — e.g. [{ Regex: \".*\", Beacon: true, Listen: true }]\nBeacon advertises us, Listen peers with what we hear, Port 0 is random", "MulticastInterfaces", c.MulticastInterfaces)
+	field("accept inbound peerings only from contacts and AllowedPublicKeys\nLAN discovery links are not covered — give them a Password", "Private", c.Private)
+	field("hex public keys allowed to peer in private mode besides contacts", "AllowedPublicKeys", c.AllowedPublicKeys)
+	field("network ID — nodes only talk to nodes with the same one\nempty is the public MeshNet network", "Network", c.Network)
+	field("TCP port the DHT listens on", "DHTPort", c.DHTPort)
+	field("also run lookups over UDP on DHTPort with peers that take it\nthe port clashes with LAN discovery in TUN mode — TCP is used then", "DHTUDP", c.DHTUDP)
+	field("local API for the CLI — loopback only", "APIAddress", c.APIAddress)
+	field("name to register, empty means node-<key prefix>", "Name", c.Name)
+	field("services to advertise e.g. ssh:22", "Services", c.Services)
+	field("friendly names for group keys, usable with --group", "Groups", c.Groups)
+	field("group to register our record in, empty means public", "Group", c.Group)
+	field("how long our record stays valid on other nodes", "RecordTTL", c.RecordTTL)
+	field("how often to re-announce, 0 means 3/4 of RecordTTL", "ReannounceInterval", c.ReannounceInterval)
+	field("embedded Yggdrasil logging: Level is error, warn, info or debug\nFile is relative to the state directory, empty means stderr", "Log", c.Log)
+	return []byte(strings.TrimRight(b.String(), "\n") + "\n}\n")
+}
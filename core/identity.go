@@ -3,30 +3,68 @@ package core
 import (
 	"bytes"
 	"crypto/ed25519"
+	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
 	"os"
+	"path/filepath"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/address"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// identityVersion is the current on-disk identity format
+// version 0 (no field) is the original plaintext format
+const identityVersion = 2
+
+// argon2id parameters for new files — stored in the file so they can be
+// raised later without breaking existing identities
+const (
+	kdfArgon2id   = "argon2id"
+	cipherXChaCha = "xchacha20-poly1305"
+	kdfTime       = 3
+	kdfMemory     = 64 * 1024 // KiB
+	kdfThreads    = 4
+	kdfSaltLen    = 16
+	kdfKeyLen     = chacha20poly1305.KeySize
 )
 
+// ErrWrongPassphrase is returned when an encrypted identity fails to open
+var ErrWrongPassphrase = errors.New("wrong passphrase or corrupted identity file")
+
 type Identity struct {
-	PrivateKey string `json:"private_key"`
-	PublicKey  string `json:"public_key"`
+	Version    int                 `json:"version,omitempty"`
+	PrivateKey string              `json:"private_key,omitempty"`
+	PublicKey  string              `json:"public_key"`
+	Encryption *IdentityEncryption `json:"encryption,omitempty"`
 }
 
-func identityFilePath() string {
-	path := os.Getenv("IDENTITY")
-	if path == "" {
-		return "identity.json"
-	}
-	return path
+// IdentityEncryption holds the KDF parameters and sealed private key
+// of a passphrase-protected identity
+type IdentityEncryption struct {
+	KDF        string `json:"kdf"`
+	Salt       string `json:"salt"`
+	Time       uint32 `json:"time"`
+	Memory     uint32 `json:"memory"`
+	Threads    uint8  `json:"threads"`
+	Cipher     string `json:"cipher"`
+	Nonce      string `json:"nonce"`
+	Ciphertext string `json:"ciphertext"`
+}
+
+// IsEncrypted reports whether the private key is passphrase-protected
+func (id *Identity) IsEncrypted() bool {
+	return id.Encryption != nil
 }
 
-func loadOrCreateIdentity() (ed25519.PublicKey, ed25519.PrivateKey, error) {
+func loadOrCreateIdentity(path string, pass PassphraseSource, yggConfPath string) (ed25519.PublicKey, ed25519.PrivateKey, error) {
 	// try installed Yggdrasil's identity first
 	// if found, we share one address with the OS-level mesh interface
-	pubKey, privKey, err := tryReadYggdrasilIdentity()
+	pubKey, privKey, err := tryReadYggdrasilIdentity(yggConfPath)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -35,117 +73,279 @@ func loadOrCreateIdentity() (ed25519.PublicKey, ed25519.PrivateKey, error) {
 	}
 
 	// fall back to our own identity file
-	data, err := os.ReadFile(identityFilePath())
+	return loadOrCreateIdentityFile(path, pass)
+}
+
+// loadOrCreateIdentityFile opens our own identity file, creating it on
+// the first start and encrypting a plaintext one
+func loadOrCreateIdentityFile(path string, pass PassphraseSource) (ed25519.PublicKey, ed25519.PrivateKey, error) {
+	identity, err := ReadIdentity(path)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
-			return createAndSaveIdentity()
+			return createAndSaveIdentity(path, pass)
 		}
-		return nil, nil, fmt.Errorf("failed to read identity file: %w", err)
-	}
-
-	var identity Identity
-	if err := json.Unmarshal(data, &identity); err != nil {
-		return nil, nil, fmt.Errorf("failed to parse identity file: %w", err)
+		return nil, nil, err
 	}
 
-	privKeyBytes, err := hex.DecodeString(identity.PrivateKey)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to decode private key: %w", err)
-	}
-	pubKeyBytes, err := hex.DecodeString(identity.PublicKey)
+	privKey, err := identity.Unlock(pass)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to decode public key: %w", err)
+		return nil, nil, err
 	}
+	pubKey := privKey.Public().(ed25519.PublicKey)
 
-	privKey = ed25519.PrivateKey(privKeyBytes)
-	pubKey = ed25519.PublicKey(pubKeyBytes)
+	// transparently migrate plaintext files — encrypt with a headless
+	// passphrase or one asked for on the terminal, and only with nobody
+	// to ask just upgrade the format
+	if !identity.IsEncrypted() {
+		secret, err := migrationPassphrase(pass)
+		if err != nil {
+			return nil, nil, err
+		}
+		if secret != nil || identity.Version != identityVersion {
+			if err := SaveIdentity(path, privKey, secret); err != nil {
+				return nil, nil, fmt.Errorf("failed to migrate identity file: %w", err)
+			}
+		}
+		if secret != nil {
+			fmt.Println("Identity file encrypted with supplied passphrase")
+		} else {
+			fmt.Println("Warning: identity file is not encrypted — run 'meshnet identity encrypt'")
+		}
+	}
 
 	fmt.Println("Identity loaded from disk")
 	return pubKey, privKey, nil
 }
 
-func createAndSaveIdentity() (ed25519.PublicKey, ed25519.PrivateKey, error) {
-
+func createAndSaveIdentity(path string, pass PassphraseSource) (ed25519.PublicKey, ed25519.PrivateKey, error) {
 	pubKey, privKey, err := ed25519.GenerateKey(nil)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to generate keys: %w", err)
 	}
 
-	identity := Identity{
-		PrivateKey: hex.EncodeToString(privKey),
-		PublicKey:  hex.EncodeToString(pubKey),
+	if err := SaveNewIdentity(path, privKey, pass); err != nil {
+		return nil, nil, err
 	}
 
-	data, err := json.MarshalIndent(identity, "", "  ")
+	fmt.Println("Fresh identity generated and saved to", path)
+	return pubKey, privKey, nil
+}
+
+// SaveNewIdentity stores a freshly generated key, encrypting it from
+// the start if pass supplies a headless passphrase
+func SaveNewIdentity(path string, privKey ed25519.PrivateKey, pass PassphraseSource) error {
+	secret, err := headlessPassphrase(pass)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to encode identity: %w", err)
+		return err
 	}
+	return SaveIdentity(path, privKey, secret)
+}
 
-	if err := os.WriteFile(identityFilePath(), data, 0600); err != nil {
-		return nil, nil, fmt.Errorf("failed to save identity: %w", err)
+// ReadIdentity parses an identity file without unlocking it
+func ReadIdentity(path string) (*Identity, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to read identity file: %w", err)
 	}
 
-	fmt.Println("Fresh identity generated and saved to", identityFilePath())
-	return pubKey, privKey, nil
+	var identity Identity
+	if err := json.Unmarshal(data, &identity); err != nil {
+		return nil, fmt.Errorf("failed to parse identity file: %w", err)
+	}
+	if identity.Version > identityVersion {
+		return nil, fmt.Errorf("identity file version %d is newer than supported version %d",
+			identity.Version, identityVersion)
+	}
+	return &identity, nil
 }
 
-// PrivKeyHex returns the private key as a hex string
-// used when writing Yggdrasil config file
-func PrivKeyHex(privKey ed25519.PrivateKey) string {
-	return hex.EncodeToString(privKey)
+// Unlock returns the private key, asking pass for the passphrase
+// if the identity is encrypted
+func (id *Identity) Unlock(pass PassphraseSource) (ed25519.PrivateKey, error) {
+	if !id.IsEncrypted() {
+		return id.plaintextKey()
+	}
+	if pass == nil {
+		return nil, fmt.Errorf("identity file is encrypted and no passphrase source is available")
+	}
+
+	passphrase, err := pass.Passphrase("Identity passphrase: ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read passphrase: %w", err)
+	}
+	return id.Decrypt(passphrase)
 }
 
-// yggdrasilConfigPath is where the installed Yggdrasil stores its config
-const yggdrasilConfigPath = `C:\ProgramData\Yggdrasil\yggdrasil.conf`
+// Decrypt opens an encrypted identity with the given passphrase
+func (id *Identity) Decrypt(passphrase []byte) (ed25519.PrivateKey, error) {
+	enc := id.Encryption
+	if enc == nil {
+		return id.plaintextKey()
+	}
+	if enc.KDF != kdfArgon2id {
+		return nil, fmt.Errorf("unsupported identity KDF %q", enc.KDF)
+	}
+	if enc.Cipher != cipherXChaCha {
+		return nil, fmt.Errorf("unsupported identity cipher %q", enc.Cipher)
+	}
 
-// tryReadYggdrasilIdentity attempts to read the keypair from an installed
-// Yggdrasil instance. Returns nil, nil, nil if not found — caller falls back.
-func tryReadYggdrasilIdentity() (ed25519.PublicKey, ed25519.PrivateKey, error) {
-	data, err := os.ReadFile(yggdrasilConfigPath)
+	salt, err := hex.DecodeString(enc.Salt)
 	if err != nil {
-		// not installed or not readable — fall back to our own identity
-		return nil, nil, nil
+		return nil, fmt.Errorf("failed to decode salt: %w", err)
+	}
+	nonce, err := hex.DecodeString(enc.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode nonce: %w", err)
+	}
+	ciphertext, err := hex.DecodeString(enc.Ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode ciphertext: %w", err)
+	}
+	pubKeyBytes, err := hex.DecodeString(id.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode public key: %w", err)
 	}
 
-	// Yggdrasil config is NOT standard JSON — it uses a custom format
-	// with unquoted keys and # comments
-	// we just scan line by line for the PrivateKey field
-	for _, line := range bytes.Split(data, []byte("\n")) {
-		trimmed := bytes.TrimSpace(line)
+	key := argon2.IDKey(passphrase, salt, enc.Time, enc.Memory, enc.Threads, kdfKeyLen)
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to init cipher: %w", err)
+	}
+	if len(nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("invalid nonce length %d", len(nonce))
+	}
 
-		// skip comments and empty lines
-		if len(trimmed) == 0 || trimmed[0] == '#' {
-			continue
-		}
+	// the public key is authenticated as associated data — swapping it
+	// in the file makes decryption fail rather than yield a mismatched pair
+	seed, err := aead.Open(nil, nonce, ciphertext, pubKeyBytes)
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	if len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("invalid decrypted key length %d", len(seed))
+	}
 
-		// look for:  PrivateKey: <hexstring>
-		if !bytes.HasPrefix(trimmed, []byte("PrivateKey:")) {
-			continue
-		}
+	privKey := ed25519.NewKeyFromSeed(seed)
+	if !bytes.Equal(privKey.Public().(ed25519.PublicKey), pubKeyBytes) {
+		return nil, fmt.Errorf("decrypted key does not match public key")
+	}
+	return privKey, nil
+}
 
-		// extract the value after the colon
-		parts := bytes.SplitN(trimmed, []byte(":"), 2)
-		if len(parts) != 2 {
-			continue
-		}
+func (id *Identity) plaintextKey() (ed25519.PrivateKey, error) {
+	privKeyBytes, err := hex.DecodeString(id.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode private key: %w", err)
+	}
+	if len(privKeyBytes) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("invalid private key length %d", len(privKeyBytes))
+	}
+	return ed25519.PrivateKey(privKeyBytes), nil
+}
 
-		keyHex := string(bytes.TrimSpace(parts[1]))
-		if keyHex == "" {
-			continue
-		}
+// SaveIdentity writes privKey to path in the current format
+// a non-empty passphrase encrypts the key, nil stores it as plaintext
+func SaveIdentity(path string, privKey ed25519.PrivateKey, passphrase []byte) error {
+	pubKey := privKey.Public().(ed25519.PublicKey)
 
-		privKeyBytes, err := hex.DecodeString(keyHex)
+	identity := Identity{
+		Version:   identityVersion,
+		PublicKey: hex.EncodeToString(pubKey),
+	}
+
+	if len(passphrase) == 0 {
+		identity.PrivateKey = hex.EncodeToString(privKey)
+	} else {
+		enc, err := encryptKey(privKey, passphrase)
 		if err != nil {
-			return nil, nil, fmt.Errorf("invalid private key in yggdrasil config: %w", err)
+			return err
 		}
+		identity.Encryption = enc
+	}
 
-		privKey := ed25519.PrivateKey(privKeyBytes)
-		pubKey := privKey.Public().(ed25519.PublicKey)
+	data, err := json.MarshalIndent(identity, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode identity: %w", err)
+	}
 
-		fmt.Println("Identity loaded from installed Yggdrasil")
-		return pubKey, privKey, nil
+	if err := writeFileAtomic(path, data, 0600); err != nil {
+		return fmt.Errorf("failed to save identity: %w", err)
 	}
+	return nil
+}
+
+func encryptKey(privKey ed25519.PrivateKey, passphrase []byte) (*IdentityEncryption, error) {
+	salt := make([]byte, kdfSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	key := argon2.IDKey(passphrase, salt, kdfTime, kdfMemory, kdfThreads, kdfKeyLen)
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to init cipher: %w", err)
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	pubKey := privKey.Public().(ed25519.PublicKey)
+	ciphertext := aead.Seal(nil, nonce, privKey.Seed(), pubKey)
+
+	return &IdentityEncryption{
+		KDF:        kdfArgon2id,
+		Salt:       hex.EncodeToString(salt),
+		Time:       kdfTime,
+		Memory:     kdfMemory,
+		Threads:    kdfThreads,
+		Cipher:     cipherXChaCha,
+		Nonce:      hex.EncodeToString(nonce),
+		Ciphertext: hex.EncodeToString(ciphertext),
+	}, nil
+}
+
+// writeFileAtomic writes to a temp file and renames it over path
+// so a crash mid-write never leaves a truncated identity behind
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
 
-	// PrivateKey line not found
-	return nil, nil, fmt.Errorf("PrivateKey not found in yggdrasil config")
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmpName)
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		os.Remove(tmpName)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	return os.Rename(tmpName, path)
+}
+
+// AddressForKey returns the Yggdrasil IPv6 address derived from pubKey
+func AddressForKey(pubKey ed25519.PublicKey) string {
+	addr := address.AddrForKey(pubKey)
+	if addr == nil {
+		return ""
+	}
+	return net.IP(addr[:]).String()
+}
+
+// PrivKeyHex returns the private key as a hex string
+// used when writing Yggdrasil config file
+func PrivKeyHex(privKey ed25519.PrivateKey) string {
+	return hex.EncodeToString(privKey)
 }
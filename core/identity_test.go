@@ -0,0 +1,168 @@
+package core
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// promptPassphrase answers prompts in order, like someone at a terminal
+type promptPassphrase struct {
+	answers []string
+}
+
+func (p *promptPassphrase) Passphrase(string) ([]byte, error) {
+	if len(p.answers) == 0 {
+		return nil, errors.New("no more answers")
+	}
+	answer := p.answers[0]
+	p.answers = p.answers[1:]
+	return []byte(answer), nil
+}
+
+func (p *promptPassphrase) Interactive() bool { return true }
+
+func TestIdentityPassphraseRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "identity.json")
+	_, priv, _ := ed25519.GenerateKey(nil)
+	if err := SaveIdentity(path, priv, []byte("correct horse")); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte(hex.EncodeToString(priv.Seed()))) {
+		t.Fatal("private key written in the clear")
+	}
+
+	identity, err := ReadIdentity(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !identity.IsEncrypted() || identity.Version != identityVersion {
+		t.Fatalf("identity %+v not encrypted in the current format", identity)
+	}
+	got, err := identity.Decrypt([]byte("correct horse"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Equal(priv) {
+		t.Error("decrypted key differs from the saved one")
+	}
+}
+
+func TestIdentityWrongPassphrase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "identity.json")
+	_, priv, _ := ed25519.GenerateKey(nil)
+	if err := SaveIdentity(path, priv, []byte("correct horse")); err != nil {
+		t.Fatal(err)
+	}
+	identity, err := ReadIdentity(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := identity.Decrypt([]byte("battery staple")); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("wrong passphrase: got %v, want %v", err, ErrWrongPassphrase)
+	}
+	if _, err := identity.Unlock(staticPassphrase("battery staple")); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("wrong passphrase from a source: got %v, want %v", err, ErrWrongPassphrase)
+	}
+
+	// the public key is bound to the ciphertext
+	otherPub, _, _ := ed25519.GenerateKey(nil)
+	identity.PublicKey = hex.EncodeToString(otherPub)
+	if _, err := identity.Decrypt([]byte("correct horse")); err == nil {
+		t.Error("opened with a swapped public key")
+	}
+}
+
+// writePlaintextIdentity writes the original, unversioned format
+func writePlaintextIdentity(t *testing.T, path string, priv ed25519.PrivateKey) {
+	t.Helper()
+	data, err := json.Marshal(map[string]string{
+		"private_key": hex.EncodeToString(priv),
+		"public_key":  hex.EncodeToString(priv.Public().(ed25519.PublicKey)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIdentityMigratesPlaintext(t *testing.T) {
+	tests := []struct {
+		name string
+		pass PassphraseSource
+		// encrypted is the passphrase the file should end up under,
+		// "" for plaintext
+		encrypted string
+	}{
+		{"headless", staticPassphrase("correct horse"), "correct horse"},
+		{"prompted", &promptPassphrase{answers: []string{"correct horse", "correct horse"}}, "correct horse"},
+		{"nobody to ask", nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "identity.json")
+			_, priv, _ := ed25519.GenerateKey(nil)
+			writePlaintextIdentity(t, path, priv)
+
+			_, got, err := loadOrCreateIdentityFile(path, tt.pass)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Equal(priv) {
+				t.Fatal("migration changed the key")
+			}
+
+			identity, err := ReadIdentity(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if identity.Version != identityVersion {
+				t.Errorf("version %d, want %d", identity.Version, identityVersion)
+			}
+			if identity.IsEncrypted() != (tt.encrypted != "") {
+				t.Fatalf("encrypted is %v", identity.IsEncrypted())
+			}
+			if tt.encrypted == "" {
+				return
+			}
+			if identity.PrivateKey != "" {
+				t.Error("plaintext key left in the encrypted file")
+			}
+			if key, err := identity.Decrypt([]byte(tt.encrypted)); err != nil || !key.Equal(priv) {
+				t.Errorf("migrated file does not open with the passphrase: %v", err)
+			}
+		})
+	}
+}
+
+func TestIdentityMigrationRefusesMismatchedPrompt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "identity.json")
+	_, priv, _ := ed25519.GenerateKey(nil)
+	writePlaintextIdentity(t, path, priv)
+
+	pass := &promptPassphrase{answers: []string{"correct horse", "correct hose"}}
+	if _, _, err := loadOrCreateIdentityFile(path, pass); err == nil || !strings.Contains(err.Error(), "do not match") {
+		t.Fatalf("got %v, want a mismatch error", err)
+	}
+	identity, err := ReadIdentity(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Version != 0 || identity.IsEncrypted() {
+		t.Error("identity file rewritten after a failed prompt")
+	}
+}
@@ -0,0 +1,509 @@
+package core
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	iwt "github.com/Arceliar/ironwood/types"
+	"github.com/quic-go/quic-go"
+	"github.com/yggdrasil-network/yggdrasil-go/src/address"
+	yggcore "github.com/yggdrasil-network/yggdrasil-go/src/core"
+)
+
+// meshALPN identifies MeshNet traffic on QUIC connections over the mesh
+const meshALPN = "meshnet-dht"
+
+// how long to wait for the mesh to find the key behind an address, and
+// how often to repeat the lookup meanwhile
+const (
+	keyLookupInterval = time.Second
+	meshIdleTimeout   = 2 * time.Minute
+)
+
+// MeshTransport carries stream connections through the embedded
+// Yggdrasil core instead of the OS network stack, so the DHT works
+// without a TUN adapter or admin rights. Streams are QUIC over the
+// core's end-to-end encrypted packet connection; one QUIC connection
+// per remote node is shared by every stream to it.
+//
+// Yggdrasil addresses only contain part of the public key, so dialing
+// an address first asks the mesh for the full key. Keys of nodes that
+// connect to us are remembered, which makes replies instant.
+type MeshTransport struct {
+	tls  *tls.Config
+	quic *quic.Config
+
+	mu      sync.Mutex
+	core    *yggcore.Core
+	qt      *quic.Transport // nil while the core restarts
+	keys    map[address.Address]ed25519.PublicKey
+	waiters map[address.Address][]chan struct{}
+	conns   map[address.Address]quic.Connection
+
+	listener *meshListener
+}
+
+func newMeshTransport(c *yggcore.Core, cert *tls.Certificate) *MeshTransport {
+	// buffer sizes only mean something for real UDP sockets
+	os.Setenv("QUIC_GO_DISABLE_RECEIVE_BUFFER_WARNING", "true")
+
+	t := &MeshTransport{
+		// the mesh already authenticates and encrypts end to end by key —
+		// QUIC's TLS only has to be present, not checked
+		tls: &tls.Config{
+			Certificates:       []tls.Certificate{*cert},
+			NextProtos:         []string{meshALPN},
+			InsecureSkipVerify: true,
+		},
+		quic: &quic.Config{
+			MaxIdleTimeout: meshIdleTimeout,
+		},
+		keys:    make(map[address.Address]ed25519.PublicKey),
+		waiters: make(map[address.Address][]chan struct{}),
+		conns:   make(map[address.Address]quic.Connection),
+	}
+	t.rebind(c)
+	return t
+}
+
+// rebind moves the transport onto a (re)started core. Connections over
+// the old core are gone, but the listener and learned keys carry over.
+func (t *MeshTransport) rebind(c *yggcore.Core) {
+	c.SetPathNotify(t.learnKey)
+	qt := &quic.Transport{Conn: c}
+
+	t.mu.Lock()
+	t.core = c
+	t.qt = qt
+	listener := t.listener
+	t.mu.Unlock()
+
+	if listener != nil {
+		if ql, err := qt.Listen(t.tls, t.quic); err == nil {
+			listener.attach(ql)
+		}
+	}
+}
+
+// unbind drops everything tied to the current core before it stops
+func (t *MeshTransport) unbind() {
+	t.mu.Lock()
+	qt := t.qt
+	conns := t.conns
+	t.qt = nil
+	t.conns = make(map[address.Address]quic.Connection)
+	t.mu.Unlock()
+
+	for _, c := range conns {
+		c.CloseWithError(0, "")
+	}
+	if qt != nil {
+		qt.Close()
+	}
+}
+
+// current returns the core and QUIC transport in use
+func (t *MeshTransport) current() (*yggcore.Core, *quic.Transport, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.qt == nil {
+		return nil, nil, errors.New("mesh transport is restarting")
+	}
+	return t.core, t.qt, nil
+}
+
+// learnKey records the full key behind an address and wakes any dialer
+// waiting on it
+func (t *MeshTransport) learnKey(key ed25519.PublicKey) {
+	addr := *address.AddrForKey(key)
+
+	t.mu.Lock()
+	t.keys[addr] = append(ed25519.PublicKey(nil), key...)
+	waiting := t.waiters[addr]
+	delete(t.waiters, addr)
+	t.mu.Unlock()
+
+	for _, ch := range waiting {
+		close(ch)
+	}
+}
+
+// resolve returns the public key behind addr, asking the mesh if needed
+func (t *MeshTransport) resolve(ctx context.Context, addr address.Address) (ed25519.PublicKey, error) {
+	t.mu.Lock()
+	if key, ok := t.keys[addr]; ok {
+		t.mu.Unlock()
+		return key, nil
+	}
+	ch := make(chan struct{})
+	t.waiters[addr] = append(t.waiters[addr], ch)
+	t.mu.Unlock()
+
+	ticker := time.NewTicker(keyLookupInterval)
+	defer ticker.Stop()
+	for {
+		if core, _, err := t.current(); err == nil {
+			core.SendLookup(addr.GetKey())
+		}
+		select {
+		case <-ch:
+			t.mu.Lock()
+			key := t.keys[addr]
+			t.mu.Unlock()
+			return key, nil
+		case <-ticker.C:
+		case <-ctx.Done():
+			t.dropWaiter(addr, ch)
+			return nil, fmt.Errorf("no route to %s on the mesh", net.IP(addr[:]))
+		}
+	}
+}
+
+func (t *MeshTransport) dropWaiter(addr address.Address, ch chan struct{}) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	waiting := t.waiters[addr]
+	for i, w := range waiting {
+		if w == ch {
+			t.waiters[addr] = append(waiting[:i], waiting[i+1:]...)
+			break
+		}
+	}
+	if len(t.waiters[addr]) == 0 {
+		delete(t.waiters, addr)
+	}
+}
+
+// Dial opens a stream to the node at addr ("[200:...]:port"). The port
+// is ignored — a node's key already identifies it on the mesh.
+func (t *MeshTransport) Dial(addr string, timeout time.Duration) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address %s: %w", addr, err)
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || len(ip) != net.IPv6len {
+		return nil, fmt.Errorf("invalid mesh address %s", host)
+	}
+	var target address.Address
+	copy(target[:], ip)
+	if !target.IsValid() {
+		return nil, fmt.Errorf("%s is not a Yggdrasil address", host)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	conn, err := t.connection(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		// the cached connection may have died since — one fresh attempt
+		t.forget(target, conn)
+		if conn, err = t.connection(ctx, target); err != nil {
+			return nil, err
+		}
+		if stream, err = conn.OpenStreamSync(ctx); err != nil {
+			return nil, fmt.Errorf("failed to open stream to %s: %w", host, err)
+		}
+	}
+	return newMeshConn(stream, conn), nil
+}
+
+// connection returns the shared QUIC connection to addr, dialing one
+func (t *MeshTransport) connection(ctx context.Context, addr address.Address) (quic.Connection, error) {
+	t.mu.Lock()
+	conn := t.conns[addr]
+	t.mu.Unlock()
+	if conn != nil && conn.Context().Err() == nil {
+		return conn, nil
+	}
+
+	key, err := t.resolve(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	_, qt, err := t.current()
+	if err != nil {
+		return nil, err
+	}
+	conn, err = qt.Dial(ctx, iwt.Addr(key), t.tls, t.quic)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", net.IP(addr[:]), err)
+	}
+
+	t.mu.Lock()
+	if existing := t.conns[addr]; existing != nil && existing.Context().Err() == nil {
+		// lost a race with another dial — keep the first
+		t.mu.Unlock()
+		conn.CloseWithError(0, "")
+		return existing, nil
+	}
+	t.conns[addr] = conn
+	t.mu.Unlock()
+	return conn, nil
+}
+
+func (t *MeshTransport) forget(addr address.Address, conn quic.Connection) {
+	t.mu.Lock()
+	if t.conns[addr] == conn {
+		delete(t.conns, addr)
+	}
+	t.mu.Unlock()
+	conn.CloseWithError(0, "")
+}
+
+// Listen accepts streams from other nodes. A node has one listener
+// whatever the port, since its key is its whole address.
+func (t *MeshTransport) Listen(port int) (net.Listener, error) {
+	_, qt, err := t.current()
+	if err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.listener != nil {
+		return nil, errors.New("mesh transport is already listening")
+	}
+	ql, err := qt.Listen(t.tls, t.quic)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on the mesh: %w", err)
+	}
+	t.listener = newMeshListener(t)
+	t.listener.attach(ql)
+	return t.listener, nil
+}
+
+// ListenPacket opens the transport's datagram side: packets on the
+// core that aren't QUIC. Like Listen it ignores the port.
+func (t *MeshTransport) ListenPacket(port int) (net.PacketConn, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &meshPacketConn{t: t, ctx: ctx, cancel: cancel}, nil
+}
+
+// Close drops every connection and the listener
+func (t *MeshTransport) Close() error {
+	t.mu.Lock()
+	listener := t.listener
+	t.mu.Unlock()
+	if listener != nil {
+		listener.Close()
+	}
+	t.unbind()
+	return nil
+}
+
+// ── listener ─────────────────────────────────────────────────────────────────
+
+// meshListener turns incoming QUIC connections into a stream of
+// net.Conns. It outlives core restarts: each new core's QUIC listener
+// is attached to it.
+type meshListener struct {
+	t       *MeshTransport
+	streams chan net.Conn
+	done    chan struct{}
+	once    sync.Once
+
+	mu sync.Mutex
+	ql *quic.Listener
+}
+
+func newMeshListener(t *MeshTransport) *meshListener {
+	return &meshListener{
+		t:       t,
+		streams: make(chan net.Conn),
+		done:    make(chan struct{}),
+	}
+}
+
+// attach starts accepting from ql — the previous one died with its core
+func (l *meshListener) attach(ql *quic.Listener) {
+	l.mu.Lock()
+	l.ql = ql
+	l.mu.Unlock()
+	go l.acceptConns(ql)
+}
+
+func (l *meshListener) acceptConns(ql *quic.Listener) {
+	for {
+		conn, err := ql.Accept(context.Background())
+		if err != nil {
+			return
+		}
+		// we now know this node's key — replies to it need no lookup
+		if key, ok := conn.RemoteAddr().(iwt.Addr); ok {
+			l.t.learnKey(ed25519.PublicKey(key))
+		}
+		go l.acceptStreams(conn)
+	}
+}
+
+func (l *meshListener) acceptStreams(conn quic.Connection) {
+	for {
+		stream, err := conn.AcceptStream(context.Background())
+		if err != nil {
+			return
+		}
+		select {
+		case l.streams <- newMeshConn(stream, conn):
+		case <-l.done:
+			stream.CancelRead(0)
+			stream.Close()
+			return
+		}
+	}
+}
+
+func (l *meshListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.streams:
+		return c, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *meshListener) Close() error {
+	l.once.Do(func() {
+		close(l.done)
+		l.mu.Lock()
+		if l.ql != nil {
+			l.ql.Close()
+		}
+		l.mu.Unlock()
+	})
+	return nil
+}
+
+func (l *meshListener) Addr() net.Addr {
+	l.t.mu.Lock()
+	defer l.t.mu.Unlock()
+	return meshAddr(l.t.core.PublicKey())
+}
+
+// ── datagrams ───────────────────────────────────────────────────────────────
+
+// packetResolveTimeout bounds the key lookup before a datagram is sent
+const packetResolveTimeout = 5 * time.Second
+
+// meshPacketConn sends and receives the core's non-QUIC packets, which
+// QUIC hands over because their first byte lacks the bits it uses.
+// Like meshListener it carries over core restarts.
+type meshPacketConn struct {
+	t      *MeshTransport
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func (c *meshPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		if _, qt, err := c.t.current(); err == nil {
+			n, from, err := qt.ReadNonQUICPacket(c.ctx, b)
+			if err == nil {
+				key, ok := from.(iwt.Addr)
+				if !ok {
+					continue
+				}
+				// as with streams, replies need no key lookup
+				c.t.learnKey(ed25519.PublicKey(key))
+				return n, meshPacketAddr(ed25519.PublicKey(key)), nil
+			}
+		}
+		// the core is restarting — wait for the next one
+		select {
+		case <-c.ctx.Done():
+			return 0, nil, net.ErrClosed
+		case <-time.After(keyLookupInterval):
+		}
+	}
+}
+
+func (c *meshPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	ua, ok := addr.(*net.UDPAddr)
+	if !ok || len(ua.IP) != net.IPv6len {
+		return 0, fmt.Errorf("invalid mesh address %s", addr)
+	}
+	var target address.Address
+	copy(target[:], ua.IP)
+	if !target.IsValid() {
+		return 0, fmt.Errorf("%s is not a Yggdrasil address", ua.IP)
+	}
+
+	ctx, cancel := context.WithTimeout(c.ctx, packetResolveTimeout)
+	defer cancel()
+	key, err := c.t.resolve(ctx, target)
+	if err != nil {
+		return 0, err
+	}
+	_, qt, err := c.t.current()
+	if err != nil {
+		return 0, err
+	}
+	return qt.WriteTo(b, iwt.Addr(key))
+}
+
+func (c *meshPacketConn) Close() error {
+	c.cancel()
+	return nil
+}
+
+func (c *meshPacketConn) LocalAddr() net.Addr {
+	c.t.mu.Lock()
+	defer c.t.mu.Unlock()
+	return meshPacketAddr(c.t.core.PublicKey())
+}
+
+// deadlines aren't used — reads end with Close
+func (c *meshPacketConn) SetDeadline(time.Time) error      { return nil }
+func (c *meshPacketConn) SetReadDeadline(time.Time) error  { return nil }
+func (c *meshPacketConn) SetWriteDeadline(time.Time) error { return nil }
+
+// meshPacketAddr is meshAddr for datagrams
+func meshPacketAddr(key ed25519.PublicKey) net.Addr {
+	addr := address.AddrForKey(key)
+	return &net.UDPAddr{IP: net.IP(addr[:])}
+}
+
+// ── conn ─────────────────────────────────────────────────────────────────────
+
+// meshConn is one QUIC stream presented as a net.Conn
+type meshConn struct {
+	quic.Stream
+	local, remote net.Addr
+}
+
+func newMeshConn(s quic.Stream, c quic.Connection) *meshConn {
+	mc := &meshConn{Stream: s, local: c.LocalAddr(), remote: c.RemoteAddr()}
+	if key, ok := c.LocalAddr().(iwt.Addr); ok {
+		mc.local = meshAddr(ed25519.PublicKey(key))
+	}
+	if key, ok := c.RemoteAddr().(iwt.Addr); ok {
+		mc.remote = meshAddr(ed25519.PublicKey(key))
+	}
+	return mc
+}
+
+// Close ends both directions — a QUIC stream's Close only ends ours
+func (c *meshConn) Close() error {
+	c.Stream.CancelRead(0)
+	return c.Stream.Close()
+}
+
+func (c *meshConn) LocalAddr() net.Addr  { return c.local }
+func (c *meshConn) RemoteAddr() net.Addr { return c.remote }
+
+// meshAddr presents a key as the Yggdrasil address it maps to
+func meshAddr(key ed25519.PublicKey) net.Addr {
+	addr := address.AddrForKey(key)
+	return &net.TCPAddr{IP: net.IP(addr[:])}
+}
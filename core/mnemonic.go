@@ -0,0 +1,119 @@
+package core
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	_ "embed"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// bip39English is the standard BIP39 English wordlist (crc32 c1dbd296)
+//
+//go:embed bip39_english.txt
+var bip39English string
+
+// mnemonicWords is the number of words encoding a 32-byte seed:
+// 256 bits of seed + 8 bits of checksum = 264 bits = 24 × 11 bits
+const mnemonicWords = 24
+
+var (
+	wordlistOnce  sync.Once
+	wordlist      []string
+	wordlistIndex map[string]int
+)
+
+func loadWordlist() {
+	wordlistOnce.Do(func() {
+		wordlist = strings.Fields(bip39English)
+		wordlistIndex = make(map[string]int, len(wordlist))
+		for i, w := range wordlist {
+			wordlistIndex[w] = i
+		}
+	})
+}
+
+// SeedToMnemonic encodes a 32-byte ed25519 seed as 24 words using the
+// BIP39 entropy encoding. The seed is the entropy itself — there is no
+// PBKDF2 step — so the words restore exactly this key.
+func SeedToMnemonic(seed []byte) (string, error) {
+	if len(seed) != ed25519.SeedSize {
+		return "", fmt.Errorf("seed must be %d bytes, got %d", ed25519.SeedSize, len(seed))
+	}
+	loadWordlist()
+
+	checksum := sha256.Sum256(seed)
+	bits := append(append([]byte{}, seed...), checksum[0])
+
+	words := make([]string, mnemonicWords)
+	for i := range words {
+		words[i] = wordlist[readBits(bits, i*11, 11)]
+	}
+	return strings.Join(words, " "), nil
+}
+
+// MnemonicToSeed decodes 24 words back into the 32-byte seed and
+// verifies the checksum. Words may be abbreviated to their first
+// four letters, which are unique in the BIP39 list.
+func MnemonicToSeed(mnemonic string) ([]byte, error) {
+	loadWordlist()
+
+	words := strings.Fields(strings.ToLower(mnemonic))
+	if len(words) != mnemonicWords {
+		return nil, fmt.Errorf("expected %d words, got %d", mnemonicWords, len(words))
+	}
+
+	bits := make([]byte, ed25519.SeedSize+1)
+	for i, w := range words {
+		idx, err := lookupWord(w)
+		if err != nil {
+			return nil, fmt.Errorf("word %d: %w", i+1, err)
+		}
+		writeBits(bits, i*11, 11, idx)
+	}
+
+	seed := bits[:ed25519.SeedSize]
+	checksum := sha256.Sum256(seed)
+	if checksum[0] != bits[ed25519.SeedSize] {
+		return nil, fmt.Errorf("checksum mismatch — check the words and their order")
+	}
+	return seed, nil
+}
+
+func lookupWord(w string) (int, error) {
+	if idx, ok := wordlistIndex[w]; ok {
+		return idx, nil
+	}
+	if len(w) >= 4 {
+		for i, candidate := range wordlist {
+			if strings.HasPrefix(candidate, w) {
+				return i, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("%q is not in the word list", w)
+}
+
+// readBits returns n bits starting at bit offset off, most significant first
+func readBits(buf []byte, off, n int) int {
+	v := 0
+	for i := 0; i < n; i++ {
+		bit := off + i
+		v <<= 1
+		if buf[bit/8]&(0x80>>(bit%8)) != 0 {
+			v |= 1
+		}
+	}
+	return v
+}
+
+// writeBits stores the low n bits of v at bit offset off
+func writeBits(buf []byte, off, n, v int) {
+	for i := 0; i < n; i++ {
+		bit := off + i
+		if v&(1<<(n-1-i)) != 0 {
+			buf[bit/8] |= 0x80 >> (bit % 8)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package core
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// BIP39 reference vectors for 256-bit entropy
+var mnemonicVectors = []struct {
+	seed     string
+	mnemonic string
+}{
+	{
+		strings.Repeat("00", 32),
+		strings.Repeat("abandon ", 23) + "art",
+	},
+	{
+		strings.Repeat("7f", 32),
+		"legal winner thank year wave sausage worth useful legal winner thank year wave sausage worth useful legal winner thank year wave sausage worth title",
+	},
+	{
+		strings.Repeat("80", 32),
+		"letter advice cage absurd amount doctor acoustic avoid letter advice cage absurd amount doctor acoustic avoid letter advice cage absurd amount doctor acoustic bless",
+	},
+	{
+		strings.Repeat("ff", 32),
+		strings.Repeat("zoo ", 23) + "vote",
+	},
+}
+
+func TestMnemonicVectors(t *testing.T) {
+	for _, v := range mnemonicVectors {
+		seed, _ := hex.DecodeString(v.seed)
+		got, err := SeedToMnemonic(seed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != v.mnemonic {
+			t.Errorf("%s...: got %q, want %q", v.seed[:8], got, v.mnemonic)
+		}
+		back, err := MnemonicToSeed(v.mnemonic)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(back, seed) {
+			t.Errorf("%s...: decoded to %x", v.seed[:8], back)
+		}
+	}
+}
+
+func TestMnemonicRoundTrip(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		seed := make([]byte, 32)
+		rand.Read(seed)
+		words, err := SeedToMnemonic(seed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := MnemonicToSeed(words)
+		if err != nil {
+			t.Fatalf("%q: %v", words, err)
+		}
+		if !bytes.Equal(got, seed) {
+			t.Fatalf("%q decoded to %x, want %x", words, got, seed)
+		}
+
+		// as typed: first four letters, any case, extra spaces
+		var typed []string
+		for _, w := range strings.Fields(words) {
+			if len(w) > 4 {
+				w = w[:4]
+			}
+			typed = append(typed, strings.ToUpper(w))
+		}
+		got, err = MnemonicToSeed(" " + strings.Join(typed, "  ") + "\n")
+		if err != nil || !bytes.Equal(got, seed) {
+			t.Fatalf("abbreviated %q: %x, %v", typed, got, err)
+		}
+	}
+}
+
+func TestMnemonicRejectsBadInput(t *testing.T) {
+	valid := mnemonicVectors[1].mnemonic
+	words := strings.Fields(valid)
+
+	tests := []struct {
+		name     string
+		mnemonic string
+		err      string
+	}{
+		{"bad checksum", strings.Repeat("abandon ", 24), "checksum"},
+		{"last word changed", strings.Join(append(words[:23:23], "trick"), " "), "checksum"},
+		{"words swapped", strings.Join(append([]string{words[1], words[0]}, words[2:]...), " "), "checksum"},
+		{"word missing", strings.Join(words[:23], " "), "expected 24 words, got 23"},
+		{"word too many", valid + " zoo", "expected 24 words, got 25"},
+		{"empty", "", "expected 24 words, got 0"},
+		{"unknown word", strings.Join(append([]string{"meshnet"}, words[1:]...), " "), "word 1"},
+		{"prefix too short", strings.Join(append([]string{"wi"}, words[1:]...), " "), "word 1"},
+	}
+	for _, tt := range tests {
+		if _, err := MnemonicToSeed(tt.mnemonic); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: got %v, want an error containing %q", tt.name, err, tt.err)
+		}
+	}
+
+	if _, err := SeedToMnemonic(make([]byte, 16)); err == nil {
+		t.Error("16 byte seed encoded")
+	}
+}
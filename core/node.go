@@ -2,13 +2,19 @@ package core
 
 import (
 	"crypto/ed25519"
+	"crypto/tls"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"net/url"
 	"os"
+	"regexp"
+	"sync"
 
 	"github.com/gologme/log"
 	"github.com/yggdrasil-network/yggdrasil-go/src/admin"
 	yggcore "github.com/yggdrasil-network/yggdrasil-go/src/core"
+	"github.com/yggdrasil-network/yggdrasil-go/src/multicast"
 )
 
 type Node struct {
@@ -17,20 +23,137 @@ type Node struct {
 	logger  *log.Logger
 	address string
 	privKey ed25519.PrivateKey
+	pass    PassphraseSource
+	yggConf string
+	idPath  string
+	peers   []string
+	listen  []string
+	logOut  io.Writer
+	logLvl  string
+	adminAt string
+
+	listeners []string
+	mcastIfs  []MulticastInterface
+	mcast     *multicast.Multicast
+	nodeInfo  map[string]interface{}
+
+	cert      *tls.Certificate
+	transport *MeshTransport
+	pubKey    string
+
+	// private mode — see SetPrivate
+	private      bool
+	allowed      []string
+	bootstrapped bool
+	// mu guards core, admin and what startCore sets, which a restart in
+	// UpdateAllowedKeys swaps out
+	mu sync.Mutex
+}
+
+// MulticastInterface selects interfaces for LAN peer discovery
+// same keys as the MulticastInterfaces section of a Yggdrasil config
+type MulticastInterface struct {
+	Regex    string `json:"Regex"`
+	Beacon   bool   `json:"Beacon"`
+	Listen   bool   `json:"Listen"`
+	Port     uint16 `json:"Port"`
+	Priority uint8  `json:"Priority"`
+	Password string `json:"Password"`
+}
+
+// NewNode creates a node whose identity lives at identityPath
+func NewNode(identityPath string) *Node {
+	return &Node{idPath: identityPath}
+}
+
+// SetPeers sets the Yggdrasil peers BootstrapPeers connects to
+func (n *Node) SetPeers(peers []string) {
+	n.peers = peers
+}
+
+// SetListen sets URIs the embedded core accepts peerings on
+// must be called before Start
+func (n *Node) SetListen(uris []string) {
+	n.listen = uris
 }
 
-func NewNode() *Node {
-	return &Node{}
+// Listeners returns the addresses the embedded core is accepting
+// peerings on, with the real port when the URI asked for port 0
+func (n *Node) Listeners() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.listeners
+}
+
+// SetMulticast enables LAN peer discovery on matching interfaces
+// must be called before Start
+func (n *Node) SetMulticast(ifaces []MulticastInterface) {
+	n.mcastIfs = ifaces
+}
+
+// SetNodeInfo sets the NodeInfo other nodes get from getNodeInfo
+// must be called before Start
+func (n *Node) SetNodeInfo(info map[string]interface{}) {
+	n.nodeInfo = info
+}
+
+// SetLogging sets the embedded core's log level (error, warn, info,
+// debug) and output — must be called before Start
+func (n *Node) SetLogging(level string, out io.Writer) {
+	n.logLvl = level
+	n.logOut = out
+}
+
+// SetAdminListen exposes the embedded core's admin API on uri
+// e.g. unix:///path/yggdrasil.sock — must be called before Start
+func (n *Node) SetAdminListen(uri string) {
+	n.adminAt = uri
+}
+
+// AdminEndpoint returns where the embedded admin API listens, or ""
+func (n *Node) AdminEndpoint() string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.admin == nil {
+		return ""
+	}
+	return n.adminAt
+}
+
+// SetYggdrasilConfig points identity discovery at a specific Yggdrasil
+// config instead of searching the default locations
+func (n *Node) SetYggdrasilConfig(path string) {
+	n.yggConf = path
+}
+
+// SetPassphraseSource sets where the identity passphrase comes from
+// must be called before Start
+func (n *Node) SetPassphraseSource(pass PassphraseSource) {
+	n.pass = pass
 }
 
 func (n *Node) Start() error {
-	n.logger = log.New(os.Stderr, "", 0)
-	n.logger.EnableLevel("warn")
-	n.logger.EnableLevel("error")
-	// info level disabled — suppresses "Connected outbound" noise
-	// re-enable with n.logger.EnableLevel("info") for debugging
+	out := n.logOut
+	if out == nil {
+		out = os.Stderr
+	}
+	n.logger = log.New(out, "", 0)
+	// info level disabled by default — suppresses "Connected outbound" noise
+	switch n.logLvl {
+	case "debug":
+		n.logger.EnableLevel("debug")
+		fallthrough
+	case "info":
+		n.logger.EnableLevel("info")
+		fallthrough
+	case "warn", "":
+		n.logger.EnableLevel("warn")
+		fallthrough
+	case "error":
+		n.logger.EnableLevel("error")
+	}
 
-	pubKey, privKey, err := loadOrCreateIdentity()
+	pubKey, privKey, err := loadOrCreateIdentity(n.idPath, n.pass, n.yggConf)
 	if err != nil {
 		return fmt.Errorf("failed to load identity %w", err)
 	}
@@ -40,27 +163,189 @@ func (n *Node) Start() error {
 	if err != nil {
 		return fmt.Errorf("failed to generate certificate %w", err)
 	}
+	n.cert = cert
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.startCore()
+}
+
+// newYggCore creates the embedded core, swapped out by tests
+var newYggCore = yggcore.New
+
+// startCore brings up the Yggdrasil core and the modules around it,
+// n.mu held
+func (n *Node) startCore() error {
+	var opts []yggcore.SetupOption
+	for _, key := range n.allowedKeys() {
+		opts = append(opts, yggcore.AllowedPublicKey(key))
+	}
+	if n.nodeInfo != nil {
+		opts = append(opts, yggcore.NodeInfo(n.nodeInfo))
+	}
 
-	n.core, err = yggcore.New(cert, n.logger)
+	var err error
+	n.core, err = newYggCore(n.cert, n.logger, opts...)
 	if err != nil {
 		return fmt.Errorf("failed to create yggdrasil node: %w", err)
 	}
 
-	n.admin, err = admin.New(n.core, n.logger)
+	// listen ourselves rather than through core options so a bad URI
+	// fails startup instead of being logged and ignored
+	n.listeners = nil
+	for _, uri := range n.listen {
+		u, err := url.Parse(uri)
+		if err != nil {
+			n.stopCore()
+			return fmt.Errorf("invalid listen URI %s: %w", uri, err)
+		}
+		l, err := n.core.Listen(u, "")
+		if err != nil {
+			n.stopCore()
+			return fmt.Errorf("failed to listen on %s: %w", uri, err)
+		}
+		n.listeners = append(n.listeners, u.Scheme+"://"+l.Addr().String())
+	}
+
+	n.admin, err = admin.New(n.core, n.logger, admin.ListenAddress(n.adminAt))
 	if err != nil {
+		n.stopCore()
 		return fmt.Errorf("failed to create admin socket: %w", err)
 	}
+	if n.admin != nil {
+		n.admin.SetupAdminHandlers()
+	}
+
+	if len(n.mcastIfs) > 0 {
+		var opts []multicast.SetupOption
+		for _, intf := range n.mcastIfs {
+			re, err := regexp.Compile(intf.Regex)
+			if err != nil {
+				n.stopCore()
+				return fmt.Errorf("invalid multicast interface regex %q: %w", intf.Regex, err)
+			}
+			opts = append(opts, multicast.MulticastInterface{
+				Regex:    re,
+				Beacon:   intf.Beacon,
+				Listen:   intf.Listen,
+				Port:     intf.Port,
+				Priority: intf.Priority,
+				Password: intf.Password,
+			})
+		}
+		n.mcast, err = multicast.New(n.core, n.logger, opts...)
+		if err != nil {
+			n.stopCore()
+			return fmt.Errorf("failed to start multicast: %w", err)
+		}
+		if n.admin != nil {
+			n.mcast.SetupAdminHandlers(n.admin)
+		}
+	}
 
 	n.address = n.core.Address().String()
+	n.pubKey = fmt.Sprintf("%x", n.core.PublicKey())
+	if n.transport != nil {
+		n.transport.rebind(n.core)
+	}
 	return nil
 }
 
+// stopCore tears down what startCore built, leaving the transport
+// ready to be rebound, n.mu held
+func (n *Node) stopCore() {
+	if n.transport != nil {
+		n.transport.unbind()
+	}
+	if n.mcast != nil {
+		n.mcast.Stop()
+		n.mcast = nil
+	}
+	if n.admin != nil {
+		n.admin.Stop()
+		n.admin = nil
+	}
+	if n.core != nil {
+		n.core.Stop()
+	}
+}
+
+// allowedKeys is the list handed to Yggdrasil. An empty list means
+// "allow everyone" there, so private mode with no contacts lists our
+// own key — which never dials itself — to shut everyone else out.
+func (n *Node) allowedKeys() []ed25519.PublicKey {
+	if !n.private {
+		return nil
+	}
+	keys := []ed25519.PublicKey{n.privKey.Public().(ed25519.PublicKey)}
+	for _, k := range n.allowed {
+		if b, err := hex.DecodeString(k); err == nil && len(b) == ed25519.PublicKeySize {
+			keys = append(keys, b)
+		}
+	}
+	return keys
+}
+
+// SetPrivate restricts inbound peerings to the given public keys (hex)
+// must be called before Start — use UpdateAllowedKeys afterwards
+func (n *Node) SetPrivate(keys []string) {
+	n.private = true
+	n.allowed = keys
+}
+
+// UpdateAllowedKeys replaces the private-mode allow-list of a running
+// node. Yggdrasil only reads the list at startup, so the core restarts
+// with the same key and redials its peers — links drop for a moment.
+// If the restart fails the core comes back with the old list.
+func (n *Node) UpdateAllowedKeys(keys []string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if !n.private || sameKeys(n.allowed, keys) {
+		return nil
+	}
+	old := n.allowed
+	n.allowed = keys
+
+	n.stopCore()
+	err := n.startCore()
+	if err != nil {
+		n.allowed = old
+		if rerr := n.startCore(); rerr != nil {
+			return fmt.Errorf("failed to restart with the new allow-list: %v, nor with the old one: %w", err, rerr)
+		}
+		err = fmt.Errorf("failed to restart with the new allow-list, kept the old one: %w", err)
+	}
+	if n.bootstrapped {
+		n.dialPeers(false)
+	}
+	return err
+}
+
+func sameKeys(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	seen := make(map[string]bool, len(a))
+	for _, k := range a {
+		seen[k] = true
+	}
+	for _, k := range b {
+		if !seen[k] {
+			return false
+		}
+	}
+	return true
+}
+
 func (n *Node) AddPeer(peerURL string) error {
 	u, err := url.Parse(peerURL)
 	if err != nil {
 		return fmt.Errorf("invalid peer URL %s: %w", peerURL, err)
 	}
-	return n.core.AddPeer(u, "")
+	n.mu.Lock()
+	core := n.core
+	n.mu.Unlock()
+	return core.AddPeer(u, "")
 }
 
 // Bootstrap is a no-op stub
@@ -72,35 +357,53 @@ func (n *Node) Bootstrap() {}
 // only call this when NOT in TUN mode
 // calling this with TUN active causes routing conflicts — same key, two instances
 func (n *Node) BootstrapPeers() {
-	peers := []string{
-		"tls://62.210.85.80:39575",
-		"tls://51.15.204.214:54321",
-		"tls://n.ygg.yt:443",
-		"tls://ygg7.mk16.de:1338?key=000000086278b5f3ba1eb63acb5b7f6e406f04ce83990dee9c07f49011e375ae",
-		"tls://syd.joel.net.au:8443",
-		"tls://95.217.35.92:1337",
-		"tls://37.205.14.171:993",
-	}
-	for _, peer := range peers {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.bootstrapped = true
+	n.dialPeers(true)
+}
+
+// dialPeers connects to the configured peers in the background, n.mu held
+func (n *Node) dialPeers(verbose bool) {
+	core := n.core
+	for _, peer := range n.peers {
 		go func(p string) {
 			u, err := url.Parse(p)
 			if err != nil {
 				return
 			}
-			if err := n.core.AddPeer(u, ""); err != nil {
+			if err := core.AddPeer(u, ""); err != nil {
 				return
 			}
-			fmt.Println("  ✓", p)
+			if verbose {
+				fmt.Println("  ✓", p)
+			}
 		}(peer)
 	}
 }
 
+// MeshTransport returns a dht.Transport that runs through the embedded
+// core — for when there is no TUN and the OS can't reach 200::/7.
+// Only valid after Start; every call returns the same transport.
+func (n *Node) MeshTransport() *MeshTransport {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.transport == nil {
+		n.transport = newMeshTransport(n.core, n.cert)
+	}
+	return n.transport
+}
+
 func (n *Node) Address() string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
 	return n.address
 }
 
 func (n *Node) PublicKey() string {
-	return fmt.Sprintf("%x", n.core.PublicKey())
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.pubKey
 }
 
 func (n *Node) Stop() {
@@ -108,12 +411,12 @@ func (n *Node) Stop() {
 	n.logger.DisableLevel("warn")
 	n.logger.DisableLevel("error")
 
-	if n.admin != nil {
-		n.admin.Stop()
-	}
-	if n.core != nil {
-		n.core.Stop()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.transport != nil {
+		n.transport.Close()
 	}
+	n.stopCore()
 }
 
 func (n *Node) PrivateKey() ed25519.PrivateKey {
@@ -0,0 +1,107 @@
+package core
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	yggcore "github.com/yggdrasil-network/yggdrasil-go/src/core"
+)
+
+// startPrivateNode starts a node in private mode allowing allowed, its
+// key taken from a Yggdrasil config so no identity file is involved
+func startPrivateNode(t *testing.T, allowed []string) *Node {
+	t.Helper()
+	dir := t.TempDir()
+	_, priv, _ := ed25519.GenerateKey(nil)
+	conf := filepath.Join(dir, "yggdrasil.conf")
+	if err := os.WriteFile(conf, []byte(`{PrivateKey: "`+hex.EncodeToString(priv)+`"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	n := NewNode(filepath.Join(dir, "identity.json"))
+	n.SetYggdrasilConfig(conf)
+	n.SetLogging("error", io.Discard)
+	n.SetPrivate(allowed)
+	if err := n.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(n.Stop)
+	return n
+}
+
+// coreAllowing wraps yggcore.New, refusing to start a core whose
+// allow-list has refuse on it and reporting the lists of those it starts
+func coreAllowing(t *testing.T, refuse ed25519.PublicKey, started func([]ed25519.PublicKey)) {
+	t.Cleanup(func() { newYggCore = yggcore.New })
+	newYggCore = func(cert *tls.Certificate, logger yggcore.Logger, opts ...yggcore.SetupOption) (*yggcore.Core, error) {
+		var keys []ed25519.PublicKey
+		for _, opt := range opts {
+			if key, ok := opt.(yggcore.AllowedPublicKey); ok {
+				if bytes.Equal(key, refuse) {
+					return nil, errors.New("refused")
+				}
+				keys = append(keys, ed25519.PublicKey(key))
+			}
+		}
+		started(keys)
+		return yggcore.New(cert, logger, opts...)
+	}
+}
+
+func contactKey(t *testing.T) (ed25519.PublicKey, string) {
+	t.Helper()
+	pub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pub, hex.EncodeToString(pub)
+}
+
+func TestUpdateAllowedKeysRestart(t *testing.T) {
+	oldPub, oldKey := contactKey(t)
+	newPub, newKey := contactKey(t)
+	n := startPrivateNode(t, []string{oldKey})
+	address := n.Address()
+
+	var lists [][]ed25519.PublicKey
+	coreAllowing(t, nil, func(keys []ed25519.PublicKey) { lists = append(lists, keys) })
+	if err := n.UpdateAllowedKeys([]string{oldKey, newKey}); err != nil {
+		t.Fatal(err)
+	}
+	// our own key is always on the list, see allowedKeys
+	if len(lists) != 1 || len(lists[0]) != 3 || !lists[0][1].Equal(oldPub) || !lists[0][2].Equal(newPub) {
+		t.Fatalf("core restarted with %x", lists)
+	}
+	if n.Address() != address {
+		t.Error("address changed across the restart")
+	}
+}
+
+func TestUpdateAllowedKeysRestoresOldList(t *testing.T) {
+	oldPub, oldKey := contactKey(t)
+	newPub, newKey := contactKey(t)
+	n := startPrivateNode(t, []string{oldKey})
+
+	var lists [][]ed25519.PublicKey
+	coreAllowing(t, newPub, func(keys []ed25519.PublicKey) { lists = append(lists, keys) })
+	if err := n.UpdateAllowedKeys([]string{newKey}); err == nil {
+		t.Fatal("failed restart not reported")
+	}
+
+	if !sameKeys(n.allowed, []string{oldKey}) {
+		t.Errorf("allow-list is %v after the failed update", n.allowed)
+	}
+	if len(lists) != 1 || len(lists[0]) != 2 || !lists[0][1].Equal(oldPub) {
+		t.Fatalf("core came back with %x, want the old list", lists)
+	}
+	if n.Address() == "" || n.core == nil {
+		t.Error("node left without a core")
+	}
+}
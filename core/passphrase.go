@@ -0,0 +1,154 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+
+	"golang.org/x/term"
+)
+
+// PassphraseEnv is the environment variable checked for a headless passphrase
+const PassphraseEnv = "MESHNET_PASSPHRASE"
+
+// PassphraseSource supplies the passphrase protecting the identity file.
+// Interactive sources prompt on the terminal; headless ones return a
+// secret supplied up front (env var, file, file descriptor).
+type PassphraseSource interface {
+	Passphrase(prompt string) ([]byte, error)
+	Interactive() bool
+}
+
+// headlessPassphrase returns the passphrase of a non-interactive source
+// or nil if the source would have to prompt
+func headlessPassphrase(pass PassphraseSource) ([]byte, error) {
+	if pass == nil || pass.Interactive() {
+		return nil, nil
+	}
+	secret, err := pass.Passphrase("")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read passphrase: %w", err)
+	}
+	if len(secret) == 0 {
+		return nil, nil
+	}
+	return secret, nil
+}
+
+// migrationPassphrase returns the passphrase to encrypt a plaintext
+// identity with as it's loaded: a headless source's, or a new one asked
+// for twice on the terminal. nil, with no error, when there's neither.
+func migrationPassphrase(pass PassphraseSource) ([]byte, error) {
+	if !canPrompt(pass) {
+		return headlessPassphrase(pass)
+	}
+
+	fmt.Fprintln(os.Stderr, "Identity file is not encrypted — choose a passphrase to protect it")
+	secret, err := pass.Passphrase("New identity passphrase: ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read passphrase: %w", err)
+	}
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("passphrase cannot be empty")
+	}
+	confirm, err := pass.Passphrase("Confirm passphrase: ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read passphrase: %w", err)
+	}
+	if !bytes.Equal(secret, confirm) {
+		return nil, fmt.Errorf("passphrases do not match")
+	}
+	return secret, nil
+}
+
+// canPrompt reports whether an interactive source has someone to ask —
+// a start under a service manager has no terminal
+func canPrompt(pass PassphraseSource) bool {
+	if pass == nil || !pass.Interactive() {
+		return false
+	}
+	if _, ok := pass.(TerminalPassphrase); ok {
+		return term.IsTerminal(int(os.Stdin.Fd()))
+	}
+	return true
+}
+
+// staticPassphrase is a passphrase known before startup
+type staticPassphrase []byte
+
+func (p staticPassphrase) Passphrase(string) ([]byte, error) { return p, nil }
+func (p staticPassphrase) Interactive() bool                 { return false }
+
+// TerminalPassphrase reads the passphrase from the terminal without echo
+type TerminalPassphrase struct{}
+
+func (TerminalPassphrase) Passphrase(prompt string) ([]byte, error) {
+	return ReadPassphrase(prompt)
+}
+
+func (TerminalPassphrase) Interactive() bool { return true }
+
+// ReadPassphrase prompts on stderr and reads a line from the terminal
+// with echo disabled
+func ReadPassphrase(prompt string) ([]byte, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return nil, fmt.Errorf("stdin is not a terminal — set %s or use --passphrase-file/--passphrase-fd", PassphraseEnv)
+	}
+	fmt.Fprint(os.Stderr, prompt)
+	secret, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// PassphraseFromFile reads the passphrase from the first line of a file
+func PassphraseFromFile(path string) (PassphraseSource, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read passphrase file: %w", err)
+	}
+	return staticPassphrase(firstLine(data)), nil
+}
+
+// PassphraseFromFD reads the passphrase from an inherited file descriptor
+// e.g. meshnet start --passphrase-fd 3 3< secret
+func PassphraseFromFD(fd int) (PassphraseSource, error) {
+	f := os.NewFile(uintptr(fd), fmt.Sprintf("fd%d", fd))
+	if f == nil {
+		return nil, fmt.Errorf("invalid file descriptor %d", fd)
+	}
+	defer f.Close()
+
+	line, err := bufio.NewReader(f).ReadBytes('\n')
+	if err != nil && len(line) == 0 {
+		return nil, fmt.Errorf("failed to read passphrase from fd %d: %w", fd, err)
+	}
+	return staticPassphrase(firstLine(line)), nil
+}
+
+// DefaultPassphraseSource picks the passphrase source for a command:
+// --passphrase-fd, then --passphrase-file, then $MESHNET_PASSPHRASE,
+// then an interactive terminal prompt
+func DefaultPassphraseSource(file string, fd int) (PassphraseSource, error) {
+	if fd >= 0 {
+		return PassphraseFromFD(fd)
+	}
+	if file != "" {
+		return PassphraseFromFile(file)
+	}
+	if env := os.Getenv(PassphraseEnv); env != "" {
+		return staticPassphrase(env), nil
+	}
+	return TerminalPassphrase{}, nil
+}
+
+func firstLine(data []byte) []byte {
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		data = data[:i]
+	}
+	return bytes.TrimRight(data, "\r")
+}
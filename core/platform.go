@@ -0,0 +1,36 @@
+package core
+
+import (
+	"os"
+	"time"
+)
+
+// Platform is the OS-specific side of running Yggdrasil with a TUN
+// adapter: detecting an OS-managed instance, naming and cleaning up the
+// interface, and stopping the subprocess
+type Platform interface {
+	// ServiceRunning reports whether an OS-managed Yggdrasil is already
+	// providing the TUN interface, so we should use it instead of our own
+	ServiceRunning() bool
+	// ServiceAdmin returns the network and address of that instance's
+	// admin socket
+	ServiceAdmin() (network, addr string)
+	// DefaultBinary is where to look for yggdrasil when --yggdrasil isn't given
+	DefaultBinary() string
+	// IfName is the interface name written to the subprocess config
+	IfName() string
+	// CleanupInterface removes an interface left behind by a previous run
+	// of our own subprocess — never one owned by someone else
+	CleanupInterface()
+	// WaitInterface blocks until the subprocess's interface is usable
+	WaitInterface(timeout time.Duration)
+	// Interrupt asks the subprocess to exit cleanly, if the OS can
+	Interrupt(p *os.Process) error
+	// PrivilegeHint tells the user how to get the rights to create a TUN
+	PrivilegeHint() string
+}
+
+// DefaultYggdrasilBinary is the yggdrasil binary used when none is given
+func DefaultYggdrasilBinary() string {
+	return currentPlatform(0).DefaultBinary()
+}
@@ -0,0 +1,124 @@
+package core
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// linuxIfPrefix names the TUN interfaces our subprocesses create,
+// meshnet0 for the default profile and meshnetN for the others — fixed
+// names so cleanup can never touch an interface we don't own
+const linuxIfPrefix = "meshnet"
+
+// linuxServiceAdminSocket is where a packaged Yggdrasil puts its admin socket
+const linuxServiceAdminSocket = "/var/run/yggdrasil.sock"
+
+// yggdrasilPrefix is 200::/7, the range every Yggdrasil address falls in
+var yggdrasilPrefix = &net.IPNet{IP: net.ParseIP("200::"), Mask: net.CIDRMask(7, 128)}
+
+type linuxPlatform struct {
+	ifName string
+}
+
+func currentPlatform(instance int) Platform {
+	return linuxPlatform{ifName: fmt.Sprintf("%s%d", linuxIfPrefix, instance)}
+}
+
+// isMeshnetInterface reports whether name is one of our subprocesses'
+// interfaces, this profile's or another's
+func isMeshnetInterface(name string) bool {
+	n, ok := strings.CutPrefix(name, linuxIfPrefix)
+	if !ok {
+		return false
+	}
+	_, err := strconv.Atoi(n)
+	return err == nil
+}
+
+// ServiceRunning detects an OS-level Yggdrasil: an active systemd unit,
+// or any TUN interface (other than a MeshNet one) carrying a 200::/7 address
+func (linuxPlatform) ServiceRunning() bool {
+	if exec.Command("systemctl", "is-active", "--quiet", "yggdrasil").Run() == nil {
+		return true
+	}
+	return foreignYggdrasilInterface() != ""
+}
+
+// foreignYggdrasilInterface returns the name of a TUN interface with a
+// Yggdrasil address that we didn't create, or "" if there is none
+func foreignYggdrasilInterface() string {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return ""
+	}
+	for _, iface := range ifaces {
+		if isMeshnetInterface(iface.Name) || iface.Flags&net.FlagUp == 0 {
+			continue
+		}
+		// only TUN devices have tun_flags in sysfs
+		if _, err := os.Stat(filepath.Join("/sys/class/net", iface.Name, "tun_flags")); err != nil {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			if ipnet, ok := a.(*net.IPNet); ok && yggdrasilPrefix.Contains(ipnet.IP) {
+				return iface.Name
+			}
+		}
+	}
+	return ""
+}
+
+func (linuxPlatform) ServiceAdmin() (string, string) {
+	return "unix", linuxServiceAdminSocket
+}
+
+// DefaultBinary prefers a yggdrasil on PATH over the bundled one
+func (linuxPlatform) DefaultBinary() string {
+	if path, err := exec.LookPath("yggdrasil"); err == nil {
+		return path
+	}
+	return "bin/yggdrasil"
+}
+
+func (p linuxPlatform) IfName() string {
+	return p.ifName
+}
+
+// CleanupInterface deletes our interface if a killed subprocess left it behind
+func (p linuxPlatform) CleanupInterface() {
+	if _, err := net.InterfaceByName(p.ifName); err != nil {
+		return
+	}
+	exec.Command("ip", "link", "delete", p.ifName).Run()
+}
+
+// WaitInterface polls until our interface is up
+func (p linuxPlatform) WaitInterface(timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if iface, err := net.InterfaceByName(p.ifName); err == nil && iface.Flags&net.FlagUp != 0 {
+			return
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+// Interrupt sends SIGTERM so the subprocess can remove its interface
+func (linuxPlatform) Interrupt(p *os.Process) error {
+	return p.Signal(syscall.SIGTERM)
+}
+
+func (linuxPlatform) PrivilegeHint() string {
+	return "Run as root, or grant the binary CAP_NET_ADMIN: sudo setcap cap_net_admin+ep $(which yggdrasil)"
+}
@@ -0,0 +1,26 @@
+//go:build !windows && !linux
+
+package core
+
+import (
+	"os"
+	"time"
+)
+
+// genericPlatform runs the subprocess with no OS-specific detection or
+// cleanup — Yggdrasil picks its own interface (utun on macOS), a free
+// one for each profile
+type genericPlatform struct{}
+
+func currentPlatform(int) Platform {
+	return genericPlatform{}
+}
+
+func (genericPlatform) ServiceRunning() bool           { return false }
+func (genericPlatform) ServiceAdmin() (string, string) { return "", "" }
+func (genericPlatform) DefaultBinary() string          { return "bin/yggdrasil" }
+func (genericPlatform) IfName() string                 { return "auto" }
+func (genericPlatform) CleanupInterface()              {}
+func (genericPlatform) WaitInterface(time.Duration)    {}
+func (genericPlatform) Interrupt(p *os.Process) error  { return p.Signal(os.Interrupt) }
+func (genericPlatform) PrivilegeHint() string          { return "Run with sudo" }
@@ -0,0 +1,73 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// windowsAdapterName is what the WinTun adapter is called when IfName is "auto"
+const windowsAdapterName = "Yggdrasil"
+
+// windowsServiceAdminAddr is where the installed Yggdrasil service admin socket listens
+const windowsServiceAdminAddr = "localhost:9001"
+
+// windowsPlatform names the adapter of the default profile's subprocess
+// as Yggdrasil does, and the other profiles' MeshNetN
+type windowsPlatform struct {
+	adapter string
+}
+
+func currentPlatform(instance int) Platform {
+	if instance == 0 {
+		return windowsPlatform{adapter: windowsAdapterName}
+	}
+	return windowsPlatform{adapter: fmt.Sprintf("MeshNet%d", instance)}
+}
+
+// ServiceRunning checks if the Yggdrasil Windows Service is installed
+func (windowsPlatform) ServiceRunning() bool {
+	return exec.Command("sc", "query", "Yggdrasil").Run() == nil
+}
+
+func (windowsPlatform) ServiceAdmin() (string, string) {
+	return "tcp", windowsServiceAdminAddr
+}
+
+func (windowsPlatform) DefaultBinary() string {
+	return "bin/yggdrasil.exe"
+}
+
+func (p windowsPlatform) IfName() string {
+	if p.adapter == windowsAdapterName {
+		return "auto"
+	}
+	return p.adapter
+}
+
+// CleanupInterface deletes a leftover adapter from a previous run
+// prevents "file already exists" on WinTun driver
+func (p windowsPlatform) CleanupInterface() {
+	exec.Command("netsh", "interface", "delete", "interface", p.adapter).Run()
+	time.Sleep(500 * time.Millisecond)
+}
+
+// WaitInterface waits out TUN initialization — the admin socket comes
+// up a few seconds before WinTun is ready and there is nothing to poll
+func (windowsPlatform) WaitInterface(timeout time.Duration) {
+	wait := 5 * time.Second
+	if timeout < wait {
+		wait = timeout
+	}
+	time.Sleep(wait)
+}
+
+// Interrupt kills the subprocess — Windows has no SIGTERM to send
+func (windowsPlatform) Interrupt(p *os.Process) error {
+	return p.Kill()
+}
+
+func (windowsPlatform) PrivilegeHint() string {
+	return "Run PowerShell as Administrator"
+}
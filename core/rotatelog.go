@@ -0,0 +1,109 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// default limits for yggdrasil.log — 4 files of 5 MB at most
+const (
+	defaultLogMaxSize = 5 << 20
+	defaultLogKeep    = 3
+)
+
+// RotatingLog is an append-only log file that is renamed to path.1
+// (path.1 to path.2 and so on) once it grows past maxSize, keeping at
+// most keep old files. Safe for concurrent writers.
+type RotatingLog struct {
+	path    string
+	maxSize int64
+	keep    int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// OpenRotatingLog opens path for appending, rotating first if it is
+// already over maxSize
+func OpenRotatingLog(path string, maxSize int64, keep int) (*RotatingLog, error) {
+	l := &RotatingLog{path: path, maxSize: maxSize, keep: keep}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	if l.size >= l.maxSize {
+		if err := l.rotate(); err != nil {
+			l.file.Close()
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+func (l *RotatingLog) open() error {
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open log: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to stat log: %w", err)
+	}
+	l.file = f
+	l.size = info.Size()
+	return nil
+}
+
+// rotate shifts path.N-1 → path.N … path → path.1 and reopens path
+func (l *RotatingLog) rotate() error {
+	l.file.Close()
+	l.file = nil
+
+	if l.keep < 1 {
+		os.Remove(l.path)
+	} else {
+		os.Remove(fmt.Sprintf("%s.%d", l.path, l.keep))
+		for i := l.keep - 1; i >= 1; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", l.path, i), fmt.Sprintf("%s.%d", l.path, i+1))
+		}
+		if err := os.Rename(l.path, l.path+".1"); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to rotate log: %w", err)
+		}
+	}
+	return l.open()
+}
+
+// Write appends p, rotating beforehand if p would push the file past
+// maxSize. A single write is never split across files.
+func (l *RotatingLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		if err := l.open(); err != nil {
+			return 0, err
+		}
+	}
+	if l.size > 0 && l.size+int64(len(p)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := l.file.Write(p)
+	l.size += int64(n)
+	return n, err
+}
+
+// Close closes the current file
+func (l *RotatingLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
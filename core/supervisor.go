@@ -0,0 +1,256 @@
+package core
+
+import (
+	"fmt"
+	"time"
+
+	"meshnet/yggadmin"
+)
+
+// YggState is where the Yggdrasil subprocess is in its lifecycle
+type YggState string
+
+const (
+	YggStarting   YggState = "starting"
+	YggRunning    YggState = "running"
+	YggUnhealthy  YggState = "unhealthy"  // admin socket not answering
+	YggRestarting YggState = "restarting" // crashed or killed, waiting to relaunch
+	YggFailed     YggState = "failed"
+	YggStopped    YggState = "stopped"
+	YggExternal   YggState = "external" // an installed service owns the TUN
+)
+
+// supervisor tuning
+const (
+	yggHealthInterval = 10 * time.Second
+	yggHealthTimeout  = 3 * time.Second
+	// consecutive failed health checks before the process is restarted
+	yggHealthFailures = 3
+	yggBackoffMin     = time.Second
+	yggBackoffMax     = 2 * time.Minute
+	// a restarted process that stays up this long resets the backoff
+	yggStableAfter = 5 * time.Minute
+	// transitions kept for the API
+	yggEventHistory = 20
+)
+
+// YggEvent is one state transition
+type YggEvent struct {
+	Time   time.Time `json:"time"`
+	State  YggState  `json:"state"`
+	Detail string    `json:"detail,omitempty"`
+}
+
+// YggStatus is a snapshot of the supervised subprocess
+type YggStatus struct {
+	State     YggState   `json:"state"`
+	Since     time.Time  `json:"since"`
+	Restarts  int        `json:"restarts"`
+	LastError string     `json:"last_error,omitempty"`
+	PID       int        `json:"pid,omitempty"`
+	Events    []YggEvent `json:"events"`
+}
+
+// SetStateHandler registers fn to be called on every state transition
+func (s *YggService) SetStateHandler(fn func(YggEvent)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onState = fn
+}
+
+// Status returns the current state and recent transitions
+func (s *YggService) Status() YggStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.status
+	st.Events = append([]YggEvent(nil), s.status.Events...)
+	if s.proc != nil {
+		select {
+		case <-s.proc.exited:
+		default:
+			st.PID = s.proc.cmd.Process.Pid
+		}
+	}
+	return st
+}
+
+func (s *YggService) setState(state YggState, detail string) {
+	ev := YggEvent{Time: time.Now(), State: state, Detail: detail}
+
+	s.mu.Lock()
+	if s.status.State != state {
+		s.status.Since = ev.Time
+	}
+	s.status.State = state
+	if detail != "" && (state == YggUnhealthy || state == YggRestarting || state == YggFailed) {
+		s.status.LastError = detail
+	}
+	s.status.Events = append(s.status.Events, ev)
+	if len(s.status.Events) > yggEventHistory {
+		s.status.Events = s.status.Events[len(s.status.Events)-yggEventHistory:]
+	}
+	fn := s.onState
+	s.mu.Unlock()
+
+	if fn != nil {
+		fn(ev)
+	}
+}
+
+// Supervise health-checks Yggdrasil's admin socket in the background
+// and relaunches our subprocess with exponential backoff when it
+// crashes or stops answering. An installed service is only watched —
+// it belongs to the OS, so we re-add our peers when it comes back.
+// Call after a successful Start; Stop ends supervision.
+func (s *YggService) Supervise() {
+	s.supervisor.Add(1)
+	go func() {
+		defer s.supervisor.Done()
+		s.mu.Lock()
+		external := s.proc == nil
+		s.mu.Unlock()
+		if external {
+			s.watchService()
+		} else {
+			s.superviseProcess()
+		}
+	}()
+}
+
+// healthCheck asks the admin socket for getSelf
+func (s *YggService) healthCheck() error {
+	client, err := yggadmin.Dial(s.AdminEndpoint(), yggHealthTimeout)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	_, err = client.GetSelf()
+	return err
+}
+
+func (s *YggService) superviseProcess() {
+	ticker := time.NewTicker(yggHealthInterval)
+	defer ticker.Stop()
+
+	backoff := yggBackoffMin
+	failures := 0
+
+	for {
+		s.mu.Lock()
+		proc := s.proc
+		s.mu.Unlock()
+
+		select {
+		case <-s.stopping:
+			return
+
+		case <-proc.exited:
+			s.mu.Lock()
+			reload := s.reloading
+			s.reloading = false
+			s.mu.Unlock()
+			if reload {
+				s.setState(YggRestarting, "reloading config")
+				if !s.relaunch(0) {
+					return
+				}
+				continue
+			}
+
+			detail := "exited"
+			if proc.err != nil {
+				detail = proc.err.Error()
+			}
+			s.setState(YggRestarting, "yggdrasil "+detail)
+
+		case <-ticker.C:
+			err := s.healthCheck()
+			if err == nil {
+				failures = 0
+				st := s.Status()
+				if st.State == YggUnhealthy {
+					s.setState(YggRunning, "admin socket answering again")
+				}
+				if time.Since(st.Since) > yggStableAfter {
+					backoff = yggBackoffMin
+				}
+				continue
+			}
+			failures++
+			s.setState(YggUnhealthy, fmt.Sprintf("health check %d/%d: %v", failures, yggHealthFailures, err))
+			if failures < yggHealthFailures {
+				continue
+			}
+			proc.stop(s.platform)
+			s.setState(YggRestarting, "not answering — killed")
+		}
+
+		failures = 0
+		if !s.relaunch(backoff) {
+			return
+		}
+		backoff *= 2
+		if backoff > yggBackoffMax {
+			backoff = yggBackoffMax
+		}
+	}
+}
+
+// relaunch starts the subprocess again, first after wait and then with
+// growing delays until it comes up. Returns false if stopped meanwhile.
+func (s *YggService) relaunch(wait time.Duration) bool {
+	for {
+		select {
+		case <-s.stopping:
+			return false
+		case <-time.After(wait):
+		}
+		wait *= 2
+		if wait < yggBackoffMin {
+			wait = yggBackoffMin
+		}
+		if wait > yggBackoffMax {
+			wait = yggBackoffMax
+		}
+
+		err := s.launch(nil)
+		if err == nil {
+			break
+		}
+		select {
+		case <-s.stopping:
+			return false
+		default:
+		}
+		s.setState(YggRestarting, fmt.Sprintf("%v — retrying in %s", err, wait))
+	}
+
+	s.mu.Lock()
+	s.status.Restarts++
+	s.mu.Unlock()
+	s.setState(YggRunning, "restarted")
+	return true
+}
+
+func (s *YggService) watchService() {
+	ticker := time.NewTicker(yggHealthInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stopping:
+			return
+		case <-ticker.C:
+		}
+
+		err := s.healthCheck()
+		down := s.Status().State == YggUnhealthy
+		switch {
+		case err != nil && !down:
+			s.setState(YggUnhealthy, "installed service: "+err.Error())
+		case err == nil && down:
+			s.addPeersViaAdmin()
+			s.setState(YggExternal, "installed service answering again")
+		}
+	}
+}
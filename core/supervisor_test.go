@@ -0,0 +1,270 @@
+package core
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// The test binary doubles as a stub yggdrasil: run with stubEnv set, it
+// reads the config it's given and answers getSelf on its admin socket
+// with the config's key. With stubCrashEnv naming a file that doesn't
+// exist yet, it creates the file and exits a little after starting —
+// so it crashes once, then stays up.
+const (
+	stubEnv      = "MESHNET_STUB_YGGDRASIL"
+	stubCrashEnv = "MESHNET_STUB_CRASH_ONCE"
+)
+
+func TestMain(m *testing.M) {
+	if os.Getenv(stubEnv) != "" {
+		runStubYggdrasil()
+		return
+	}
+	os.Exit(m.Run())
+}
+
+func runStubYggdrasil() {
+	var cfgPath string
+	for i, arg := range os.Args {
+		if arg == "-useconffile" && i+1 < len(os.Args) {
+			cfgPath = os.Args[i+1]
+		}
+	}
+	data, err := os.ReadFile(cfgPath)
+	if err != nil {
+		os.Exit(1)
+	}
+	var cfg yggConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		os.Exit(1)
+	}
+	privKey, err := hex.DecodeString(cfg.PrivateKey)
+	if err != nil || len(privKey) != ed25519.PrivateKeySize {
+		os.Exit(1)
+	}
+	pub := ed25519.PrivateKey(privKey).Public().(ed25519.PublicKey)
+
+	l, err := net.Listen("tcp", strings.TrimPrefix(cfg.AdminListen, "tcp://"))
+	if err != nil {
+		os.Exit(1)
+	}
+	if marker := os.Getenv(stubCrashEnv); marker != "" {
+		if _, err := os.Stat(marker); os.IsNotExist(err) {
+			os.WriteFile(marker, nil, 0644)
+			time.AfterFunc(1500*time.Millisecond, func() { os.Exit(1) })
+		}
+	}
+	serveAdmin(l, hex.EncodeToString(pub), nil)
+}
+
+// adminRequest is a request as the yggadmin client sends it
+type adminRequest struct {
+	Name      string          `json:"request"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+// serveAdmin answers admin requests on l until it is closed: getSelf
+// with key, anything else with an empty success. seen hears of each
+// request if not nil.
+func serveAdmin(l net.Listener, key string, seen func(adminRequest)) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func(conn net.Conn) {
+			defer conn.Close()
+			dec := json.NewDecoder(conn)
+			enc := json.NewEncoder(conn)
+			for {
+				var req adminRequest
+				if err := dec.Decode(&req); err != nil {
+					return
+				}
+				if seen != nil {
+					seen(req)
+				}
+				res := map[string]interface{}{"status": "success", "response": map[string]interface{}{}}
+				if req.Name == "getSelf" {
+					res["response"] = map[string]string{"key": key, "address": "200::1"}
+				}
+				enc.Encode(res)
+			}
+		}(conn)
+	}
+}
+
+// stubPlatform runs no real TUN and counts interface cleanups
+type stubPlatform struct {
+	installed   bool
+	serviceAddr string
+	cleanups    atomic.Int32
+}
+
+func (p *stubPlatform) ServiceRunning() bool             { return p.installed }
+func (p *stubPlatform) ServiceAdmin() (string, string)   { return "tcp", p.serviceAddr }
+func (p *stubPlatform) DefaultBinary() string            { return "" }
+func (p *stubPlatform) IfName() string                   { return "none" }
+func (p *stubPlatform) CleanupInterface()                { p.cleanups.Add(1) }
+func (p *stubPlatform) WaitInterface(time.Duration)      {}
+func (p *stubPlatform) Interrupt(proc *os.Process) error { return proc.Signal(os.Interrupt) }
+func (p *stubPlatform) PrivilegeHint() string            { return "" }
+
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// newStubService returns a service that launches the stub yggdrasil,
+// with its config written for a fresh key
+func newStubService(t *testing.T, p *stubPlatform) (*YggService, ed25519.PublicKey) {
+	t.Helper()
+	bin, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(stubEnv, "1")
+
+	dir := t.TempDir()
+	s := NewYggService(bin, filepath.Join(dir, "yggdrasil.conf"), filepath.Join(dir, "yggdrasil.log"))
+	s.SetPlatform(p)
+	s.SetAdminAddrs(freeAddr(t), "tcp", p.serviceAddr)
+
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	if err := s.WriteConfig(PrivKeyHex(priv)); err != nil {
+		t.Fatal(err)
+	}
+	return s, pub
+}
+
+func TestYggServiceStub(t *testing.T) {
+	p := &stubPlatform{}
+	s, pub := newStubService(t, p)
+
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if st := s.Status(); st.State != YggRunning || st.PID == 0 {
+		t.Errorf("after Start: state %s, pid %d", st.State, st.PID)
+	}
+	key, err := s.GetPublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key != hex.EncodeToString(pub) {
+		t.Errorf("stub runs on %s, want the configured key", key)
+	}
+
+	s.Stop()
+	if st := s.Status(); st.State != YggStopped || st.PID != 0 {
+		t.Errorf("after Stop: state %s, pid %d", st.State, st.PID)
+	}
+	// once before launching, once after stopping
+	if got := p.cleanups.Load(); got != 2 {
+		t.Errorf("interface cleaned up %d times, want 2", got)
+	}
+}
+
+func TestSupervisorRestartsCrashed(t *testing.T) {
+	if testing.Short() {
+		t.Skip("waits out a restart backoff")
+	}
+	p := &stubPlatform{}
+	s, _ := newStubService(t, p)
+	t.Setenv(stubCrashEnv, filepath.Join(t.TempDir(), "crashed"))
+
+	var mu sync.Mutex
+	var states []YggState
+	s.SetStateHandler(func(ev YggEvent) {
+		mu.Lock()
+		states = append(states, ev.State)
+		mu.Unlock()
+	})
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	firstPID := s.Status().PID
+	s.Supervise()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for s.Status().Restarts == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("not restarted, last error %q", s.Status().LastError)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	st := s.Status()
+	if st.State != YggRunning || st.PID == 0 || st.PID == firstPID {
+		t.Errorf("after restart: state %s, pid %d (first %d)", st.State, st.PID, firstPID)
+	}
+	if !strings.Contains(st.LastError, "exit status 1") {
+		t.Errorf("last error %q, want the crash", st.LastError)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []YggState{YggStarting, YggRunning, YggRestarting, YggRunning}
+	if !reflect.DeepEqual(states, want) {
+		t.Errorf("states %v, want %v", states, want)
+	}
+}
+
+func TestYggServiceInstalled(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	var mu sync.Mutex
+	var added []string
+	go serveAdmin(l, "servicekey", func(req adminRequest) {
+		if req.Name != "addPeer" {
+			return
+		}
+		var args struct {
+			URI string `json:"uri"`
+		}
+		json.Unmarshal(req.Arguments, &args)
+		mu.Lock()
+		added = append(added, args.URI)
+		mu.Unlock()
+	})
+
+	p := &stubPlatform{installed: true, serviceAddr: l.Addr().String()}
+	s := NewYggService("/nonexistent/yggdrasil", filepath.Join(t.TempDir(), "yggdrasil.conf"), "")
+	s.SetPlatform(p)
+	s.SetPeers([]string{"tls://a.example:443", "tcp://b.example:9001"})
+
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	if st := s.Status(); st.State != YggExternal {
+		t.Errorf("state %s, want %s", st.State, YggExternal)
+	}
+	if key, err := s.GetPublicKey(); err != nil || key != "servicekey" {
+		t.Errorf("got key %q, %v from the installed service", key, err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(added) != 2 || added[0] != "tls://a.example:443" || added[1] != "tcp://b.example:9001" {
+		t.Errorf("peers added to the service: %v", added)
+	}
+}
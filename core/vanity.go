@@ -0,0 +1,258 @@
+package core
+
+import (
+	"context"
+	"crypto/ed25519"
+	"fmt"
+	"math"
+	"math/bits"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/address"
+)
+
+// maxAddressStrength is the largest strength a single address byte can carry
+const maxAddressStrength = 255
+
+// VanityCriteria describes the address a generated key must have
+type VanityCriteria struct {
+	// Prefix the address must start with, in its usual written form
+	// e.g. "203:abcd" — 'x' matches any hex digit
+	Prefix string
+	// MinStrength is the least number of leading zero bits of the key,
+	// which is the byte after the 2 in the address (2xx)
+	MinStrength int
+
+	groups []string
+}
+
+// VanityProgress is reported periodically while searching
+type VanityProgress struct {
+	Attempts uint64
+	Rate     float64 // keys per second
+	Chance   float64 // probability a match should have been found by now
+	ETA      time.Duration
+}
+
+// AddressStrength returns how many leading zero bits pubKey has —
+// Yggdrasil encodes it in the 2xx part of the address, and stronger
+// addresses are both rarer and shorter when written out
+func AddressStrength(pubKey ed25519.PublicKey) int {
+	n := 0
+	for _, b := range pubKey {
+		if b != 0 {
+			return n + bits.LeadingZeros8(b)
+		}
+		n += 8
+	}
+	return n
+}
+
+// Validate parses the prefix and checks the criteria can be satisfied
+func (c *VanityCriteria) Validate() error {
+	if c.MinStrength < 0 || c.MinStrength > maxAddressStrength {
+		return fmt.Errorf("strength must be between 0 and %d", maxAddressStrength)
+	}
+	c.groups = nil
+	if c.Prefix == "" {
+		return nil
+	}
+
+	prefix := strings.ToLower(c.Prefix)
+	if strings.Contains(prefix, "::") {
+		return fmt.Errorf("prefix cannot use :: — write every group out")
+	}
+	groups := strings.Split(prefix, ":")
+	if len(groups) > 8 {
+		return fmt.Errorf("prefix has more than 8 groups")
+	}
+	for i, g := range groups {
+		if g == "" || len(g) > 4 {
+			return fmt.Errorf("prefix group %d must be 1-4 hex digits", i+1)
+		}
+		for _, r := range g {
+			if !strings.ContainsRune("0123456789abcdefx", r) {
+				return fmt.Errorf("prefix group %d: %q is not a hex digit or x", i+1, r)
+			}
+		}
+	}
+	if groups[0][0] != '2' && groups[0][0] != 'x' {
+		return fmt.Errorf("Yggdrasil addresses start with 2")
+	}
+	if len(groups) > 1 && len(groups[0]) != 3 {
+		return fmt.Errorf("first group must be written as 2xx")
+	}
+	c.groups = groups
+
+	if c.strengthProbability() == 0 {
+		return fmt.Errorf("prefix %s cannot have strength %d or more", c.Prefix, c.MinStrength)
+	}
+	return nil
+}
+
+// Matches reports whether pubKey gives an address meeting the criteria
+func (c *VanityCriteria) Matches(pubKey ed25519.PublicKey) bool {
+	// strength is cheap to check, so it filters before deriving the address
+	if AddressStrength(pubKey) < c.MinStrength {
+		return false
+	}
+	if len(c.groups) == 0 {
+		return true
+	}
+
+	addr := address.AddrForKey(pubKey)
+	for i, pattern := range c.groups {
+		value := uint16(addr[2*i])<<8 | uint16(addr[2*i+1])
+		if !matchGroup(pattern, value, i == len(c.groups)-1) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchGroup compares one address group against a pattern. Complete
+// groups are compared zero-padded; the last group is a prefix of the
+// group as written, so "200:ab" matches 200:ab12.
+func matchGroup(pattern string, value uint16, last bool) bool {
+	var written string
+	if last {
+		written = strconv.FormatUint(uint64(value), 16)
+		if len(pattern) > len(written) {
+			return false
+		}
+	} else {
+		written = fmt.Sprintf("%04x", value)
+		pattern = strings.Repeat("0", 4-len(pattern)) + pattern
+	}
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] != 'x' && pattern[i] != written[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// strengthProbability is the chance a random key has a strength that
+// both satisfies MinStrength and matches the first prefix group
+func (c *VanityCriteria) strengthProbability() float64 {
+	p := 0.0
+	for s := c.MinStrength; s <= maxAddressStrength; s++ {
+		if len(c.groups) > 0 && !matchGroup(c.groups[0], 0x200|uint16(s), len(c.groups) == 1) {
+			continue
+		}
+		// strength exactly s: s zero bits then a one bit
+		p += math.Pow(2, -float64(s+1))
+	}
+	return p
+}
+
+// ExpectedAttempts estimates how many keys the search needs on average
+func (c *VanityCriteria) ExpectedAttempts() float64 {
+	p := c.strengthProbability()
+	if len(c.groups) > 1 {
+		for _, g := range c.groups[1:] {
+			p /= math.Pow(16, float64(len(g)-strings.Count(g, "x")))
+		}
+	}
+	if p == 0 {
+		return math.Inf(1)
+	}
+	return 1 / p
+}
+
+// SearchVanityKey generates keys on workers goroutines (0 means one per
+// CPU) until one matches. progress, if set, is called about once a second.
+func SearchVanityKey(ctx context.Context, c VanityCriteria, workers int, progress func(VanityProgress)) (ed25519.PrivateKey, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var attempts atomic.Uint64
+	found := make(chan ed25519.PrivateKey, 1)
+	errs := make(chan error, workers)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var local uint64
+			for {
+				// check for cancellation in batches — the channel is
+				// far more expensive than generating a key
+				if local%256 == 0 {
+					attempts.Add(256)
+					select {
+					case <-ctx.Done():
+						return
+					default:
+					}
+				}
+				local++
+
+				pubKey, privKey, err := ed25519.GenerateKey(nil)
+				if err != nil {
+					errs <- fmt.Errorf("failed to generate key: %w", err)
+					return
+				}
+				if c.Matches(pubKey) {
+					select {
+					case found <- privKey:
+					default:
+					}
+					cancel()
+					return
+				}
+			}
+		}()
+	}
+	defer wg.Wait()
+
+	expected := c.ExpectedAttempts()
+	start := time.Now()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case key := <-found:
+			return key, nil
+		case err := <-errs:
+			return nil, err
+		case <-ctx.Done():
+			// a worker may have found a key just as we were cancelled
+			select {
+			case key := <-found:
+				return key, nil
+			default:
+			}
+			return nil, ctx.Err()
+		case <-ticker.C:
+			if progress == nil {
+				continue
+			}
+			n := attempts.Load()
+			rate := float64(n) / time.Since(start).Seconds()
+			p := VanityProgress{
+				Attempts: n,
+				Rate:     rate,
+				Chance:   1 - math.Exp(-float64(n)/expected),
+			}
+			if remaining := expected - float64(n); remaining > 0 && rate > 0 {
+				p.ETA = time.Duration(remaining / rate * float64(time.Second))
+			}
+			progress(p)
+		}
+	}
+}
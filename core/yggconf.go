@@ -0,0 +1,192 @@
+package core
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"meshnet/hjson"
+)
+
+// YggdrasilConfEnv names an Yggdrasil config to read the identity from
+const YggdrasilConfEnv = "YGGDRASIL_CONF"
+
+// Errors returned by Yggdrasil config discovery — match with errors.Is
+var (
+	ErrYggdrasilNotInstalled     = errors.New("yggdrasil config not found")
+	ErrYggdrasilConfigUnreadable = errors.New("yggdrasil config unreadable")
+	ErrYggdrasilConfigMalformed  = errors.New("yggdrasil config malformed")
+)
+
+// yggdrasilConfigPaths lists where an installed Yggdrasil keeps its config
+func yggdrasilConfigPaths() []string {
+	if runtime.GOOS == "windows" {
+		return []string{`C:\ProgramData\Yggdrasil\yggdrasil.conf`}
+	}
+	return []string{
+		"/etc/yggdrasil.conf",
+		"/etc/yggdrasil/yggdrasil.conf",
+	}
+}
+
+// FindYggdrasilConfig resolves which Yggdrasil config to use: path if
+// given (--yggdrasil-conf), then $YGGDRASIL_CONF, then the platform
+// defaults. explicit reports whether the user named the file.
+func FindYggdrasilConfig(path string) (found string, explicit bool, err error) {
+	if path == "" {
+		path = os.Getenv(YggdrasilConfEnv)
+	}
+	if path != "" {
+		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			return "", true, fmt.Errorf("%w at %s", ErrYggdrasilNotInstalled, path)
+		}
+		return path, true, nil
+	}
+
+	for _, candidate := range yggdrasilConfigPaths() {
+		// anything but "does not exist" means it is there — a permission
+		// error is reported as unreadable when we try to open it
+		if _, err := os.Stat(candidate); !errors.Is(err, os.ErrNotExist) {
+			return candidate, false, nil
+		}
+	}
+	return "", false, ErrYggdrasilNotInstalled
+}
+
+// ReadYggdrasilKey extracts the ed25519 private key from an Yggdrasil
+// config. Handles v0.4+ PrivateKey, v0.5 PrivateKeyPath (PEM) and the
+// v0.3 SigningPrivateKey/EncryptionPrivateKey era.
+func ReadYggdrasilKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("%w at %s", ErrYggdrasilNotInstalled, path)
+		}
+		return nil, fmt.Errorf("%w: %v", ErrYggdrasilConfigUnreadable, err)
+	}
+
+	doc, err := hjson.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrYggdrasilConfigMalformed, path, err)
+	}
+	cfg, ok := doc.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%w: %s: top level is not an object", ErrYggdrasilConfigMalformed, path)
+	}
+
+	privKey, err := yggdrasilKeyFromConfig(cfg, filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+
+	// v0.4+ configs also carry the public key — catch hand-edited mismatches
+	if pubHex, ok := cfg["PublicKey"].(string); ok && pubHex != "" {
+		if hex.EncodeToString(privKey.Public().(ed25519.PublicKey)) != pubHex {
+			return nil, fmt.Errorf("%w: %s: PublicKey does not match private key",
+				ErrYggdrasilConfigMalformed, path)
+		}
+	}
+	return privKey, nil
+}
+
+func yggdrasilKeyFromConfig(cfg map[string]interface{}, dir string) (ed25519.PrivateKey, error) {
+	if keyHex, ok := cfg["PrivateKey"]; ok {
+		return parseYggdrasilHexKey("PrivateKey", keyHex)
+	}
+
+	if keyPath, ok := cfg["PrivateKeyPath"].(string); ok && keyPath != "" {
+		if !filepath.IsAbs(keyPath) {
+			keyPath = filepath.Join(dir, keyPath)
+		}
+		return readYggdrasilPEMKey(keyPath)
+	}
+
+	// v0.3 configs split the identity into two keys — the signing key is
+	// the ed25519 one that v0.4's config migration promoted to PrivateKey
+	if keyHex, ok := cfg["SigningPrivateKey"]; ok {
+		return parseYggdrasilHexKey("SigningPrivateKey", keyHex)
+	}
+	if _, ok := cfg["EncryptionPrivateKey"]; ok {
+		return nil, fmt.Errorf("%w: only EncryptionPrivateKey present — curve25519 keys cannot be used as an identity",
+			ErrYggdrasilConfigMalformed)
+	}
+
+	return nil, fmt.Errorf("%w: no PrivateKey, PrivateKeyPath or SigningPrivateKey", ErrYggdrasilConfigMalformed)
+}
+
+func parseYggdrasilHexKey(field string, value interface{}) (ed25519.PrivateKey, error) {
+	keyHex, ok := value.(string)
+	if !ok || keyHex == "" {
+		return nil, fmt.Errorf("%w: %s is not a hex string", ErrYggdrasilConfigMalformed, field)
+	}
+
+	keyBytes, err := hex.DecodeString(keyHex)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid %s: %v", ErrYggdrasilConfigMalformed, field, err)
+	}
+
+	switch len(keyBytes) {
+	case ed25519.PrivateKeySize:
+		return ed25519.PrivateKey(keyBytes), nil
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(keyBytes), nil
+	default:
+		return nil, fmt.Errorf("%w: %s has length %d, expected %d",
+			ErrYggdrasilConfigMalformed, field, len(keyBytes), ed25519.PrivateKeySize)
+	}
+}
+
+func readYggdrasilPEMKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: PrivateKeyPath: %v", ErrYggdrasilConfigUnreadable, err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%w: %s is not PEM encoded", ErrYggdrasilConfigMalformed, path)
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrYggdrasilConfigMalformed, path, err)
+	}
+	privKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s does not hold an ed25519 key", ErrYggdrasilConfigMalformed, path)
+	}
+	return privKey, nil
+}
+
+// tryReadYggdrasilIdentity attempts to read the keypair from an installed
+// Yggdrasil instance. Returns nil, nil, nil if not found — caller falls back.
+// An explicitly configured path must work; a default location that is
+// merely unreadable (root-only /etc on Linux) falls back with a note.
+func tryReadYggdrasilIdentity(path string) (ed25519.PublicKey, ed25519.PrivateKey, error) {
+	confPath, explicit, err := FindYggdrasilConfig(path)
+	if err != nil {
+		if errors.Is(err, ErrYggdrasilNotInstalled) && !explicit {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+
+	privKey, err := ReadYggdrasilKey(confPath)
+	if err != nil {
+		if errors.Is(err, ErrYggdrasilConfigUnreadable) && !explicit {
+			fmt.Printf("Note: %v — using MeshNet identity instead\n", err)
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+
+	pubKey := privKey.Public().(ed25519.PublicKey)
+	fmt.Println("Identity loaded from installed Yggdrasil:", confPath)
+	return pubKey, privKey, nil
+}
@@ -7,65 +7,215 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sync"
 	"time"
-)
-
-// yggConfigFile is where we write the Yggdrasil config
-const yggConfigFile = "yggdrasil-meshnet.conf"
 
-// yggAdminAddr is where the installed Yggdrasil service admin socket listens
-const yggAdminAddr = "localhost:9001"
+	"meshnet/yggadmin"
+)
 
-// yggSubprocessAdminAddr is where our subprocess admin socket listens
+// yggSubprocessAdminAddr is where our subprocess admin socket listens,
+// unless SetInstance moves it
 const yggSubprocessAdminAddr = "localhost:9091"
 
-// yggLogFile is where subprocess output goes — keeps our CLI output clean
-const yggLogFile = "yggdrasil.log"
+// yggStopTimeout is how long the subprocess gets to exit cleanly
+const yggStopTimeout = 5 * time.Second
 
 // yggConfig is the minimal Yggdrasil configuration we need
 type yggConfig struct {
 	PrivateKey  string   `json:"PrivateKey"`
 	Peers       []string `json:"Peers"`
+	Listen      []string `json:"Listen,omitempty"`
 	IfName      string   `json:"IfName"`
 	IfMTU       int      `json:"IfMTU"`
 	AdminListen string   `json:"AdminListen"`
+	// always written — left out, Yggdrasil would multicast on every interface
+	MulticastInterfaces []MulticastInterface   `json:"MulticastInterfaces"`
+	AllowedPublicKeys   []string               `json:"AllowedPublicKeys"`
+	NodeInfo            map[string]interface{} `json:"NodeInfo,omitempty"`
 }
 
 // YggService manages Yggdrasil as a subprocess
 // handles config generation, start, stop, and status
 type YggService struct {
-	cmd     *exec.Cmd
-	binPath string
-	cfgPath string
-	logFile *os.File
+	platform Platform
+	binPath  string
+	cfgPath  string
+	logPath  string
+	peers    []string
+	listen   []string
+	mcastIfs []MulticastInterface
+	nodeInfo map[string]interface{}
+	private  bool
+	allowed  []string
+	privKey  string // hex, kept to rewrite the config on reload
+
+	log        *RotatingLog
+	logMaxSize int64
+	logKeep    int
+
+	adminAddr    string // our subprocess
+	serviceNet   string // an OS-managed instance
+	serviceAdmin string
+
+	mu     sync.Mutex
+	proc   *yggProc // nil when deferring to an installed service
+	status YggStatus
+
+	reloading  bool // set by Reload so the supervisor skips the backoff
+	onState    func(YggEvent)
+	stopping   chan struct{}
+	stopOnce   sync.Once
+	supervisor sync.WaitGroup
+}
+
+// NewYggService creates a new YggService for the current platform
+// binPath is the yggdrasil binary, cfgPath is where we write its config
+// and logPath is where subprocess output goes — keeps our CLI output clean
+func NewYggService(binPath, cfgPath, logPath string) *YggService {
+	s := &YggService{
+		binPath:    binPath,
+		cfgPath:    cfgPath,
+		logPath:    logPath,
+		logMaxSize: defaultLogMaxSize,
+		logKeep:    defaultLogKeep,
+		adminAddr:  yggSubprocessAdminAddr,
+		stopping:   make(chan struct{}),
+	}
+	s.SetPlatform(currentPlatform(0))
+	return s
+}
+
+// SetInstance keeps the subprocesses of several profiles on one machine
+// apart: the nth profile's gets the nth interface name, where the OS
+// lets us name it, and an admin socket on adminPort. Replaces the
+// platform, so it goes before SetPlatform and SetAdminAddrs.
+func (s *YggService) SetInstance(n, adminPort int) {
+	s.SetPlatform(currentPlatform(n))
+	s.adminAddr = fmt.Sprintf("localhost:%d", adminPort)
+}
+
+// SetPlatform replaces the OS backend, e.g. with a stub for testing
+func (s *YggService) SetPlatform(p Platform) {
+	s.platform = p
+	s.serviceNet, s.serviceAdmin = p.ServiceAdmin()
+}
+
+// SetAdminAddrs overrides where the subprocess admin socket listens (tcp)
+// and where an OS-managed instance's is (network "tcp" or "unix")
+func (s *YggService) SetAdminAddrs(subprocess, serviceNetwork, service string) {
+	s.adminAddr = subprocess
+	s.serviceNet, s.serviceAdmin = serviceNetwork, service
+}
+
+// SetLogRotation sets the size at which yggdrasil.log is rotated and how
+// many old files are kept
+func (s *YggService) SetLogRotation(maxSize int64, keep int) {
+	s.logMaxSize, s.logKeep = maxSize, keep
+}
+
+// PrivilegeHint tells the user how to get the rights TUN mode needs
+func (s *YggService) PrivilegeHint() string {
+	return s.platform.PrivilegeHint()
+}
+
+// SetPeers sets the peers written to the subprocess config, or added
+// through the admin socket when an installed service is used
+func (s *YggService) SetPeers(peers []string) {
+	s.peers = peers
+}
+
+// SetListen sets the URIs the subprocess accepts peerings on
+func (s *YggService) SetListen(uris []string) {
+	s.listen = uris
 }
 
-// NewYggService creates a new YggService
-// binPath is the path to yggdrasil.exe
-func NewYggService(binPath string) *YggService {
-	return &YggService{
-		binPath: binPath,
-		cfgPath: yggConfigFile,
+// SetMulticast sets the interfaces the subprocess discovers LAN peers on
+func (s *YggService) SetMulticast(ifaces []MulticastInterface) {
+	s.mcastIfs = ifaces
+}
+
+// SetNodeInfo sets the NodeInfo written to the subprocess config
+func (s *YggService) SetNodeInfo(info map[string]interface{}) {
+	s.nodeInfo = info
+}
+
+// SetPrivate restricts inbound peerings to the given public keys (hex)
+func (s *YggService) SetPrivate(keys []string) {
+	s.private = true
+	s.allowed = keys
+}
+
+// UpdateAllowedKeys rewrites the private-mode allow-list and restarts
+// our subprocess to apply it. An installed service's config is not
+// ours to change, so that is an error.
+func (s *YggService) UpdateAllowedKeys(keys []string) error {
+	s.mu.Lock()
+	if !s.private || sameKeys(s.allowed, keys) {
+		s.mu.Unlock()
+		return nil
+	}
+	if s.proc == nil {
+		s.mu.Unlock()
+		return fmt.Errorf("the installed Yggdrasil service manages its own AllowedPublicKeys")
+	}
+	s.allowed = keys
+	err := s.writeConfigLocked(s.privKey)
+	s.mu.Unlock()
+	if err != nil {
+		return err
 	}
+	return s.Reload()
+}
+
+// Reload restarts the subprocess so it rereads its config
+// the supervisor relaunches it at once rather than after a backoff
+func (s *YggService) Reload() error {
+	s.mu.Lock()
+	proc := s.proc
+	s.reloading = true
+	s.mu.Unlock()
+	if proc == nil {
+		return fmt.Errorf("no subprocess to reload")
+	}
+	proc.stop(s.platform)
+	return nil
+}
+
+// allowedKeys is what goes in AllowedPublicKeys, s.mu held. Empty means
+// "allow everyone" to Yggdrasil, so private mode with no contacts lists
+// our own key to shut everyone else out.
+func (s *YggService) allowedKeys() []string {
+	if !s.private {
+		return []string{}
+	}
+	keys := append([]string{}, s.allowed...)
+	if len(keys) == 0 && len(s.privKey) == 128 {
+		keys = append(keys, s.privKey[64:])
+	}
+	return keys
 }
 
 // WriteConfig generates a Yggdrasil config file from our identity
 // same private key = same Yggdrasil address = one unified identity
 func (s *YggService) WriteConfig(privKeyHex string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.writeConfigLocked(privKeyHex)
+}
+
+func (s *YggService) writeConfigLocked(privKeyHex string) error {
+	s.privKey = privKeyHex
 	cfg := yggConfig{
-		PrivateKey: privKeyHex,
-		Peers: []string{
-			"tls://62.210.85.80:39575",  // france
-			"tls://51.15.204.214:54321", // france
-			"tls://n.ygg.yt:443",        // germany
-			"tls://ygg7.mk16.de:1338?key=000000086278b5f3ba1eb63acb5b7f6e406f04ce83990dee9c07f49011e375ae", // austria
-			"tls://syd.joel.net.au:8443", // australia
-			"tls://95.217.35.92:1337",    // finland
-			"tls://37.205.14.171:993",    // czechia
-		},
-		IfName:      "auto",
+		PrivateKey:  privKeyHex,
+		Peers:       s.peers,
+		Listen:      s.listen,
+		IfName:      s.platform.IfName(),
 		IfMTU:       65535,
-		AdminListen: yggSubprocessAdminAddr,
+		AdminListen: "tcp://" + s.adminAddr,
+
+		MulticastInterfaces: append([]MulticastInterface{}, s.mcastIfs...),
+		AllowedPublicKeys:   s.allowedKeys(),
+		NodeInfo:            s.nodeInfo,
 	}
 
 	data, err := json.MarshalIndent(cfg, "", "  ")
@@ -80,16 +230,15 @@ func (s *YggService) WriteConfig(privKeyHex string) error {
 	return nil
 }
 
-// IsInstalled checks if the Yggdrasil Windows Service is installed
+// IsInstalled checks if an OS-managed Yggdrasil provides the TUN
+// interface — the Windows service, or a systemd unit on Linux
 func (s *YggService) IsInstalled() bool {
-	cmd := exec.Command("sc", "query", "Yggdrasil")
-	err := cmd.Run()
-	return err == nil
+	return s.platform.ServiceRunning()
 }
 
 // isSubprocessRunning checks if our subprocess admin socket is reachable
 func (s *YggService) isSubprocessRunning() bool {
-	conn, err := net.DialTimeout("tcp", yggSubprocessAdminAddr, 300*time.Millisecond)
+	conn, err := net.DialTimeout("tcp", s.adminAddr, 300*time.Millisecond)
 	if err != nil {
 		return false
 	}
@@ -97,15 +246,28 @@ func (s *YggService) isSubprocessRunning() bool {
 	return true
 }
 
-// Start launches yggdrasil.exe with our config
-// requires Administrator privileges on Windows for TUN creation
+// Start launches yggdrasil with our config
+// requires Administrator/root (or CAP_NET_ADMIN) for TUN creation
 func (s *YggService) Start() error {
 	if s.IsInstalled() {
 		fmt.Println("Using installed Yggdrasil service.")
 		s.addPeersViaAdmin()
+		s.setState(YggExternal, "")
 		return nil
 	}
 
+	s.setState(YggStarting, "")
+	if err := s.launch(func() { fmt.Print(".") }); err != nil {
+		s.setState(YggFailed, err.Error())
+		return err
+	}
+	s.setState(YggRunning, "")
+	return nil
+}
+
+// launch starts one yggdrasil process and waits for its admin socket
+// tick is called every half second while waiting, if not nil
+func (s *YggService) launch(tick func()) error {
 	absPath, err := filepath.Abs(s.binPath)
 	if err != nil {
 		return fmt.Errorf("invalid binary path: %w", err)
@@ -120,127 +282,172 @@ func (s *YggService) Start() error {
 	}
 
 	// clean up leftover adapter from previous run
-	// prevents "file already exists" on WinTun driver
-	exec.Command("netsh", "interface", "delete", "interface", "Yggdrasil").Run()
-	time.Sleep(500 * time.Millisecond)
+	s.platform.CleanupInterface()
 
-	s.cmd = exec.Command(absPath, "-useconffile", absCfg, "-logto", "stdout")
+	cmd := exec.Command(absPath, "-useconffile", absCfg, "-logto", "stdout")
 
 	// redirect subprocess output to log file — keeps CLI output clean
-	logFile, err := os.OpenFile(yggLogFile,
-		os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
-	if err != nil {
-		// can't open log file — fall back to stdout
-		s.cmd.Stdout = os.Stdout
-		s.cmd.Stderr = os.Stderr
+	if s.log == nil {
+		if log, err := OpenRotatingLog(s.logPath, s.logMaxSize, s.logKeep); err == nil {
+			s.log = log
+		}
+	}
+	if s.log != nil {
+		cmd.Stdout = s.log
+		cmd.Stderr = s.log
 	} else {
-		s.logFile = logFile
-		s.cmd.Stdout = logFile
-		s.cmd.Stderr = logFile
+		// can't open log file — fall back to stdout
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
 	}
 
-	if err := s.cmd.Start(); err != nil {
-		if s.logFile != nil {
-			s.logFile.Close()
-		}
+	if err := cmd.Start(); err != nil {
 		return fmt.Errorf("failed to start yggdrasil: %w", err)
 	}
 
-	// wait for subprocess admin socket on 9091
+	proc := &yggProc{cmd: cmd, exited: make(chan struct{})}
+	go func() {
+		proc.err = cmd.Wait()
+		close(proc.exited)
+	}()
+	s.mu.Lock()
+	s.proc = proc
+	s.mu.Unlock()
+
+	// wait for subprocess admin socket
 	// it comes up before TUN is fully initialized
 	for i := 0; i < 60; i++ {
 		time.Sleep(500 * time.Millisecond)
-		fmt.Print(".")
+		if tick != nil {
+			tick()
+		}
+		select {
+		case <-proc.exited:
+			// died early — usually missing privileges
+			return fmt.Errorf("yggdrasil exited during startup — check %s for details", s.logPath)
+		case <-s.stopping:
+			return fmt.Errorf("stopped during startup")
+		default:
+		}
 		if s.isSubprocessRunning() {
-			// admin socket up — TUN initialization takes a few more seconds
-			time.Sleep(5 * time.Second)
+			// admin socket up — TUN initialization takes a bit longer
+			s.platform.WaitInterface(10 * time.Second)
 			return nil
 		}
 	}
 
-	s.cmd.Process.Kill()
-	if s.logFile != nil {
-		s.logFile.Close()
-	}
-	return fmt.Errorf("yggdrasil failed to start — check %s for details", yggLogFile)
+	cmd.Process.Kill()
+	<-proc.exited
+	return fmt.Errorf("yggdrasil failed to start — check %s for details", s.logPath)
 }
 
 // addPeersViaAdmin adds bootstrap peers to the running installed service
 func (s *YggService) addPeersViaAdmin() {
-	peers := []string{
-		"tls://62.210.85.80:39575",
-		"tls://51.15.204.214:54321",
-		"tls://n.ygg.yt:443",
-		"tls://ygg7.mk16.de:1338?key=000000086278b5f3ba1eb63acb5b7f6e406f04ce83990dee9c07f49011e375ae",
-		"tls://syd.joel.net.au:8443",
-		"tls://95.217.35.92:1337",
-		"tls://37.205.14.171:993",
-	}
-
-	conn, err := net.DialTimeout("tcp", yggAdminAddr, 2*time.Second)
+	client, err := yggadmin.Dial(s.ServiceAdminEndpoint(), 2*time.Second)
 	if err != nil {
+		fmt.Println("Warning: cannot add peers to installed service:", err)
 		return
 	}
-	defer conn.Close()
+	defer client.Close()
 
-	for _, peer := range peers {
-		req := fmt.Sprintf(`{"request":"addPeer","uri":"%s"}`, peer)
-		conn.Write([]byte(req + "\n"))
-		time.Sleep(100 * time.Millisecond)
+	for _, peer := range s.peers {
+		if err := client.AddPeer(peer, ""); err != nil {
+			fmt.Printf("Warning: failed to add peer %s: %v\n", peer, err)
+		}
 	}
 }
 
-// GetAddress queries the subprocess admin socket for our mesh address
-func (s *YggService) GetAddress() (string, error) {
-	conn, err := net.DialTimeout("tcp", yggSubprocessAdminAddr, 3*time.Second)
-	if err != nil {
-		// try installed service socket as fallback
-		conn, err = net.DialTimeout("tcp", yggAdminAddr, 3*time.Second)
-		if err != nil {
-			return "", fmt.Errorf("cannot reach yggdrasil admin: %w", err)
-		}
+// AdminEndpoint returns the admin socket of whichever Yggdrasil owns the
+// TUN — the installed service if we deferred to it, else our subprocess
+func (s *YggService) AdminEndpoint() string {
+	s.mu.Lock()
+	proc := s.proc
+	s.mu.Unlock()
+	if proc == nil {
+		return s.ServiceAdminEndpoint()
 	}
-	defer conn.Close()
+	return "tcp://" + s.adminAddr
+}
 
-	req := map[string]interface{}{"request": "getSelf"}
-	data, _ := json.Marshal(req)
-	conn.Write(data)
-	conn.Write([]byte("\n"))
+// ServiceAdminEndpoint returns the admin socket of an OS-managed instance
+func (s *YggService) ServiceAdminEndpoint() string {
+	return s.serviceNet + "://" + s.serviceAdmin
+}
 
-	buf := make([]byte, 4096)
-	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
-	n, err := conn.Read(buf)
+// GetAddress queries the admin socket for our mesh address
+func (s *YggService) GetAddress() (string, error) {
+	client, err := yggadmin.Dial(s.AdminEndpoint(), 3*time.Second)
 	if err != nil {
-		return "", fmt.Errorf("failed to read admin response: %w", err)
+		return "", err
 	}
+	defer client.Close()
 
-	var resp map[string]interface{}
-	if err := json.Unmarshal(buf[:n], &resp); err != nil {
-		return "", fmt.Errorf("failed to parse admin response: %w", err)
+	self, err := client.GetSelf()
+	if err != nil {
+		return "", err
 	}
+	return self.Address, nil
+}
 
-	if status, ok := resp["response"].(map[string]interface{}); ok {
-		if addr, ok := status["address"].(string); ok {
-			return addr, nil
-		}
+// GetPublicKey queries the admin socket for the key the TUN runs on
+func (s *YggService) GetPublicKey() (string, error) {
+	client, err := yggadmin.Dial(s.AdminEndpoint(), 3*time.Second)
+	if err != nil {
+		return "", err
 	}
+	defer client.Close()
 
-	return "", fmt.Errorf("address not found in admin response")
+	self, err := client.GetSelf()
+	if err != nil {
+		return "", err
+	}
+	return self.PublicKey, nil
 }
 
-// Stop shuts down the Yggdrasil subprocess
+// Stop ends supervision and shuts down the Yggdrasil subprocess
 func (s *YggService) Stop() {
-	if s.cmd == nil || s.cmd.Process == nil {
+	s.stopOnce.Do(func() { close(s.stopping) })
+	s.supervisor.Wait()
+
+	s.mu.Lock()
+	proc := s.proc
+	s.mu.Unlock()
+	if proc == nil {
 		return
 	}
 
-	s.cmd.Process.Kill()
-	s.cmd.Wait()
-
-	if s.logFile != nil {
-		s.logFile.Close()
+	proc.stop(s.platform)
+	if s.log != nil {
+		s.log.Close()
 	}
+	s.setState(YggStopped, "")
 
 	// clean up TUN adapter so next run starts fresh
-	exec.Command("netsh", "interface", "delete", "interface", "Yggdrasil").Run()
+	s.platform.CleanupInterface()
+}
+
+// yggProc is one run of the subprocess
+type yggProc struct {
+	cmd    *exec.Cmd
+	exited chan struct{}
+	err    error // from Wait, valid once exited is closed
+}
+
+// stop interrupts the process and kills it if it won't exit in time
+func (p *yggProc) stop(platform Platform) {
+	select {
+	case <-p.exited:
+		return
+	default:
+	}
+	// give it the chance to tear down its own interface
+	if err := platform.Interrupt(p.cmd.Process); err != nil {
+		p.cmd.Process.Kill()
+	}
+	select {
+	case <-p.exited:
+	case <-time.After(yggStopTimeout):
+		p.cmd.Process.Kill()
+		<-p.exited
+	}
 }
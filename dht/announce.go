@@ -2,30 +2,73 @@ package dht
 
 import (
 	"fmt"
+	"math/rand/v2"
+	"sync"
 	"time"
 )
 
-// reannounceInterval is how often we re-announce our record
+// defaultReannounceInterval is how often we re-announce our record
 // must be less than RecordTTL (1 hour) to prevent expiry
 // 45 minutes gives a 15 minute safety margin
-const reannounceInterval = 45 * time.Minute
+const defaultReannounceInterval = 45 * time.Minute
 
-// Reannouncer manages periodic re-announcement of a record
+// re-announce tuning
+const (
+	// reannounceJitter spreads re-announces by up to this fraction of the
+	// interval either way, so nodes started together don't stay in step
+	reannounceJitter = 0.1
+	// a failed announce is retried after reannounceRetry, doubling up to
+	// reannounceMaxRetry
+	reannounceRetry    = 30 * time.Second
+	reannounceMaxRetry = 5 * time.Minute
+	// addressPoll is how often the address source is checked
+	addressPoll = 10 * time.Second
+)
+
+// Reannouncer keeps a record alive on the mesh. Each cycle it signs a
+// fresh one from its options — a new expiry and a higher sequence
+// number — and announces that, since the first record expires whatever
+// happens to it.
 type Reannouncer struct {
-	dht    *DHT
-	record Record
-	done   chan struct{}
+	dht      *DHT
+	interval time.Duration
+	// address reports the node's current address, nil to keep opts.Address
+	address func() string
+
+	mu     sync.Mutex
+	opts   RegisterOptions // PrivateKey signs each record
+	record Record          // the last one announced
+	failed bool            // whether the last announce failed
+
+	// wake asks the loop to announce now
+	wake chan struct{}
+	done chan struct{}
 }
 
-// NewReannouncer creates a reannouncer for a record
-func NewReannouncer(d *DHT, record Record) *Reannouncer {
+// NewReannouncer creates a reannouncer for the record opts describes
+func NewReannouncer(d *DHT, opts RegisterOptions) *Reannouncer {
 	return &Reannouncer{
-		dht:    d,
-		record: record,
-		done:   make(chan struct{}),
+		dht:      d,
+		opts:     opts,
+		interval: defaultReannounceInterval,
+		wake:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
 	}
 }
 
+// SetInterval changes how often the record is re-announced
+// must be shorter than the record's TTL and called before Start
+func (r *Reannouncer) SetInterval(interval time.Duration) {
+	r.interval = interval
+}
+
+// SetAddressSource makes the record follow the node's address, polled
+// from addr and re-announced as soon as it changes. Must be called
+// before Start.
+func (r *Reannouncer) SetAddressSource(addr func() string) {
+	r.address = addr
+}
+
 // Start launches the re-announcement loop in the background
 func (r *Reannouncer) Start() {
 	go r.loop()
@@ -36,26 +79,135 @@ func (r *Reannouncer) Stop() {
 	close(r.done)
 }
 
-// UpdateRecord replaces the record being announced
+// Record returns the last record announced
+func (r *Reannouncer) Record() Record {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.record
+}
+
+// UpdateRecord replaces what is announced and announces it straight away
 // call this if your services change
-func (r *Reannouncer) UpdateRecord(record Record) {
-	r.record = record
+func (r *Reannouncer) UpdateRecord(opts RegisterOptions) {
+	r.mu.Lock()
+	r.opts = opts
+	r.mu.Unlock()
+	r.trigger()
+}
+
+// Announce signs a fresh record and announces it now
+func (r *Reannouncer) Announce() error {
+	r.mu.Lock()
+	opts := r.opts
+	r.mu.Unlock()
+
+	err := r.announce(opts)
+	r.mu.Lock()
+	r.failed = err != nil
+	r.mu.Unlock()
+	return err
+}
+
+func (r *Reannouncer) announce(opts RegisterOptions) error {
+	record, err := CreateRecord(opts)
+	if err != nil {
+		return fmt.Errorf("failed to create record: %w", err)
+	}
+	stored, err := r.dht.announce(record)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	// a newer one may have gone out meanwhile
+	if record.Sequence >= r.record.Sequence {
+		r.record = record
+	}
+	r.mu.Unlock()
+
+	// alone on the mesh there is nobody to take it, and that's no failure
+	if stored == 0 && r.dht.TableSize() > 0 {
+		return fmt.Errorf("no node took the record for %q", record.Name)
+	}
+	return nil
+}
+
+func (r *Reannouncer) trigger() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// followAddress picks up a changed address, reporting whether it did
+func (r *Reannouncer) followAddress() bool {
+	if r.address == nil {
+		return false
+	}
+	addr := r.address()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if addr == "" || addr == r.opts.Address {
+		return false
+	}
+	r.opts.Address = addr
+	return true
+}
+
+// jittered is the interval moved by up to reannounceJitter either way
+func (r *Reannouncer) jittered() time.Duration {
+	spread := time.Duration(float64(r.interval) * reannounceJitter)
+	if spread <= 0 {
+		return r.interval
+	}
+	return r.interval - spread + rand.N(2*spread)
 }
 
 func (r *Reannouncer) loop() {
-	ticker := time.NewTicker(reannounceInterval)
-	defer ticker.Stop()
+	// an announce that failed before Start is retried soon
+	r.mu.Lock()
+	failed := r.failed
+	r.mu.Unlock()
+	retry, next := time.Duration(0), r.jittered()
+	if failed {
+		retry, next = reannounceRetry, reannounceRetry
+	}
+
+	timer := time.NewTimer(next)
+	defer timer.Stop()
+	poll := time.NewTicker(addressPoll)
+	defer poll.Stop()
 
 	for {
 		select {
-		case <-ticker.C:
-			fmt.Printf("Re-announcing %q on the mesh...\n", r.record.Name)
-			err := r.dht.Announce(r.record)
-			if err != nil {
-				fmt.Println("Re-announce failed:", err)
+		case <-timer.C:
+		case <-r.wake:
+		case <-poll.C:
+			if !r.followAddress() {
+				continue
 			}
+			fmt.Println("Address changed, re-announcing")
 		case <-r.done:
 			return
 		}
+
+		r.mu.Lock()
+		name := r.opts.Name
+		r.mu.Unlock()
+		fmt.Printf("Re-announcing %q on the mesh...\n", name)
+		next = r.jittered()
+		if err := r.Announce(); err != nil {
+			if retry == 0 {
+				retry = reannounceRetry
+			} else {
+				retry = min(retry*2, reannounceMaxRetry)
+			}
+			next = min(retry, next)
+			fmt.Printf("Re-announce failed: %v (retrying in %s)\n", err, next.Round(time.Second))
+		} else {
+			retry = 0
+		}
+
+		timer.Reset(next)
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,64 @@
+package dht
+
+import (
+	"crypto/ed25519"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Each re-announce is a new record, signed afresh: the first one's
+// expiry doesn't move however often it is re-sent.
+func TestReannounceSignsFreshRecord(t *testing.T) {
+	nodes := startTestNodes(t, 2, nil)
+	a, b := nodes[0], nodes[1]
+	defer a.Stop()
+	defer b.Stop()
+
+	counter, err := LoadSequenceCounter(filepath.Join(t.TempDir(), "sequence.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, priv, _ := ed25519.GenerateKey(nil)
+	r := NewReannouncer(a, RegisterOptions{
+		Name:       "alice",
+		Address:    "200::1",
+		PrivateKey: priv,
+		TTL:        10 * time.Minute,
+		Counter:    counter,
+	})
+	if err := r.Announce(); err != nil {
+		t.Fatal(err)
+	}
+	first := r.Record()
+
+	// Expires is in seconds, so the next cycle must be a second later
+	r.SetInterval(1500 * time.Millisecond)
+	r.Start()
+	defer r.Stop()
+	deadline := time.Now().Add(3 * time.Second)
+	for r.Record().Sequence == first.Sequence {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the re-announce")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	second := r.Record()
+	if second.Sequence <= first.Sequence {
+		t.Errorf("sequence %d after %d", second.Sequence, first.Sequence)
+	}
+	if second.Expires <= first.Expires {
+		t.Errorf("expires %d after %d", second.Expires, first.Expires)
+	}
+	if second.Signature == first.Signature {
+		t.Error("re-announced record carries the first signature")
+	}
+	if err := second.Verify(); err != nil {
+		t.Errorf("re-announced record does not verify: %v", err)
+	}
+	waitFor(t, "peer to hold the re-announced record", func() bool {
+		held, ok := b.store.GetPublic("alice")
+		return ok && held.Sequence == second.Sequence
+	})
+}
@@ -7,23 +7,38 @@ import (
 	"time"
 )
 
-// APIPort is the local HTTP API port — only listens on localhost
-const APIPort = 9099
-
 // StartAPI launches a local HTTP API for CLI commands to communicate with
-// the running node. Never exposed to the mesh network.
-func (d *DHT) StartAPI(nodeName string, nodeAddress string, nodePublicKey string) {
+// the running node. Only listens on localhost — never exposed to the mesh network.
+func (d *DHT) StartAPI(addr string, nodeName string, nodeAddress string, nodePublicKey string) {
 	mux := http.NewServeMux()
 
 	// GET /status
 	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"name":       nodeName,
-			"address":    nodeAddress,
-			"public_key": nodePublicKey,
-			"peers":      d.table.Size(),
-			"records":    d.store.Size(),
-		})
+		status := map[string]interface{}{
+			"name":        nodeName,
+			"address":     nodeAddress,
+			"public_key":  nodePublicKey,
+			"peers":       d.table.Size(),
+			"records":     d.store.Size(),
+			"admin":       d.AdminEndpoint(),
+			"listen":      d.Listeners(),
+			"network":     d.network,
+			"handshakes":  d.HandshakeStats(),
+			"connections": d.pool.Size(),
+			"udp":         d.UDPStats(),
+			"cache":       d.CacheStats(),
+			"protocol": map[string]interface{}{
+				"version":      ProtocolVersion,
+				"binary_peers": d.pool.BinaryPeers(),
+			},
+		}
+		d.mu.RLock()
+		tunStatus := d.tunStatus
+		d.mu.RUnlock()
+		if tunStatus != nil {
+			status["tun"] = tunStatus()
+		}
+		json.NewEncoder(w).Encode(status)
 	})
 
 	// GET /lookup?name=alice&group=
@@ -46,6 +61,38 @@ func (d *DHT) StartAPI(nodeName string, nodeAddress string, nodePublicKey string
 		json.NewEncoder(w).Encode(record)
 	})
 
+	// GET /records?owner=<public key hex>
+	mux.HandleFunc("/records", func(w http.ResponseWriter, r *http.Request) {
+		owner := r.URL.Query().Get("owner")
+		records := []Record{}
+		for _, rec := range d.store.All() {
+			if owner == "" || rec.PublicKey == owner {
+				records = append(records, rec)
+			}
+		}
+		json.NewEncoder(w).Encode(records)
+	})
+
+	// POST /announce  body: []Record
+	mux.HandleFunc("/announce", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "POST required", http.StatusMethodNotAllowed)
+			return
+		}
+		var records []Record
+		if err := json.NewDecoder(r.Body).Decode(&records); err != nil {
+			http.Error(w, "invalid body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, rec := range records {
+			if err := d.Announce(rec); err != nil {
+				http.Error(w, fmt.Sprintf("%s: %v", rec.Name, err), http.StatusBadRequest)
+				return
+			}
+		}
+		w.Write([]byte("ok"))
+	})
+
 	// GET /peers
 	mux.HandleFunc("/peers", func(w http.ResponseWriter, r *http.Request) {
 		json.NewEncoder(w).Encode(d.PingAllPeers())
@@ -71,7 +118,7 @@ func (d *DHT) StartAPI(nodeName string, nodeAddress string, nodePublicKey string
 	})
 
 	server := &http.Server{
-		Addr:    fmt.Sprintf("127.0.0.1:%d", APIPort),
+		Addr:    addr,
 		Handler: mux,
 	}
 
@@ -82,10 +129,47 @@ func (d *DHT) StartAPI(nodeName string, nodeAddress string, nodePublicKey string
 	}()
 }
 
-// IsNodeRunning checks if a node is already running
-func IsNodeRunning() bool {
+// SetAdminEndpoint records the Yggdrasil admin socket serving this node
+// reported in /status so the CLI can query the mesh layer too
+func (d *DHT) SetAdminEndpoint(endpoint string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.adminEndpoint = endpoint
+}
+
+// SetTUNStatus registers fn to report the supervised TUN subprocess
+// in /status — anything JSON-encodable
+func (d *DHT) SetTUNStatus(fn func() interface{}) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.tunStatus = fn
+}
+
+// SetListeners records the Yggdrasil URIs accepting peerings for /status
+func (d *DHT) SetListeners(addrs []string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.listeners = addrs
+}
+
+// Listeners returns the Yggdrasil URIs accepting peerings
+func (d *DHT) Listeners() []string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.listeners
+}
+
+// AdminEndpoint returns the Yggdrasil admin socket serving this node
+func (d *DHT) AdminEndpoint() string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.adminEndpoint
+}
+
+// IsNodeRunning checks if a node is already serving its API on addr
+func IsNodeRunning(addr string) bool {
 	client := &http.Client{Timeout: 500 * time.Millisecond}
-	resp, err := client.Get(fmt.Sprintf("http://127.0.0.1:%d/status", APIPort))
+	resp, err := client.Get(fmt.Sprintf("http://%s/status", addr))
 	if err != nil {
 		return false
 	}
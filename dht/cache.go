@@ -0,0 +1,175 @@
+package dht
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Lookups that had to go to the network are remembered, so a name asked
+// for on every page load costs one iterative query rather than one per
+// load. A found record is kept until it expires, but no longer than
+// cacheMaxAge, so a moved address still reaches askers soon. A name
+// nobody holds is remembered for cacheMissTTL. The oldest entries go
+// first once the cache is full.
+//
+// A record stored here, by its owner or a republishing node, drops the
+// cached answers for its name. A lookup only replaces a cached record
+// with one at least as new from the same key, or from a key the cached
+// one's handed the name to.
+
+// cache tuning
+const (
+	cacheSize = 1024
+	// cacheMaxAge bounds how long a found record is served from the cache
+	cacheMaxAge = 5 * time.Minute
+	// cacheMissTTL is how long a not-found answer is remembered
+	cacheMissTTL = 30 * time.Second
+)
+
+// CacheStats counts lookups answered from the cache, for /status
+type CacheStats struct {
+	Size      int    `json:"size"`
+	Hits      uint64 `json:"hits"`
+	MissHits  uint64 `json:"negative_hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+}
+
+type cacheKey struct {
+	name, group string
+}
+
+type cacheEntry struct {
+	key cacheKey
+	// record is nil for a name nobody holds
+	record  *Record
+	expires time.Time
+}
+
+// lookupCache is an LRU of lookup answers
+type lookupCache struct {
+	mu      sync.Mutex
+	entries map[cacheKey]*list.Element
+	order   *list.List // most recently used first
+	stats   CacheStats
+	// succeeds reports whether a verified succession hands oldKey's
+	// names to newKey
+	succeeds func(oldKey, newKey string) bool
+}
+
+func newLookupCache(succeeds func(oldKey, newKey string) bool) *lookupCache {
+	return &lookupCache{
+		entries:  make(map[cacheKey]*list.Element),
+		order:    list.New(),
+		succeeds: succeeds,
+	}
+}
+
+// get returns the cached answer for name in group — ok is false when
+// there is none, and record nil when the answer was not found
+func (c *lookupCache) get(name, group string) (record *Record, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, found := c.entries[cacheKey{name, group}]
+	if !found {
+		c.stats.Misses++
+		return nil, false
+	}
+	e := el.Value.(*cacheEntry)
+	if time.Now().After(e.expires) {
+		c.removeLocked(el)
+		c.stats.Misses++
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	if e.record == nil {
+		c.stats.MissHits++
+		return nil, true
+	}
+	c.stats.Hits++
+	r := *e.record
+	return &r, true
+}
+
+// put caches r as the answer for name in group, unless r is for another
+// name or group, or the cached answer is newer or belongs to another
+// owner
+func (c *lookupCache) put(name, group string, r Record) {
+	if r.Name != name || r.GroupKey != group {
+		return
+	}
+	expires := time.Unix(r.Expires, 0)
+	if max := time.Now().Add(cacheMaxAge); expires.After(max) {
+		expires = max
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := cacheKey{name, group}
+	if el, found := c.entries[key]; found {
+		old := el.Value.(*cacheEntry).record
+		switch {
+		case old == nil:
+		case old.PublicKey == r.PublicKey:
+			if r.Sequence < old.Sequence {
+				return
+			}
+		case !c.succeeds(old.PublicKey, r.PublicKey):
+			return
+		}
+	}
+	c.setLocked(key, &r, expires)
+}
+
+// putMissing remembers that nobody holds name in group
+func (c *lookupCache) putMissing(name, group string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.setLocked(cacheKey{name, group}, nil, time.Now().Add(cacheMissTTL))
+}
+
+// invalidate drops every cached answer for name
+func (c *lookupCache) invalidate(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, el := range c.entries {
+		if key.name == name {
+			c.removeLocked(el)
+		}
+	}
+}
+
+// Stats returns the cache counters
+func (c *lookupCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Size = c.order.Len()
+	return stats
+}
+
+func (c *lookupCache) setLocked(key cacheKey, r *Record, expires time.Time) {
+	if el, found := c.entries[key]; found {
+		e := el.Value.(*cacheEntry)
+		e.record, e.expires = r, expires
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, record: r, expires: expires})
+	for c.order.Len() > cacheSize {
+		c.removeLocked(c.order.Back())
+		c.stats.Evictions++
+	}
+}
+
+func (c *lookupCache) removeLocked(el *list.Element) {
+	delete(c.entries, el.Value.(*cacheEntry).key)
+	c.order.Remove(el)
+}
+
+// CacheStats returns the lookup cache counters
+func (d *DHT) CacheStats() CacheStats {
+	return d.cache.Stats()
+}
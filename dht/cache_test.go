@@ -0,0 +1,87 @@
+package dht
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestCacheIgnoresOtherNames(t *testing.T) {
+	c := newLookupCache(func(string, string) bool { return false })
+	evil, _ := testRecord(t, "evil")
+	c.put("alice", "", evil)
+	c.put("evil", "group", evil)
+
+	if _, ok := c.get("alice", ""); ok {
+		t.Error("record for \"evil\" cached as the answer for \"alice\"")
+	}
+	if _, ok := c.get("evil", "group"); ok {
+		t.Error("public record cached as the answer for a group")
+	}
+	c.put("evil", "", evil)
+	if r, ok := c.get("evil", ""); !ok || r.Name != "evil" {
+		t.Error("matching record not cached")
+	}
+}
+
+func TestCacheKeepsOwner(t *testing.T) {
+	s := NewStore()
+	c := newLookupCache(s.Succeeds)
+
+	owner, ownerKey := testRecord(t, "alice")
+	c.put("alice", "", owner)
+
+	other, _ := testRecord(t, "alice")
+	c.put("alice", "", other)
+	if r, _ := c.get("alice", ""); r.PublicKey != owner.PublicKey {
+		t.Fatal("record from another key replaced the cached owner's")
+	}
+
+	// after a handover the successor's record replaces it
+	newPub, newKey, _ := ed25519.GenerateKey(nil)
+	succ, err := CreateSuccession(ownerKey, newPub, time.Now().Add(-time.Second), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddSuccession(succ); err != nil {
+		t.Fatal(err)
+	}
+	successor, err := CreateRecord(RegisterOptions{Name: "alice", Address: "200::1", PrivateKey: newKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.put("alice", "", successor)
+	if r, _ := c.get("alice", ""); r.PublicKey != hex.EncodeToString(newPub) {
+		t.Error("successor's record did not replace the old owner's")
+	}
+}
+
+func TestCacheExpiryAndEviction(t *testing.T) {
+	c := newLookupCache(func(string, string) bool { return false })
+
+	c.putMissing("nobody", "")
+	if r, ok := c.get("nobody", ""); !ok || r != nil {
+		t.Error("not-found answer not cached")
+	}
+	c.entries[cacheKey{"nobody", ""}].Value.(*cacheEntry).expires = time.Now().Add(-time.Second)
+	if _, ok := c.get("nobody", ""); ok {
+		t.Error("expired answer served")
+	}
+
+	for i := 0; i < cacheSize; i++ {
+		c.putMissing(fmt.Sprint(i), "")
+	}
+	c.get("0", "") // now the most recently used
+	c.putMissing("one more", "")
+	if _, ok := c.get("0", ""); !ok {
+		t.Error("recently used answer evicted")
+	}
+	if _, ok := c.get("1", ""); ok {
+		t.Error("least recently used answer kept")
+	}
+	if stats := c.Stats(); stats.Size != cacheSize || stats.Evictions != 1 {
+		t.Errorf("stats %+v", stats)
+	}
+}
@@ -1,49 +1,106 @@
 package dht
 
 import (
-	"encoding/json"
+	"crypto/ed25519"
 	"fmt"
 	"net"
 	"sync"
+	"time"
 )
 
 const DHTPort = 9001
 
 type DHT struct {
-	address  string
-	port     int
-	table    *RoutingTable
-	store    *Store
-	listener net.Listener
-	wg       sync.WaitGroup
-	done     chan struct{}
-	mu       sync.RWMutex
+	address   string
+	port      int
+	network   string
+	privKey   ed25519.PrivateKey
+	peersFile string
+	// adminEndpoint is the Yggdrasil admin socket, reported in /status
+	adminEndpoint string
+	// listeners are the Yggdrasil URIs accepting peerings, for /status
+	listeners []string
+	// tunStatus reports the TUN subprocess supervisor, nil without TUN
+	tunStatus func() interface{}
+	table     *RoutingTable
+	store     *Store
+	// cache remembers lookups answered by the network, see cache.go
+	cache     *lookupCache
+	transport Transport
+	pool      *connPool
+	// udpEnabled asks Start to open the datagram socket, see udp.go
+	udpEnabled bool
+	// handshakes counts ping handshake outcomes for /status
+	handshakes handshakeCounters
+	// checkAddress reports whether a handshake's connection comes from
+	// the peer's own address, ownsAddress unless a test swaps it
+	checkAddress func(id NodeID, ip net.IP) bool
+	listener     net.Listener
+	wg           sync.WaitGroup
+	done         chan struct{}
+	mu           sync.RWMutex
 }
 
 func New(address string, selfID NodeID, port int) *DHT {
 	if port == 0 {
 		port = DHTPort
 	}
-	return &DHT{
-		address: address,
-		port:    port,
-		table:   NewRoutingTable(selfID),
-		store:   NewStore(),
-		done:    make(chan struct{}),
+	store := NewStore()
+	d := &DHT{
+		address:   address,
+		port:      port,
+		table:     NewRoutingTable(selfID),
+		transport: TCPTransport{},
+		pool:      newConnPool(TCPTransport{}),
+		store:     store,
+		cache:     newLookupCache(store.Succeeds),
+		done:      make(chan struct{}),
 	}
+	d.checkAddress = ownsAddress
+	d.table.SetPinger(d.pingContact)
+	d.table.SetOnNew(d.handoff)
+	return d
 }
 
-func (d *DHT) Start() error {
-	listenAddr := fmt.Sprintf("[::]:%d", d.port)
+// SetNetwork sets the network ID this node belongs to, "" being the
+// public one. Nodes and records from other networks are refused.
+// Must be called before Start.
+func (d *DHT) SetNetwork(id string) {
+	d.network = id
+	d.table.network = id
+	d.store.network = id
+}
+
+// SetPrivateKey sets the key ping handshakes are signed with, the one
+// behind selfID. Without it every peer rejects us. Must be called
+// before Start.
+func (d *DHT) SetPrivateKey(priv ed25519.PrivateKey) {
+	d.privKey = priv
+}
+
+// Network returns the network ID, "" for the public network
+func (d *DHT) Network() string {
+	return d.network
+}
 
-	listener, err := net.Listen("tcp", listenAddr)
+func (d *DHT) Start() error {
+	listener, err := d.transport.Listen(d.port)
 	if err != nil {
-		return fmt.Errorf("failed to listen on %s: %w", listenAddr, err)
+		return err
 	}
 	d.listener = listener
 
+	if d.udpEnabled {
+		if err := d.startUDP(); err != nil {
+			fmt.Printf("UDP unavailable, lookups stay on TCP: %v\n", err)
+		}
+	}
+
 	d.wg.Add(1)
 	go d.acceptLoop()
+	go d.pool.loop(d.done)
+	go d.maintain()
+	go d.republishLoop()
 
 	d.store.Start()
 	return nil
@@ -68,15 +125,7 @@ func (d *DHT) acceptLoop() {
 	}
 }
 
-func (d *DHT) handleConnection(conn net.Conn) {
-	defer d.wg.Done()
-	defer conn.Close()
-
-	msg, err := readMessage(conn)
-	if err != nil {
-		return
-	}
-
+func (d *DHT) handleRequest(conn rpcConn, msg Message) {
 	switch msg.Type {
 	case MsgPing:
 		d.handlePing(conn, msg)
@@ -84,41 +133,85 @@ func (d *DHT) handleConnection(conn net.Conn) {
 		d.handleFindNode(conn, msg)
 	case MsgStore:
 		d.handleStore(conn, msg)
+	case MsgCacheStore:
+		d.handleCacheStore(conn, msg)
 	case MsgFindValue:
 		d.handleFindValue(conn, msg)
 	}
 }
 
-func (d *DHT) handlePing(conn net.Conn, msg Message) {
+func (d *DHT) handlePing(conn rpcConn, msg Message) {
 	var ping PingBody
-	if err := json.Unmarshal(msg.Body, &ping); err != nil {
+	if err := msg.Decode(&ping); err != nil {
 		return
 	}
 
-	senderID, err := NodeIDFromHex(ping.SenderID)
-	if err == nil {
-		d.table.Add(Contact{
-			ID:      senderID,
-			Address: net.ParseIP(ping.SenderAddr),
-			Port:    ping.SenderPort,
-		})
-	}
-
-	pongBody, _ := json.Marshal(PongBody{
+	// answered even across networks — the pong tells the sender why
+	// we won't be in its table
+	pong := PongBody{
 		SenderID:   d.table.self.String(),
 		SenderAddr: d.address,
 		SenderPort: d.port,
-	})
+		Network:    d.network,
+		Version:    ProtocolVersion,
+		Features:   d.features(),
+	}
+	if ping.Network == d.network && ping.Nonce != "" {
+		nonce, err := newNonce()
+		if err != nil {
+			return
+		}
+		pong.Signature = d.signHandshake(pongContext, ping.Nonce)
+		pong.Nonce = nonce
+	}
 
-	writeMessage(conn, Message{
-		Type: MsgPong,
-		Body: pongBody,
-	})
+	if err := conn.Send(MsgPong, pong); err != nil {
+		return
+	}
+	if ping.Network != d.network {
+		return
+	}
+	if pong.Nonce == "" {
+		d.handshakes.count(errUnsigned)
+		return
+	}
+
+	// the sender goes into the table only once it has signed our nonce
+	// from the address its key owns
+	reply, err := conn.Receive()
+	if err != nil || reply.Type != MsgPingAck {
+		return
+	}
+	var ack PingAckBody
+	if err := reply.Decode(&ack); err != nil {
+		return
+	}
+	senderID, err := NodeIDFromHex(ping.SenderID)
+	if err != nil {
+		return
+	}
+	err = d.verifyHandshake(conn, senderID, pingAckContext, pong.Nonce, ack.Signature)
+	d.handshakes.count(err)
+	if err != nil {
+		return
+	}
+
+	sender := Contact{
+		ID:      senderID,
+		Address: remoteIP(conn),
+		Port:    ping.SenderPort,
+		Network: ping.Network,
+	}
+	d.table.Add(sender)
+	d.pool.setFeatures(sender.Addr(), ping.Version, ping.Features)
 }
 
-func (d *DHT) handleFindNode(conn net.Conn, msg Message) {
+func (d *DHT) handleFindNode(conn rpcConn, msg Message) {
 	var req FindNodeBody
-	if err := json.Unmarshal(msg.Body, &req); err != nil {
+	if err := msg.Decode(&req); err != nil {
+		return
+	}
+	if req.Network != d.network {
 		return
 	}
 
@@ -129,30 +222,55 @@ func (d *DHT) handleFindNode(conn net.Conn, msg Message) {
 
 	closest := d.table.Closest(targetID, K)
 
-	var contacts []ContactInfo
-	for _, c := range closest {
-		contacts = append(contacts, ContactInfo{
-			ID:   c.ID.String(),
-			Addr: c.Address.String(),
-			Port: c.Port,
+	conn.Send(MsgFoundNodes, FoundNodesBody{Nodes: contactInfos(closest)})
+}
+
+func contactInfos(contacts []Contact) []ContactInfo {
+	var infos []ContactInfo
+	for _, c := range contacts {
+		infos = append(infos, ContactInfo{
+			ID:      c.ID.String(),
+			Addr:    c.Address.String(),
+			Port:    c.Port,
+			Network: c.Network,
 		})
 	}
-
-	body, _ := json.Marshal(FoundNodesBody{Nodes: contacts})
-	writeMessage(conn, Message{Type: MsgFoundNodes, Body: body})
+	return infos
 }
 
-func (d *DHT) handleStore(_ net.Conn, msg Message) {
+func (d *DHT) handleStore(_ rpcConn, msg Message) {
 	var req StoreBody
-	if err := json.Unmarshal(msg.Body, &req); err != nil {
+	if err := msg.Decode(&req); err != nil {
 		return
 	}
-	d.store.Put(req.Record)
+	if d.store.Put(req.Record) == nil {
+		d.cache.invalidate(req.Record.Name)
+	}
+}
+
+func (d *DHT) handleCacheStore(_ rpcConn, msg Message) {
+	var req CacheStoreBody
+	if err := msg.Decode(&req); err != nil {
+		return
+	}
+	ttl := time.Duration(req.TTL) * time.Second
+	if ttl <= 0 {
+		return
+	}
+	if ttl > cachedCopyTTL {
+		ttl = cachedCopyTTL
+	}
+	if d.store.PutCached(req.Record, ttl) == nil {
+		d.cache.invalidate(req.Record.Name)
+	}
 }
 
-func (d *DHT) handleFindValue(conn net.Conn, msg Message) {
+func (d *DHT) handleFindValue(conn rpcConn, msg Message) {
 	var req FindValueBody
-	if err := json.Unmarshal(msg.Body, &req); err != nil {
+	if err := msg.Decode(&req); err != nil {
+		return
+	}
+	if req.Network != d.network {
 		return
 	}
 
@@ -166,31 +284,17 @@ func (d *DHT) handleFindValue(conn net.Conn, msg Message) {
 	}
 
 	if found {
-		body, _ := json.Marshal(FoundValueBody{Record: record})
-		writeMessage(conn, Message{Type: MsgFoundValue, Body: body})
+		conn.Send(MsgFoundValue, FoundValueBody{Record: record})
 		return
 	}
 
 	targetID := RecordID(req.Name)
-	closest := d.table.Closest(targetID, K)
-
-	var contacts []ContactInfo
-	for _, c := range closest {
-		contacts = append(contacts, ContactInfo{
-			ID:   c.ID.String(),
-			Addr: c.Address.String(),
-			Port: c.Port,
-		})
-	}
+	contacts := contactInfos(d.table.Closest(targetID, K))
 
 	if len(contacts) > 0 {
-		body, _ := json.Marshal(FoundNodesBody{Nodes: contacts})
-		writeMessage(conn, Message{Type: MsgFoundNodes, Body: body})
+		conn.Send(MsgFoundNodes, FoundNodesBody{Nodes: contacts})
 	} else {
-		writeMessage(conn, Message{
-			Type: MsgNotFound,
-			Body: json.RawMessage("{}"),
-		})
+		conn.Send(MsgNotFound, NotFoundBody{})
 	}
 }
 
@@ -199,22 +303,39 @@ func (d *DHT) Stop() {
 	if d.listener != nil {
 		d.listener.Close()
 	}
+	if d.pool.udp != nil {
+		d.pool.udp.Close()
+	}
 	d.store.Stop()
 	d.wg.Wait()
 }
 
-func (d *DHT) PingPeer(addr string) error {
-	self := Contact{
+// selfContact is how we describe ourselves in pings
+func (d *DHT) selfContact() Contact {
+	return Contact{
 		ID:      d.table.self,
 		Address: net.ParseIP(d.address),
 		Port:    d.port,
 	}
+}
 
-	pong, err := SendPing(addr, self)
+// pingContact checks a contact is still there, for the routing table
+func (d *DHT) pingContact(c Contact) error {
+	pong, err := d.SendPing(c.Addr(), d.selfContact())
 	if err != nil {
-		return fmt.Errorf("ping failed: %w", err)
+		return err
 	}
+	if pong.SenderID != c.ID.String() {
+		return fmt.Errorf("%s is now another node", c.Addr())
+	}
+	return nil
+}
 
+func (d *DHT) PingPeer(addr string) error {
+	pong, err := d.SendPing(addr, d.selfContact())
+	if err != nil {
+		return fmt.Errorf("ping failed: %w", err)
+	}
 	id, err := NodeIDFromHex(pong.SenderID)
 	if err != nil {
 		return fmt.Errorf("invalid sender ID: %w", err)
@@ -231,11 +352,20 @@ func (d *DHT) PingPeer(addr string) error {
 		ID:      id,
 		Address: net.ParseIP(host),
 		Port:    dialPort,
+		Network: pong.Network,
 	})
 
 	return nil
 }
 
+// networkName describes a network ID for messages
+func networkName(id string) string {
+	if id == "" {
+		return "the public network"
+	}
+	return fmt.Sprintf("network %q", id)
+}
+
 func (d *DHT) TableSize() int {
 	return d.table.Size()
 }
@@ -0,0 +1,61 @@
+package dht
+
+import (
+	"crypto/ed25519"
+	"net"
+	"testing"
+)
+
+// newTestNode creates a node on a free loopback port, not yet started
+// loopback is no key's address, so handshakes fail unless a test swaps
+// checkAddress (see handshake_test.go) — most put contacts in tables
+// themselves with meet
+func newTestNode(tb testing.TB) *DHT {
+	tb.Helper()
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		tb.Skip("no IPv6 loopback:", err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	d := New("::1", NodeIDFromPublicKey(pub), port)
+	d.SetPrivateKey(priv)
+	d.SetUDP(false)
+	return d
+}
+
+// startTestNodes starts n nodes that all know each other
+func startTestNodes(tb testing.TB, n int, setup func(*DHT)) []*DHT {
+	tb.Helper()
+	nodes := make([]*DHT, n)
+	for i := range nodes {
+		nodes[i] = newTestNode(tb)
+		if setup != nil {
+			setup(nodes[i])
+		}
+		if err := nodes[i].Start(); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	for i, a := range nodes {
+		for _, b := range nodes[i+1:] {
+			meet(a, b)
+		}
+	}
+	return nodes
+}
+
+// meet puts a and b in each other's routing table
+func meet(a, b *DHT) {
+	a.table.Add(loopbackContact(b))
+	b.table.Add(loopbackContact(a))
+}
+
+func loopbackContact(d *DHT) Contact {
+	return Contact{ID: d.table.self, Address: net.ParseIP("::1"), Port: d.port}
+}
@@ -0,0 +1,224 @@
+package dht
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"meshnet/yggadmin"
+)
+
+// NodeInfoKey is the entry MeshNet nodes publish in Yggdrasil's NodeInfo
+const NodeInfoKey = "meshnet"
+
+// discovery tuning
+const (
+	discoveryInterval = time.Minute
+	// a node without MeshNet is asked again after this long
+	discoveryRequery = 30 * time.Minute
+	// getNodeInfo waits up to 6s per silent node, so each scan is capped
+	discoveryMaxQueries = 32
+	discoveryWorkers    = 4
+)
+
+// NodeInfo is what a MeshNet node publishes under NodeInfoKey
+type NodeInfo struct {
+	// Name is the registered name, empty means node-<key prefix>
+	Name    string `json:"name,omitempty"`
+	DHTPort int    `json:"dht_port"`
+	Version int    `json:"version"`
+	Network string `json:"network,omitempty"`
+}
+
+// Map returns info in the shape Yggdrasil's NodeInfo setting takes
+func (n NodeInfo) Map() map[string]interface{} {
+	data, _ := json.Marshal(n)
+	var entry map[string]interface{}
+	json.Unmarshal(data, &entry)
+	return map[string]interface{}{NodeInfoKey: entry}
+}
+
+// ParseNodeInfo extracts the MeshNet entry from a getNodeInfo reply
+func ParseNodeInfo(info map[string]interface{}) (*NodeInfo, bool) {
+	entry, ok := info[NodeInfoKey]
+	if !ok {
+		return nil, false
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return nil, false
+	}
+	var n NodeInfo
+	if err := json.Unmarshal(data, &n); err != nil || n.DHTPort < 1 || n.DHTPort > 65535 {
+		return nil, false
+	}
+	return &n, true
+}
+
+// Discovery finds MeshNet nodes among the Yggdrasil nodes we know of —
+// peers, then paths, then the spanning tree — by asking each for its
+// NodeInfo and pinging those that advertise a DHT on our network
+type Discovery struct {
+	dht  *DHT
+	seen map[string]time.Time // key → last asked
+	done chan struct{}
+}
+
+// NewDiscovery creates a discovery loop for d, using d's admin endpoint
+func NewDiscovery(d *DHT) *Discovery {
+	return &Discovery{
+		dht:  d,
+		seen: make(map[string]time.Time),
+		done: make(chan struct{}),
+	}
+}
+
+// Start scans right away and then every minute in the background
+func (disc *Discovery) Start() {
+	go disc.loop()
+}
+
+// Stop shuts down the loop
+func (disc *Discovery) Stop() {
+	close(disc.done)
+}
+
+func (disc *Discovery) loop() {
+	ticker := time.NewTicker(discoveryInterval)
+	defer ticker.Stop()
+
+	for {
+		disc.scan()
+		select {
+		case <-ticker.C:
+		case <-disc.done:
+			return
+		}
+	}
+}
+
+// candidate is a Yggdrasil node that might run MeshNet
+type candidate struct {
+	key     string
+	address string
+}
+
+func (disc *Discovery) scan() {
+	endpoint := disc.dht.AdminEndpoint()
+	if endpoint == "" {
+		return
+	}
+	candidates, err := disc.candidates(endpoint)
+	if err != nil {
+		return
+	}
+
+	queue := make(chan candidate)
+	var wg sync.WaitGroup
+	for i := 0; i < discoveryWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// the admin client serializes calls, so each worker has its own
+			admin, err := yggadmin.Dial(endpoint, 3*time.Second)
+			if err != nil {
+				for range queue {
+				}
+				return
+			}
+			defer admin.Close()
+			for c := range queue {
+				disc.probe(admin, c)
+			}
+		}()
+	}
+
+	for _, c := range candidates {
+		select {
+		case queue <- c:
+		case <-disc.done:
+		}
+	}
+	close(queue)
+	wg.Wait()
+}
+
+// candidates lists nodes worth asking, closest first, skipping
+// ourselves, nodes already in the table and nodes asked recently
+func (disc *Discovery) candidates(endpoint string) ([]candidate, error) {
+	admin, err := yggadmin.Dial(endpoint, 3*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer admin.Close()
+
+	skip := map[string]bool{disc.dht.table.self.String(): true}
+	for _, c := range disc.dht.table.All() {
+		skip[c.ID.String()] = true
+	}
+	now := time.Now()
+	for key, asked := range disc.seen {
+		if now.Sub(asked) > discoveryRequery {
+			delete(disc.seen, key)
+		} else {
+			skip[key] = true
+		}
+	}
+
+	var out []candidate
+	add := func(key, address string) {
+		if skip[key] || address == "" || len(out) >= discoveryMaxQueries {
+			return
+		}
+		skip[key] = true
+		disc.seen[key] = now
+		out = append(out, candidate{key: key, address: address})
+	}
+
+	if peers, err := admin.GetPeers(); err == nil {
+		for _, p := range peers {
+			if p.Up {
+				add(p.PublicKey, p.Address)
+			}
+		}
+	}
+	if paths, err := admin.GetPaths(); err == nil {
+		for _, p := range paths {
+			add(p.PublicKey, p.Address)
+		}
+	}
+	if tree, err := admin.GetTree(); err == nil {
+		for _, t := range tree {
+			add(t.PublicKey, t.Address)
+		}
+	}
+	return out, nil
+}
+
+// probe asks one node for its NodeInfo and pings its DHT if it runs ours
+func (disc *Discovery) probe(admin *yggadmin.Client, c candidate) {
+	select {
+	case <-disc.done:
+		return
+	default:
+	}
+
+	info, err := admin.GetNodeInfo(c.key)
+	if err != nil {
+		return
+	}
+	mn, ok := ParseNodeInfo(info)
+	if !ok || mn.Network != disc.dht.network {
+		return
+	}
+
+	name := mn.Name
+	if name == "" {
+		name = "node-" + c.key[:8]
+	}
+	if err := disc.dht.PingPeer(fmt.Sprintf("[%s]:%d", c.address, mn.DHTPort)); err != nil {
+		return
+	}
+	fmt.Printf("Discovered MeshNet node %s via NodeInfo\n", name)
+}
@@ -0,0 +1,116 @@
+package dht
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+
+	"meshnet/yggadmin/yggadmintest"
+)
+
+func TestParseNodeInfo(t *testing.T) {
+	tests := []struct {
+		name string
+		info map[string]interface{}
+		want *NodeInfo
+	}{
+		{"published", NodeInfo{Name: "alice", DHTPort: 9001, Version: 3, Network: "lab"}.Map(),
+			&NodeInfo{Name: "alice", DHTPort: 9001, Version: 3, Network: "lab"}},
+		{"among other entries", map[string]interface{}{
+			"buildname": "yggdrasil",
+			NodeInfoKey: map[string]interface{}{"dht_port": float64(9002), "version": float64(3)},
+		}, &NodeInfo{DHTPort: 9002, Version: 3}},
+		{"no MeshNet", map[string]interface{}{"buildname": "yggdrasil"}, nil},
+		{"no port", map[string]interface{}{NodeInfoKey: map[string]interface{}{"name": "alice"}}, nil},
+		{"port out of range", NodeInfo{DHTPort: 70000}.Map(), nil},
+		{"not an object", map[string]interface{}{NodeInfoKey: "9001"}, nil},
+	}
+	for _, tt := range tests {
+		got, ok := ParseNodeInfo(tt.info)
+		if ok != (tt.want != nil) {
+			t.Errorf("%s: ok = %v", tt.name, ok)
+			continue
+		}
+		if ok && *got != *tt.want {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// A node's admin socket knows of three Yggdrasil nodes that publish
+// MeshNet NodeInfo — one on our network, one on another, one with a
+// down link — and one that doesn't run MeshNet. Only the first is
+// pinged and joins the table.
+func TestDiscoveryFiltersByNetwork(t *testing.T) {
+	nodes := startHandshakeNodes(t, 4)
+	d, ours, theirs, down := nodes[0], nodes[1], nodes[2], nodes[3]
+	d.checkAddress = loopbackOwners(ours.table.self, theirs.table.self, down.table.self)
+	ours.checkAddress = loopbackOwners(d.table.self)
+	theirs.checkAddress = loopbackOwners(d.table.self)
+	down.checkAddress = loopbackOwners(d.table.self)
+	plain := NodeID{0xee}
+
+	info := map[string]NodeInfo{
+		ours.table.self.String():   {DHTPort: ours.port},
+		theirs.table.self.String(): {DHTPort: theirs.port, Network: "other"},
+		down.table.self.String():   {DHTPort: down.port},
+	}
+	var mu sync.Mutex
+	asked := make(map[string]bool)
+	endpoint := yggadmintest.Serve(t, "tcp", func(name string, args json.RawMessage) (interface{}, error) {
+		switch name {
+		case "getPeers":
+			return map[string]interface{}{"peers": []map[string]interface{}{
+				{"key": ours.table.self.String(), "address": "::1", "up": true},
+				{"key": down.table.self.String(), "address": "::1", "up": false},
+			}}, nil
+		case "getPaths":
+			return map[string]interface{}{"paths": []map[string]interface{}{
+				{"key": theirs.table.self.String(), "address": "::1"},
+			}}, nil
+		case "getTree":
+			return map[string]interface{}{"tree": []map[string]interface{}{
+				{"key": plain.String(), "address": "::1"},
+				{"key": d.table.self.String(), "address": "::1"},
+			}}, nil
+		case "getNodeInfo":
+			var req struct {
+				Key string `json:"key"`
+			}
+			json.Unmarshal(args, &req)
+			mu.Lock()
+			asked[req.Key] = true
+			mu.Unlock()
+			if mn, ok := info[req.Key]; ok {
+				return map[string]interface{}{req.Key: mn.Map()}, nil
+			}
+			return map[string]interface{}{req.Key: map[string]interface{}{"buildname": "yggdrasil"}}, nil
+		}
+		return nil, fmt.Errorf("unknown request %s", name)
+	})
+	d.SetAdminEndpoint(endpoint)
+
+	disc := NewDiscovery(d)
+	disc.scan()
+
+	if !d.table.Contains(ours.table.self) {
+		t.Error("node on our network not added")
+	}
+	if d.table.Contains(theirs.table.self) {
+		t.Error("node on another network added")
+	}
+	if d.table.Contains(down.table.self) || asked[down.table.self.String()] {
+		t.Error("peer with a down link asked")
+	}
+	if !asked[plain.String()] || asked[d.table.self.String()] {
+		t.Errorf("asked %v, want every candidate but ourselves", asked)
+	}
+
+	// nodes just asked are left alone on the next scan
+	asked = make(map[string]bool)
+	disc.scan()
+	if len(asked) != 0 {
+		t.Errorf("asked %v again right away", asked)
+	}
+}
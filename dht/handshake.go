@@ -0,0 +1,140 @@
+package dht
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"sync/atomic"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/address"
+)
+
+// A ping is a three-way handshake on one connection:
+//
+//	ping     → nonce A
+//	pong     ← signature over A by the responder, nonce B
+//	ping ack → signature over B by the pinger
+//
+// Each side checks that the signature verifies under the claimed
+// NodeID — which is the ed25519 key — and that the connection comes
+// from the Yggdrasil address that key owns. Only then does the other
+// side go into the routing table.
+
+const nonceSize = 32
+
+// signing contexts, so a signature made for one step can't be replayed
+// as another
+const (
+	pongContext    = "meshnet-dht-pong"
+	pingAckContext = "meshnet-dht-ping-ack"
+)
+
+// handshake rejection reasons
+var (
+	errUnsigned     = fmt.Errorf("handshake not signed")
+	errBadSignature = fmt.Errorf("handshake signature does not match the node ID")
+	errWrongAddress = fmt.Errorf("connection does not come from the node ID's address")
+)
+
+// HandshakeStats counts ping handshakes in both directions
+type HandshakeStats struct {
+	Accepted     uint64 `json:"accepted"`
+	Unsigned     uint64 `json:"rejected_unsigned"`
+	BadSignature uint64 `json:"rejected_signature"`
+	WrongAddress uint64 `json:"rejected_address"`
+}
+
+type handshakeCounters struct {
+	accepted, unsigned, badSignature, wrongAddress atomic.Uint64
+}
+
+// count records the outcome of a handshake, err being nil on success
+func (c *handshakeCounters) count(err error) {
+	switch err {
+	case nil:
+		c.accepted.Add(1)
+	case errUnsigned:
+		c.unsigned.Add(1)
+	case errBadSignature:
+		c.badSignature.Add(1)
+	case errWrongAddress:
+		c.wrongAddress.Add(1)
+	}
+}
+
+// HandshakeStats returns the handshake counters
+func (d *DHT) HandshakeStats() HandshakeStats {
+	return HandshakeStats{
+		Accepted:     d.handshakes.accepted.Load(),
+		Unsigned:     d.handshakes.unsigned.Load(),
+		BadSignature: d.handshakes.badSignature.Load(),
+		WrongAddress: d.handshakes.wrongAddress.Load(),
+	}
+}
+
+func newNonce() (string, error) {
+	b := make([]byte, nonceSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// handshakePayload is what gets signed: the step, our network and the
+// peer's nonce
+func handshakePayload(context, network, nonce string) []byte {
+	h := sha256.New()
+	h.Write([]byte(context))
+	h.Write([]byte{0})
+	h.Write([]byte(network))
+	h.Write([]byte{0})
+	h.Write([]byte(nonce))
+	return h.Sum(nil)
+}
+
+// signHandshake signs the peer's nonce for one step of the handshake
+func (d *DHT) signHandshake(context, nonce string) string {
+	if d.privKey == nil {
+		return ""
+	}
+	return hex.EncodeToString(ed25519.Sign(d.privKey, handshakePayload(context, d.network, nonce)))
+}
+
+// verifyHandshake checks that sig is id's signature over our nonce and
+// that conn comes from id's Yggdrasil address
+func (d *DHT) verifyHandshake(conn rpcConn, id NodeID, context, nonce, sig string) error {
+	if sig == "" {
+		return errUnsigned
+	}
+	sigBytes, err := hex.DecodeString(sig)
+	if err != nil {
+		return errBadSignature
+	}
+	if !ed25519.Verify(ed25519.PublicKey(id[:]), handshakePayload(context, d.network, nonce), sigBytes) {
+		return errBadSignature
+	}
+	if !d.checkAddress(id, remoteIP(conn)) {
+		return errWrongAddress
+	}
+	return nil
+}
+
+// ownsAddress reports whether ip is the Yggdrasil address of key id
+func ownsAddress(id NodeID, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	addr := address.AddrForKey(ed25519.PublicKey(id[:]))
+	return addr != nil && net.IP(addr[:]).Equal(ip)
+}
+
+func remoteIP(conn rpcConn) net.IP {
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
@@ -0,0 +1,173 @@
+package dht
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"net"
+	"testing"
+)
+
+// loopbackOwners is an address check under which loopback is the
+// address of each of ids and of no other key
+func loopbackOwners(ids ...NodeID) func(NodeID, net.IP) bool {
+	return func(id NodeID, ip net.IP) bool {
+		for _, owner := range ids {
+			if owner == id {
+				return ip.IsLoopback()
+			}
+		}
+		return false
+	}
+}
+
+func startHandshakeNodes(t *testing.T, n int) []*DHT {
+	t.Helper()
+	nodes := make([]*DHT, n)
+	for i := range nodes {
+		nodes[i] = newTestNode(t)
+		if err := nodes[i].Start(); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(nodes[i].Stop)
+	}
+	return nodes
+}
+
+// handshakeAs pings d as the holder of priv, answering d's nonce with
+// the signature sign returns for it
+func handshakeAs(t *testing.T, d *DHT, priv ed25519.PrivateKey, sign func(nonce string) string) {
+	t.Helper()
+	conn, err := newConnPool(TCPTransport{}).dialStream(loopbackContact(d).Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	nonce, _ := newNonce()
+	err = conn.Send(MsgPing, PingBody{
+		SenderID: NodeIDFromPublicKey(priv.Public().(ed25519.PublicKey)).String(),
+		Nonce:    nonce,
+		Version:  ProtocolVersion,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply, err := conn.Receive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pong PongBody
+	if err := reply.Decode(&pong); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Send(MsgPingAck, PingAckBody{Signature: sign(pong.Nonce)}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func signedBy(priv ed25519.PrivateKey) func(string) string {
+	return func(nonce string) string {
+		return hex.EncodeToString(ed25519.Sign(priv, handshakePayload(pingAckContext, "", nonce)))
+	}
+}
+
+func TestHandshakeAddsPeer(t *testing.T) {
+	nodes := startHandshakeNodes(t, 2)
+	a, b := nodes[0], nodes[1]
+	a.checkAddress = loopbackOwners(b.table.self)
+	b.checkAddress = loopbackOwners(a.table.self)
+
+	if err := a.PingPeer(loopbackContact(b).Addr()); err != nil {
+		t.Fatal(err)
+	}
+	if !a.table.Contains(b.table.self) {
+		t.Error("pinger did not add the responder")
+	}
+	waitFor(t, "responder to add the pinger", func() bool {
+		return b.table.Contains(a.table.self)
+	})
+	if a.HandshakeStats().Accepted != 1 || b.HandshakeStats().Accepted != 1 {
+		t.Errorf("stats %+v, %+v", a.HandshakeStats(), b.HandshakeStats())
+	}
+}
+
+func TestHandshakeRejectsWrongSignature(t *testing.T) {
+	nodes := startHandshakeNodes(t, 2)
+	a, liar := nodes[0], nodes[1]
+	a.checkAddress = loopbackOwners(liar.table.self)
+
+	// the responder signs with a key other than its ID's
+	_, other, _ := ed25519.GenerateKey(nil)
+	liar.SetPrivateKey(other)
+	if err := a.PingPeer(loopbackContact(liar).Addr()); err == nil {
+		t.Fatal("pong signed by another key accepted")
+	}
+	if a.table.Contains(liar.table.self) || a.HandshakeStats().BadSignature != 1 {
+		t.Errorf("responder added or not counted: %+v", a.HandshakeStats())
+	}
+
+	// and the pinger does
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	a.checkAddress = loopbackOwners(NodeIDFromPublicKey(pub))
+	handshakeAs(t, a, priv, signedBy(other))
+	waitFor(t, "bad ack to be counted", func() bool {
+		return a.HandshakeStats().BadSignature == 2
+	})
+	if a.table.Size() != 0 {
+		t.Error("pinger with a bad signature added")
+	}
+}
+
+func TestHandshakeRejectsReplayedNonce(t *testing.T) {
+	d := startHandshakeNodes(t, 1)[0]
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	peer := NodeIDFromPublicKey(pub)
+	d.checkAddress = loopbackOwners(peer)
+
+	var first string
+	handshakeAs(t, d, priv, func(nonce string) string {
+		first = signedBy(priv)(nonce)
+		return first
+	})
+	waitFor(t, "first handshake", func() bool { return d.table.Contains(peer) })
+	d.table.Remove(peer)
+
+	// the peer's signature, replayed by someone without its key, is
+	// over the last handshake's nonce, not this one's
+	handshakeAs(t, d, priv, func(string) string { return first })
+	waitFor(t, "replay to be counted", func() bool {
+		return d.HandshakeStats().BadSignature == 1
+	})
+	if d.table.Contains(peer) {
+		t.Error("replayed handshake added the peer")
+	}
+	if stats := d.HandshakeStats(); stats.Accepted != 1 {
+		t.Errorf("stats %+v", stats)
+	}
+}
+
+func TestHandshakeRejectsWrongAddress(t *testing.T) {
+	nodes := startHandshakeNodes(t, 2)
+	a, b := nodes[0], nodes[1]
+	// b keeps the real check, under which loopback is nobody's address
+	a.checkAddress = loopbackOwners(b.table.self)
+
+	if err := a.PingPeer(loopbackContact(b).Addr()); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "ack from the wrong address to be counted", func() bool {
+		return b.HandshakeStats().WrongAddress == 1
+	})
+	if b.table.Contains(a.table.self) {
+		t.Error("pinger from another key's address added")
+	}
+
+	// and the other way round
+	if err := b.PingPeer(loopbackContact(a).Addr()); !errors.Is(err, errWrongAddress) {
+		t.Errorf("got %v, want %v", err, errWrongAddress)
+	}
+	if b.table.Contains(a.table.self) || b.HandshakeStats().WrongAddress != 2 {
+		t.Errorf("responder added or not counted: %+v", b.HandshakeStats())
+	}
+}
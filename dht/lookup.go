@@ -61,14 +61,23 @@ func (ls *lookupState) addCandidates(contacts []Contact) {
 }
 
 func (ls *lookupState) nextBatch() []Contact {
+	return ls.nextBatchAmong(0, alpha)
+}
+
+// nextBatchAmong returns up to size contacts not yet asked from the k
+// closest candidates, 0 meaning all of them
+func (ls *lookupState) nextBatchAmong(k, size int) []Contact {
 	ls.mu.Lock()
 	defer ls.mu.Unlock()
 
 	var batch []Contact
-	for _, c := range ls.candidates {
+	for i, c := range ls.candidates {
+		if k > 0 && i >= k {
+			break
+		}
 		if !ls.contacted[c.ID] {
 			batch = append(batch, c)
-			if len(batch) >= alpha {
+			if len(batch) >= size {
 				break
 			}
 		}
@@ -76,6 +85,19 @@ func (ls *lookupState) nextBatch() []Contact {
 	return batch
 }
 
+// drop removes a candidate that didn't answer, so the next closest
+// takes its place among the k closest
+func (ls *lookupState) drop(id NodeID) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	for i, c := range ls.candidates {
+		if c.ID == id {
+			ls.candidates = append(ls.candidates[:i], ls.candidates[i+1:]...)
+			return
+		}
+	}
+}
+
 func (ls *lookupState) markContacted(id NodeID) {
 	ls.mu.Lock()
 	defer ls.mu.Unlock()
@@ -97,6 +119,7 @@ func (d *DHT) LookupNode(target NodeID) []Contact {
 	if len(seeds) == 0 {
 		return nil
 	}
+	d.table.markLookup(target)
 
 	state := newLookupState(d.table.self, target, seeds)
 
@@ -115,10 +138,18 @@ func (d *DHT) LookupNode(target NodeID) []Contact {
 				defer wg.Done()
 				state.markContacted(c.ID)
 
-				contacts, err := SendFindNode(c.Addr(), d.table.self, target)
+				start := time.Now()
+				contacts, err := d.SendFindNode(c.Addr(), d.table.self, target)
 				if err != nil {
+					d.table.Failed(c.ID)
 					return
 				}
+				d.table.Seen(c.ID, time.Since(start))
+				// it answered — the handshake proves it is who it was
+				// claimed to be before it goes into our table
+				if !d.table.Contains(c.ID) {
+					d.PingPeer(c.Addr())
+				}
 
 				var found []Contact
 				for _, ci := range contacts {
@@ -134,6 +165,7 @@ func (d *DHT) LookupNode(target NodeID) []Contact {
 						ID:      id,
 						Address: ip,
 						Port:    ci.Port,
+						Network: ci.Network,
 					})
 				}
 				results <- found
@@ -147,9 +179,6 @@ func (d *DHT) LookupNode(target NodeID) []Contact {
 
 		for contacts := range results {
 			state.addCandidates(contacts)
-			for _, c := range contacts {
-				d.table.Add(c)
-			}
 		}
 	}
 
@@ -168,22 +197,44 @@ func (d *DHT) LookupValue(name string, groupKey string) (*Record, error) {
 	if localFound {
 		return &localRecord, nil
 	}
+	if cached, ok := d.cache.get(name, groupKey); ok {
+		if cached == nil || !d.store.IsSuperseded(cached.PublicKey) {
+			return cached, nil
+		}
+		d.cache.invalidate(name)
+	}
 
 	target := RecordID(name)
 	seeds := d.table.Closest(target, K)
 	if len(seeds) == 0 {
 		return nil, fmt.Errorf("no known nodes to query")
 	}
+	d.table.markLookup(target)
 
 	state := newLookupState(d.table.self, target, seeds)
 
+	// what the nodes asked answered: the newest record of the key holding
+	// the name and who had it, who had an older one, and who had none
+	var mu sync.Mutex
+	var newest *Record
+	var holders, stale, missed []Contact
+
+	// the lookup goes on until the K closest nodes have answered — the
+	// closest may hold a newer copy than the first one found. Once there
+	// is a copy, the rest of them are asked at once.
+	timedOut := false
 	for {
-		batch := state.nextBatch()
+		mu.Lock()
+		size := alpha
+		if newest != nil {
+			size = K
+		}
+		mu.Unlock()
+		batch := state.nextBatchAmong(K, size)
 		if len(batch) == 0 {
 			break
 		}
 
-		found := make(chan *Record, 1)
 		var wg sync.WaitGroup
 
 		for _, contact := range batch {
@@ -192,24 +243,56 @@ func (d *DHT) LookupValue(name string, groupKey string) (*Record, error) {
 				defer wg.Done()
 				state.markContacted(c.ID)
 
-				record, closer, err := SendFindValue(
+				start := time.Now()
+				record, closer, err := d.SendFindValue(
 					c.Addr(),
 					d.table.self,
 					name,
 					groupKey,
 				)
 				if err != nil {
-					d.table.Remove(c.ID)
+					d.table.Failed(c.ID)
+					state.drop(c.ID)
 					return
 				}
+				d.table.Seen(c.ID, time.Since(start))
 
 				if record != nil {
-					select {
-					case found <- record:
+					if !d.acceptLookupResult(record, name, groupKey) {
+						// forged, expired, for another name, or from a
+						// rotated-out key — keep looking
+						return
+					}
+					mu.Lock()
+					defer mu.Unlock()
+					switch {
+					case newest == nil:
+						newest, holders = record, []Contact{c}
+					case record.PublicKey != newest.PublicKey:
+						// sequence numbers only order one key's records —
+						// another key's copy wins only through a succession
+						// from the key holding the name, and an unrelated
+						// key's is dropped however high its sequence
+						switch {
+						case d.store.Succeeds(newest.PublicKey, record.PublicKey):
+							stale = append(stale, holders...)
+							newest, holders = record, []Contact{c}
+						case d.store.Succeeds(record.PublicKey, newest.PublicKey):
+							stale = append(stale, c)
+						}
+					case record.Sequence > newest.Sequence:
+						stale = append(stale, holders...)
+						newest, holders = record, []Contact{c}
+					case record.Sequence == newest.Sequence:
+						holders = append(holders, c)
 					default:
+						stale = append(stale, c)
 					}
 					return
 				}
+				mu.Lock()
+				missed = append(missed, c)
+				mu.Unlock()
 
 				if closer != nil {
 					var contacts []Contact
@@ -222,10 +305,14 @@ func (d *DHT) LookupValue(name string, groupKey string) (*Record, error) {
 						if ip == nil {
 							continue
 						}
+						if ci.Network != d.network {
+							continue
+						}
 						contacts = append(contacts, Contact{
 							ID:      id,
 							Address: ip,
 							Port:    ci.Port,
+							Network: ci.Network,
 						})
 					}
 					state.addCandidates(contacts)
@@ -240,57 +327,129 @@ func (d *DHT) LookupValue(name string, groupKey string) (*Record, error) {
 		}()
 
 		select {
-		case record := <-found:
-			return record, nil
 		case <-done:
 		case <-time.After(readTimeout):
-			return nil, nil
+			timedOut = true
+		}
+		if timedOut {
+			break
 		}
 	}
 
+	mu.Lock()
+	defer mu.Unlock()
+	if newest != nil {
+		d.cache.put(name, groupKey, *newest)
+		go d.spreadRecord(*newest,
+			append(append([]Contact(nil), holders...), stale...),
+			append([]Contact(nil), stale...),
+			append([]Contact(nil), missed...))
+		r := *newest
+		return &r, nil
+	}
+	// a lookup that timed out may have missed a holder, so only one that
+	// heard from the K closest says nobody has it
+	if !timedOut {
+		d.cache.putMissing(name, groupKey)
+	}
 	return nil, nil
 }
 
-func (d *DHT) Announce(record Record) error {
-	if err := record.Verify(); err != nil {
-		return fmt.Errorf("invalid record: %w", err)
+// cached copies last cachedCopyTTL on a node as close to the name as
+// the closest holder, and half as long for every bit of distance more,
+// down to cachedCopyMinTTL — the copies nearest the name, which most
+// lookups pass, are kept longest
+const (
+	cachedCopyTTL    = 10 * time.Minute
+	cachedCopyMinTTL = 30 * time.Second
+)
+
+// spreadRecord sends the newest copy of r to the nodes that had an older
+// one, and caches it on the closest node that had none, so the next
+// lookup for it stops there. held is every node that had a copy.
+func (d *DHT) spreadRecord(r Record, held, stale, missed []Contact) {
+	for _, c := range stale {
+		d.SendStore(c.Addr(), r)
+	}
+	if len(missed) == 0 {
+		return
 	}
+	target := RecordID(r.Name)
+	closest := missed[0]
+	for _, c := range missed[1:] {
+		if c.ID.Less(closest.ID, target) {
+			closest = c
+		}
+	}
+	d.SendCacheStore(closest.Addr(), r, cachedCopyTTLAt(closest, held, target))
+}
 
-	// store locally first
-	if err := d.store.Put(record); err != nil {
-		return fmt.Errorf("failed to store locally: %w", err)
+// cachedCopyTTLAt is how long c keeps a cached copy of the record at
+// target, held by the nodes in held
+func cachedCopyTTLAt(c Contact, held []Contact, target NodeID) time.Duration {
+	farther := 0
+	for _, h := range held {
+		if gap := prefixLen(h.ID, target) - prefixLen(c.ID, target); gap > farther {
+			farther = gap
+		}
+	}
+	if farther >= 16 {
+		return cachedCopyMinTTL
+	}
+	ttl := cachedCopyTTL >> farther
+	if ttl < cachedCopyMinTTL {
+		ttl = cachedCopyMinTTL
 	}
+	return ttl
+}
 
-	// distribute to closest nodes
-	target := RecordID(record.Name)
-	closest := d.LookupNode(target)
+// prefixLen is how many leading bits id shares with target
+func prefixLen(id, target NodeID) int {
+	if i := id.bucketIndex(target); i >= 0 {
+		return i
+	}
+	return len(id) * 8
+}
 
-	if len(closest) == 0 {
-		// no other nodes yet — stored locally, will propagate when peers connect
-		return nil
+// acceptLookupResult vets a record returned by a remote node for name
+// in groupKey — a valid signature alone doesn't make it the answer
+// a succession riding along teaches us about the rotation
+func (d *DHT) acceptLookupResult(r *Record, name, groupKey string) bool {
+	if r.Name != name || r.GroupKey != groupKey {
+		return false
+	}
+	if r.Network != d.network || r.IsExpired() || r.Verify() != nil {
+		return false
 	}
+	if r.Succession != nil && r.Succession.NewKey == r.PublicKey {
+		d.store.AddSuccession(*r.Succession)
+	}
+	return !d.store.IsSuperseded(r.PublicKey)
+}
 
-	var wg sync.WaitGroup
-	stored := 0
-	var mu sync.Mutex
+func (d *DHT) Announce(record Record) error {
+	_, err := d.announce(record)
+	return err
+}
 
-	for _, contact := range closest {
-		wg.Add(1)
-		go func(c Contact) {
-			defer wg.Done()
-			if SendStore(c.Addr(), record) == nil {
-				mu.Lock()
-				stored++
-				mu.Unlock()
-			}
-		}(contact)
+// announce is Announce, also returning how many other nodes took the record
+func (d *DHT) announce(record Record) (int, error) {
+	if err := record.Verify(); err != nil {
+		return 0, fmt.Errorf("invalid record: %w", err)
 	}
 
-	wg.Wait()
+	// store locally first
+	if err := d.store.Put(record); err != nil {
+		return 0, fmt.Errorf("failed to store locally: %w", err)
+	}
+	d.cache.invalidate(record.Name)
 
+	// distribute to closest nodes — with none yet, it's stored locally and
+	// handed to nodes as they join
+	stored := d.storeAtClosest(record)
 	if stored > 0 {
 		fmt.Printf("Announced %q on %d nodes\n", record.Name, stored)
 	}
 
-	return nil
+	return stored, nil
 }
@@ -0,0 +1,132 @@
+package dht
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"testing"
+)
+
+func TestAcceptLookupResultChecksName(t *testing.T) {
+	d := New("::1", NodeID{1}, 0)
+	evil, _ := testRecord(t, "evil")
+
+	if d.acceptLookupResult(&evil, "alice", "") {
+		t.Error("record for \"evil\" accepted as the answer for \"alice\"")
+	}
+	if d.acceptLookupResult(&evil, "evil", "group") {
+		t.Error("public record accepted as the answer for a group lookup")
+	}
+	if !d.acceptLookupResult(&evil, "evil", "") {
+		t.Error("matching record refused")
+	}
+}
+
+// the first copy found is older than the one a closer node holds, which
+// only a node that had none knows about
+func TestLookupAsksClosestForNewest(t *testing.T) {
+	var nodes []*DHT
+	for i := 0; i < 4; i++ {
+		d := newTestNode(t)
+		if err := d.Start(); err != nil {
+			t.Fatal(err)
+		}
+		defer d.Stop()
+		nodes = append(nodes, d)
+	}
+	asker, stale, pointer, fresh := nodes[0], nodes[1], nodes[2], nodes[3]
+	asker.table.Add(loopbackContact(stale))
+	asker.table.Add(loopbackContact(pointer))
+	pointer.table.Add(loopbackContact(fresh))
+
+	old, key := testRecord(t, "alice")
+	newer := old
+	newer.Address = "200::2"
+	newer.Sequence = old.Sequence + 1
+	newer.Signature = hex.EncodeToString(ed25519.Sign(key, newer.SigningPayload()))
+	if err := stale.store.Put(old); err != nil {
+		t.Fatal(err)
+	}
+	if err := fresh.store.Put(newer); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := asker.LookupValue("alice", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r == nil || r.Sequence != newer.Sequence {
+		t.Fatalf("got %+v, want the record with sequence %d", r, newer.Sequence)
+	}
+	// the stale holder is brought up to date
+	waitFor(t, "update", func() bool {
+		r, _ := stale.store.GetPublic("alice")
+		return r.Sequence == newer.Sequence
+	})
+}
+
+// a node near the name answers with its own record for it at a far
+// higher sequence — another key's sequence doesn't outrank the owner's
+func TestLookupIgnoresOtherKeysSequence(t *testing.T) {
+	var nodes []*DHT
+	for i := 0; i < 4; i++ {
+		d := newTestNode(t)
+		if err := d.Start(); err != nil {
+			t.Fatal(err)
+		}
+		defer d.Stop()
+		nodes = append(nodes, d)
+	}
+	asker, holder, pointer, forger := nodes[0], nodes[1], nodes[2], nodes[3]
+	asker.table.Add(loopbackContact(holder))
+	asker.table.Add(loopbackContact(pointer))
+	// the forger is only heard of once the holder has answered
+	pointer.table.Add(loopbackContact(forger))
+
+	owned, _ := testRecord(t, "alice")
+	forged, key := testRecord(t, "alice")
+	forged.Address = "200::666"
+	forged.Sequence = owned.Sequence + 1000
+	forged.Signature = hex.EncodeToString(ed25519.Sign(key, forged.SigningPayload()))
+	if err := holder.store.Put(owned); err != nil {
+		t.Fatal(err)
+	}
+	if err := forger.store.Put(forged); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := asker.LookupValue("alice", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r == nil || r.PublicKey != owned.PublicKey {
+		t.Fatalf("got %+v, want the owner's record", r)
+	}
+	// the copy spread to the closest node that had none is the owner's
+	waitFor(t, "spread", func() bool {
+		_, ok := pointer.store.Get("alice")
+		return ok
+	})
+	if r, _ := pointer.store.Get("alice"); r.PublicKey != owned.PublicKey {
+		t.Error("forged record spread")
+	}
+	if len(pointer.store.All()) != 0 {
+		t.Error("copy spread for caching held as a full record")
+	}
+}
+
+func TestCachedCopyTTLFallsWithDistance(t *testing.T) {
+	var target NodeID
+	near := Contact{ID: NodeID{0x20}}
+	holders := []Contact{{ID: NodeID{0x08}}}
+
+	if ttl := cachedCopyTTLAt(near, []Contact{near}, target); ttl != cachedCopyTTL {
+		t.Errorf("as close as the holders: %v, want %v", ttl, cachedCopyTTL)
+	}
+	// near shares 2 bits with the target, the holder 4
+	if ttl := cachedCopyTTLAt(near, holders, target); ttl != cachedCopyTTL/4 {
+		t.Errorf("2 bits farther: %v, want %v", ttl, cachedCopyTTL/4)
+	}
+	if ttl := cachedCopyTTLAt(Contact{ID: NodeID{0x80}}, []Contact{{ID: NodeID{0, 1}}}, target); ttl != cachedCopyMinTTL {
+		t.Errorf("far away: %v, want %v", ttl, cachedCopyMinTTL)
+	}
+}
@@ -0,0 +1,164 @@
+package dht
+
+import (
+	"crypto/rand"
+	"sync"
+	"time"
+)
+
+// The maintenance loop keeps the routing table current. Once the table
+// has its first contacts it looks up our own ID, which fills the
+// buckets nearest us and puts us in our neighbours' tables. After that
+// it looks up a random ID in every bucket no lookup has touched for an
+// hour, and pings contacts that haven't answered anything for a while.
+
+// maintenance tuning
+const (
+	maintainInterval = 5 * time.Minute
+	// joinPoll is how often a node with an empty table checks for contacts
+	joinPoll = 5 * time.Second
+	// bucketRefresh is how long a bucket goes without a lookup before
+	// it gets one of its own
+	bucketRefresh = time.Hour
+	// idlePing is how long a contact goes unheard before it is pinged
+	idlePing = 15 * time.Minute
+	// maxFailures drops a contact after this many failed RPCs in a row
+	maxFailures = 3
+	// maintainWorkers bounds the idle contacts pinged at once
+	maintainWorkers = 4
+)
+
+func (d *DHT) maintain() {
+	joined := false
+	wait := joinPoll
+	for {
+		select {
+		case <-time.After(wait):
+		case <-d.done:
+			return
+		}
+
+		if d.table.Size() == 0 {
+			// nothing to ask yet, or everyone left — join again later
+			joined = false
+			wait = joinPoll
+			continue
+		}
+		if !joined {
+			d.LookupNode(d.table.self)
+			joined = true
+		}
+		d.refreshBuckets()
+		d.pingIdle()
+		wait = maintainInterval
+	}
+}
+
+// refreshBuckets looks up a random ID in each stale bucket
+func (d *DHT) refreshBuckets() {
+	for _, idx := range d.table.staleBuckets(bucketRefresh) {
+		select {
+		case <-d.done:
+			return
+		default:
+		}
+		d.LookupNode(d.table.randomID(idx))
+	}
+}
+
+// pingIdle pings the contacts not heard from lately
+func (d *DHT) pingIdle() {
+	sem := make(chan struct{}, maintainWorkers)
+	var wg sync.WaitGroup
+	for _, c := range d.table.idle(idlePing) {
+		select {
+		case sem <- struct{}{}:
+		case <-d.done:
+			wg.Wait()
+			return
+		}
+		wg.Add(1)
+		go func(c Contact) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			d.checkContact(c)
+		}(c)
+	}
+	wg.Wait()
+}
+
+// checkContact pings c and records how it went
+func (d *DHT) checkContact(c Contact) error {
+	start := time.Now()
+	if err := d.pingContact(c); err != nil {
+		d.table.Failed(c.ID)
+		return err
+	}
+	d.table.Seen(c.ID, time.Since(start))
+	return nil
+}
+
+// ── table ────────────────────────────────────────────────────────────────────
+
+// markLookup notes a lookup for target, which refreshes its bucket
+func (rt *RoutingTable) markLookup(target NodeID) {
+	idx := rt.self.bucketIndex(target)
+	if idx < 0 {
+		return
+	}
+	rt.mu.Lock()
+	rt.lookedUp[idx] = time.Now()
+	rt.mu.Unlock()
+}
+
+// staleBuckets lists buckets no lookup has touched for age, up to the
+// deepest one holding contacts — buckets past it cover so little of the
+// ID space that a random ID in them finds no one
+func (rt *RoutingTable) staleBuckets(age time.Duration) []int {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+
+	deepest := -1
+	for i, bucket := range rt.buckets {
+		if len(bucket) > 0 {
+			deepest = i
+		}
+	}
+	var stale []int
+	for i := 0; i <= deepest; i++ {
+		if time.Since(rt.lookedUp[i]) > age {
+			stale = append(stale, i)
+		}
+	}
+	return stale
+}
+
+// randomID returns a random ID that falls in bucket idx
+func (rt *RoutingTable) randomID(idx int) NodeID {
+	var id NodeID
+	rand.Read(id[:])
+	// share idx leading bits with us, then differ
+	for bit := 0; bit <= idx; bit++ {
+		mask := byte(0x80) >> (bit % 8)
+		id[bit/8] &^= mask
+		id[bit/8] |= rt.self[bit/8] & mask
+	}
+	id[idx/8] ^= byte(0x80) >> (idx % 8)
+	return id
+}
+
+// idle returns the contacts not seen for age
+func (rt *RoutingTable) idle(age time.Duration) []Contact {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+
+	var idle []Contact
+	for _, bucket := range rt.buckets {
+		for _, c := range bucket {
+			if time.Since(c.LastSeen) > age {
+				idle = append(idle, c)
+			}
+		}
+	}
+	return idle
+}
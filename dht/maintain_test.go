@@ -0,0 +1,70 @@
+package dht
+
+import (
+	"testing"
+	"time"
+)
+
+// setLookedUp marks every bucket as last looked up at t
+func setLookedUp(rt *RoutingTable, t time.Time) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	for i := range rt.lookedUp {
+		rt.lookedUp[i] = t
+	}
+}
+
+func TestRefreshStaleBucket(t *testing.T) {
+	nodes := startHandshakeNodes(t, 3)
+	a, b, c := nodes[0], nodes[1], nodes[2]
+	for _, d := range nodes {
+		d.checkAddress = loopbackOwners(a.table.self, b.table.self, c.table.self)
+	}
+	// a knows only b, which knows c
+	a.table.Add(loopbackContact(b))
+	meet(b, c)
+	idx := a.table.self.bucketIndex(b.table.self)
+
+	// fresh buckets are left alone
+	setLookedUp(a.table, time.Now())
+	a.refreshBuckets()
+	if a.table.Contains(c.table.self) {
+		t.Fatal("fresh buckets looked up")
+	}
+
+	a.table.mu.Lock()
+	a.table.lookedUp[idx] = time.Now().Add(-2 * bucketRefresh)
+	a.table.mu.Unlock()
+	if stale := a.table.staleBuckets(bucketRefresh); len(stale) != 1 || stale[0] != idx {
+		t.Fatalf("stale buckets %v, want [%d]", stale, idx)
+	}
+
+	a.refreshBuckets()
+	if !a.table.Contains(c.table.self) {
+		t.Error("refresh lookup did not find b's contact")
+	}
+	for _, i := range a.table.staleBuckets(bucketRefresh) {
+		if i == idx {
+			t.Errorf("bucket %d still stale after its refresh", idx)
+		}
+	}
+}
+
+func TestStaleBucketsStopAtDeepest(t *testing.T) {
+	rt := NewRoutingTable(NodeID{})
+	if stale := rt.staleBuckets(bucketRefresh); len(stale) != 0 {
+		t.Errorf("empty table has stale buckets %v", stale)
+	}
+
+	// a contact in bucket 3: buckets 0-3 are worth refreshing, none past
+	rt.Add(Contact{ID: NodeID{0x10}})
+	stale := rt.staleBuckets(bucketRefresh)
+	if len(stale) != 4 || stale[3] != 3 {
+		t.Errorf("stale buckets %v, want [0 1 2 3]", stale)
+	}
+	for _, idx := range stale {
+		if got := rt.self.bucketIndex(rt.randomID(idx)); got != idx {
+			t.Errorf("random ID for bucket %d falls in %d", idx, got)
+		}
+	}
+}
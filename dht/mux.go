@@ -0,0 +1,629 @@
+package dht
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Connections to a peer are kept open and shared by every RPC to it.
+// A pooled connection starts with muxPreface from the dialer, echoed
+// back by the listener; after that each message travels in a frame
+//
+//	type (1) | request ID (4) | length (4) | body
+//
+// and the messages of one RPC share a request ID, so any number of
+// RPCs can be in flight at once. A connection that starts with a plain
+// message instead is served the old way, one RPC per connection.
+//
+// The top bit of the type marks a body in the binary encoding of
+// wire.go. It is only sent to peers whose pong advertised FeatureBinarySeq,
+// and a server answers each RPC in the encoding it was asked in.
+
+// muxPreface can't be mistaken for a message — 0xff is no message type
+var muxPreface = []byte{0xff, 'M', 'U', 'X', 1}
+
+// frameBinary is the type bit of a binary body
+const frameBinary = 0x80
+
+// pool tuning
+const (
+	// poolMaxConns bounds the open outgoing connections
+	poolMaxConns = 64
+	// poolIdleTimeout closes outgoing connections nothing used for this long
+	poolIdleTimeout = 2 * time.Minute
+	// muxServerIdle closes incoming connections — longer than
+	// poolIdleTimeout so the dialing side normally closes first
+	muxServerIdle = 3 * time.Minute
+	// muxMaxInflight bounds the requests served at once per connection
+	muxMaxInflight = 32
+	// legacyRetry is how long a peer that can't multiplex gets one
+	// connection per RPC before we try again
+	legacyRetry = 10 * time.Minute
+	// featuresTTL is how long a pong's feature bits are trusted — after
+	// that RPCs go as JSON until the peer is pinged again
+	featuresTTL = 24 * time.Hour
+)
+
+// rpcConn carries the messages of one RPC
+type rpcConn interface {
+	Send(typ byte, body interface{}) error
+	Receive() (Message, error)
+	RemoteAddr() net.Addr
+	Close() error
+}
+
+// ── one connection per RPC ───────────────────────────────────────────────────
+
+// streamConn is an RPC with a connection to itself
+type streamConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func newStreamConn(conn net.Conn, r io.Reader) *streamConn {
+	if r == nil {
+		r = conn
+	}
+	return &streamConn{Conn: conn, r: r}
+}
+
+// Send always uses JSON — a peer on a connection of its own is a v1 one
+func (c *streamConn) Send(typ byte, body interface{}) error {
+	msg, err := newMessage(typ, body, false)
+	if err != nil {
+		return err
+	}
+	return writeMessage(c.Conn, msg)
+}
+
+func (c *streamConn) Receive() (Message, error) {
+	c.Conn.SetReadDeadline(time.Now().Add(readTimeout))
+	return readMessage(c.r)
+}
+
+// ── framing ──────────────────────────────────────────────────────────────────
+
+func writeFrame(w io.Writer, id uint32, msg Message) error {
+	var header [9]byte
+	header[0] = msg.Type
+	if msg.Binary {
+		header[0] |= frameBinary
+	}
+	binary.BigEndian.PutUint32(header[1:5], id)
+	binary.BigEndian.PutUint32(header[5:9], uint32(len(msg.Body)))
+
+	// one write per frame — frames from concurrent RPCs must not interleave
+	_, err := w.Write(append(header[:], msg.Body...))
+	return err
+}
+
+func readFrame(r io.Reader) (uint32, Message, error) {
+	var header [9]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, Message{}, err
+	}
+	id := binary.BigEndian.Uint32(header[1:5])
+	bodyLen := binary.BigEndian.Uint32(header[5:9])
+	if bodyLen > maxMessageSize {
+		return 0, Message{}, fmt.Errorf("message too large: %d bytes", bodyLen)
+	}
+
+	body := make([]byte, bodyLen)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, Message{}, fmt.Errorf("failed to read message body: %w", err)
+	}
+	return id, Message{
+		Type:   header[0] &^ frameBinary,
+		Body:   body,
+		Binary: header[0]&frameBinary != 0,
+	}, nil
+}
+
+// ── multiplexed connection ───────────────────────────────────────────────────
+
+// muxConn is a connection shared by many RPCs, each an exchange
+type muxConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	// serve handles a frame with a new request ID, nil on the dialing
+	// side where only replies arrive
+	serve func(rpcConn, Message)
+
+	wmu sync.Mutex // one frame at a time
+
+	mu        sync.Mutex
+	exchanges map[uint32]*exchange
+	nextID    uint32
+	lastUsed  time.Time
+	inflight  chan struct{}
+	handlers  sync.WaitGroup
+
+	done     chan struct{}
+	doneOnce sync.Once
+}
+
+func newMuxConn(conn net.Conn, r *bufio.Reader, serve func(rpcConn, Message)) *muxConn {
+	return &muxConn{
+		conn:      conn,
+		r:         r,
+		serve:     serve,
+		exchanges: make(map[uint32]*exchange),
+		lastUsed:  time.Now(),
+		inflight:  make(chan struct{}, muxMaxInflight),
+		done:      make(chan struct{}),
+	}
+}
+
+// readLoop routes frames to their exchange until the connection fails
+func (mc *muxConn) readLoop() {
+	defer mc.Close()
+	for {
+		if mc.serve != nil {
+			mc.conn.SetReadDeadline(time.Now().Add(muxServerIdle))
+		}
+		id, msg, err := readFrame(mc.r)
+		if err != nil {
+			return
+		}
+
+		mc.mu.Lock()
+		ex, ok := mc.exchanges[id]
+		if !ok && mc.serve != nil {
+			select {
+			case mc.inflight <- struct{}{}:
+				ex = mc.register(id, msg.Binary)
+				mc.mu.Unlock()
+				mc.handlers.Add(1)
+				go func() {
+					defer mc.handlers.Done()
+					defer func() { <-mc.inflight }()
+					defer ex.Close()
+					mc.serve(ex, msg)
+				}()
+				continue
+			default:
+				// too busy — the dialer times out and may retry
+			}
+		}
+		mc.mu.Unlock()
+
+		if ok {
+			select {
+			case ex.replies <- msg:
+			default:
+				// the exchange isn't reading — drop rather than stall the rest
+			}
+		}
+	}
+}
+
+// register adds an exchange, mc.mu held
+func (mc *muxConn) register(id uint32, compact bool) *exchange {
+	ex := &exchange{mc: mc, id: id, compact: compact, replies: make(chan Message, 4)}
+	mc.exchanges[id] = ex
+	mc.lastUsed = time.Now()
+	return ex
+}
+
+// open starts an outgoing exchange, sending binary bodies if compact
+func (mc *muxConn) open(compact bool) (*exchange, error) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	select {
+	case <-mc.done:
+		return nil, net.ErrClosed
+	default:
+	}
+	mc.nextID++
+	return mc.register(mc.nextID, compact), nil
+}
+
+func (mc *muxConn) release(id uint32) {
+	mc.mu.Lock()
+	delete(mc.exchanges, id)
+	mc.lastUsed = time.Now()
+	mc.mu.Unlock()
+}
+
+// idleSince reports when the connection was last used, zero if in use
+func (mc *muxConn) idleSince() time.Time {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	if len(mc.exchanges) > 0 {
+		return time.Time{}
+	}
+	return mc.lastUsed
+}
+
+func (mc *muxConn) alive() bool {
+	select {
+	case <-mc.done:
+		return false
+	default:
+		return true
+	}
+}
+
+func (mc *muxConn) Close() error {
+	mc.doneOnce.Do(func() {
+		close(mc.done)
+		mc.conn.Close()
+	})
+	return nil
+}
+
+// exchange is one RPC on a muxConn
+type exchange struct {
+	mc      *muxConn
+	id      uint32
+	compact bool
+	replies chan Message
+}
+
+// Send encodes body as the exchange was opened for — pings excepted,
+// so a peer that went back to an older version can still be asked
+// what it speaks
+func (ex *exchange) Send(typ byte, body interface{}) error {
+	msg, err := newMessage(typ, body, ex.compact && typ != MsgPing)
+	if err != nil {
+		return err
+	}
+
+	ex.mc.wmu.Lock()
+	defer ex.mc.wmu.Unlock()
+	ex.mc.conn.SetWriteDeadline(time.Now().Add(readTimeout))
+	if err := writeFrame(ex.mc.conn, ex.id, msg); err != nil {
+		ex.mc.Close()
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	return nil
+}
+
+func (ex *exchange) Receive() (Message, error) {
+	timer := time.NewTimer(readTimeout)
+	defer timer.Stop()
+	select {
+	case msg := <-ex.replies:
+		return msg, nil
+	case <-ex.mc.done:
+		return Message{}, fmt.Errorf("connection closed")
+	case <-timer.C:
+		// a peer that stops answering one RPC is likely gone —
+		// don't leave the others waiting for it too
+		if ex.mc.serve == nil {
+			ex.mc.Close()
+		}
+		return Message{}, fmt.Errorf("timed out waiting for reply")
+	}
+}
+
+func (ex *exchange) RemoteAddr() net.Addr {
+	return ex.mc.conn.RemoteAddr()
+}
+
+func (ex *exchange) Close() error {
+	ex.mc.release(ex.id)
+	return nil
+}
+
+// ── serving ──────────────────────────────────────────────────────────────────
+
+// handleConnection serves a multiplexed connection, or the single RPC
+// an old-style connection carries
+func (d *DHT) handleConnection(conn net.Conn) {
+	defer d.wg.Done()
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(readTimeout))
+	br := bufio.NewReader(conn)
+	first, err := br.Peek(len(muxPreface))
+	if err != nil && len(first) == 0 {
+		return
+	}
+
+	if !bytes.Equal(first, muxPreface) {
+		msg, err := readMessage(br)
+		if err != nil {
+			return
+		}
+		d.handleRequest(newStreamConn(conn, br), msg)
+		return
+	}
+
+	br.Discard(len(muxPreface))
+	if _, err := conn.Write(muxPreface); err != nil {
+		return
+	}
+
+	mc := newMuxConn(conn, br, d.handleRequest)
+	go func() {
+		select {
+		case <-d.done:
+			mc.Close()
+		case <-mc.done:
+		}
+	}()
+	mc.readLoop()
+	mc.handlers.Wait()
+}
+
+// ── dialing ──────────────────────────────────────────────────────────────────
+
+// connPool holds one multiplexed connection per peer address
+type connPool struct {
+	transport Transport
+
+	// udp carries RPCs to peers that take datagrams, nil when off
+	udp *udpMux
+
+	mu       sync.Mutex
+	conns    map[string]*muxConn
+	legacy   map[string]time.Time // addr → when it failed to multiplex
+	noUDP    map[string]time.Time // addr → when it left UDP unanswered
+	features map[string]peerFeatures
+}
+
+// peerFeatures is what a peer's last pong advertised
+type peerFeatures struct {
+	bits uint64
+	seen time.Time
+}
+
+func newConnPool(t Transport) *connPool {
+	return &connPool{
+		transport: t,
+		conns:     make(map[string]*muxConn),
+		legacy:    make(map[string]time.Time),
+		noUDP:     make(map[string]time.Time),
+		features:  make(map[string]peerFeatures),
+	}
+}
+
+// setFeatures records what the peer at addr speaks, from its ping or pong
+func (p *connPool) setFeatures(addr string, version int, bits uint64) {
+	if version < 2 {
+		bits = 0
+	}
+	p.mu.Lock()
+	p.features[addr] = peerFeatures{bits: bits, seen: time.Now()}
+	p.mu.Unlock()
+}
+
+// compactLocked reports whether addr takes binary bodies, p.mu held
+func (p *connPool) compactLocked(addr string) bool {
+	f, ok := p.features[addr]
+	return ok && f.bits&FeatureBinarySeq != 0 && time.Since(f.seen) < featuresTTL
+}
+
+// open starts an RPC to addr on its pooled connection, dialing one if
+// needed. Peers that don't multiplex get a connection of their own.
+func (p *connPool) open(addr string) (rpcConn, error) {
+	p.mu.Lock()
+	compact := p.compactLocked(addr)
+	if mc := p.conns[addr]; mc != nil && mc.alive() {
+		p.mu.Unlock()
+		if ex, err := mc.open(compact); err == nil {
+			return ex, nil
+		}
+		p.mu.Lock()
+	}
+	failed, isLegacy := p.legacy[addr]
+	p.mu.Unlock()
+
+	if isLegacy && time.Since(failed) < legacyRetry {
+		return p.dialStream(addr)
+	}
+
+	mc, err := p.dial(addr)
+	if err == errNoMux {
+		p.mu.Lock()
+		p.legacy[addr] = time.Now()
+		p.mu.Unlock()
+		return p.dialStream(addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	delete(p.legacy, addr)
+	if existing := p.conns[addr]; existing != nil && existing.alive() {
+		// another RPC dialed it meanwhile — use theirs
+		p.mu.Unlock()
+		mc.Close()
+		return existing.open(compact)
+	}
+	if len(p.conns) >= poolMaxConns && !p.evictLocked() {
+		// every pooled connection is busy — this one is used once
+		p.mu.Unlock()
+		ex, err := mc.open(compact)
+		if err != nil {
+			return nil, err
+		}
+		return &unpooled{exchange: ex}, nil
+	}
+	p.conns[addr] = mc
+	p.mu.Unlock()
+	return mc.open(compact)
+}
+
+var errNoMux = fmt.Errorf("peer does not multiplex")
+
+// openUDP starts an RPC to addr over UDP, nil if we or the peer don't
+// take datagrams or it recently left them unanswered
+func (p *connPool) openUDP(addr string) rpcConn {
+	if p.udp == nil {
+		return nil
+	}
+	p.mu.Lock()
+	f, ok := p.features[addr]
+	failed, silent := p.noUDP[addr]
+	compact := p.compactLocked(addr)
+	p.mu.Unlock()
+	if !ok || f.bits&FeatureUDP == 0 || time.Since(f.seen) > featuresTTL {
+		return nil
+	}
+	if silent && time.Since(failed) < legacyRetry {
+		return nil
+	}
+
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil
+	}
+	ex, err := p.udp.open(udpAddr, compact)
+	if err != nil {
+		return nil
+	}
+	return ex
+}
+
+// udpFailed sends addr's RPCs over TCP for a while
+func (p *connPool) udpFailed(addr string) {
+	p.mu.Lock()
+	p.noUDP[addr] = time.Now()
+	p.mu.Unlock()
+}
+
+// dial connects and negotiates multiplexing
+func (p *connPool) dial(addr string) (*muxConn, error) {
+	conn, err := p.transport.Dial(addr, readTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+	conn.SetDeadline(time.Now().Add(readTimeout))
+	if _, err := conn.Write(muxPreface); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+	br := bufio.NewReader(conn)
+	reply := make([]byte, len(muxPreface))
+	if _, err := io.ReadFull(br, reply); err != nil || !bytes.Equal(reply, muxPreface) {
+		// an old node reads the preface as an oversized message and hangs up
+		conn.Close()
+		return nil, errNoMux
+	}
+	conn.SetDeadline(time.Time{})
+
+	mc := newMuxConn(conn, br, nil)
+	go mc.readLoop()
+	return mc, nil
+}
+
+func (p *connPool) dialStream(addr string) (rpcConn, error) {
+	conn, err := p.transport.Dial(addr, readTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+	return newStreamConn(conn, nil), nil
+}
+
+// evictLocked closes the longest idle connection, p.mu held
+// returns false if all of them are in use
+func (p *connPool) evictLocked() bool {
+	var oldest string
+	var oldestAt time.Time
+	for addr, mc := range p.conns {
+		since := mc.idleSince()
+		if since.IsZero() {
+			continue
+		}
+		if oldest == "" || since.Before(oldestAt) {
+			oldest, oldestAt = addr, since
+		}
+	}
+	if oldest == "" {
+		return false
+	}
+	p.conns[oldest].Close()
+	delete(p.conns, oldest)
+	return true
+}
+
+// reap closes connections idle longer than poolIdleTimeout and forgets
+// dead ones
+func (p *connPool) reap() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for addr, mc := range p.conns {
+		since := mc.idleSince()
+		if !mc.alive() || (!since.IsZero() && time.Since(since) > poolIdleTimeout) {
+			mc.Close()
+			delete(p.conns, addr)
+		}
+	}
+	for addr, failed := range p.legacy {
+		if time.Since(failed) > legacyRetry {
+			delete(p.legacy, addr)
+		}
+	}
+	for addr, failed := range p.noUDP {
+		if time.Since(failed) > legacyRetry {
+			delete(p.noUDP, addr)
+		}
+	}
+	for addr, f := range p.features {
+		if time.Since(f.seen) > featuresTTL {
+			delete(p.features, addr)
+		}
+	}
+}
+
+func (p *connPool) loop(done <-chan struct{}) {
+	ticker := time.NewTicker(poolIdleTimeout / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.reap()
+		case <-done:
+			p.closeAll()
+			return
+		}
+	}
+}
+
+func (p *connPool) closeAll() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for addr, mc := range p.conns {
+		mc.Close()
+		delete(p.conns, addr)
+	}
+}
+
+// Size returns the number of pooled connections
+func (p *connPool) Size() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.conns)
+}
+
+// BinaryPeers returns the number of peers we send binary bodies to
+func (p *connPool) BinaryPeers() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := 0
+	for addr := range p.features {
+		if p.compactLocked(addr) {
+			n++
+		}
+	}
+	return n
+}
+
+// unpooled is an exchange on a connection of its own, closed with it
+type unpooled struct {
+	*exchange
+}
+
+func (u *unpooled) Close() error {
+	u.exchange.Close()
+	return u.mc.Close()
+}
@@ -0,0 +1,78 @@
+package dht
+
+import (
+	"crypto/ed25519"
+	"net"
+	"testing"
+	"time"
+)
+
+// benchRTT is the round trip simulated between benchmark nodes, about
+// what a few Yggdrasil hops cost
+const benchRTT = 20 * time.Millisecond
+
+// slowTransport is TCP with benchRTT of latency: a dial costs a round
+// trip, and every write half of one
+type slowTransport struct {
+	TCPTransport
+}
+
+func (t slowTransport) Dial(addr string, timeout time.Duration) (net.Conn, error) {
+	time.Sleep(benchRTT)
+	conn, err := t.TCPTransport.Dial(addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return slowConn{conn}, nil
+}
+
+type slowConn struct {
+	net.Conn
+}
+
+func (c slowConn) Write(b []byte) (int, error) {
+	time.Sleep(benchRTT / 2)
+	return c.Conn.Write(b)
+}
+
+// BenchmarkLookup compares lookup latency on pooled multiplexed
+// connections with a connection per RPC, as before pooling
+func BenchmarkLookup(b *testing.B) {
+	b.Run("pooled", func(b *testing.B) { benchmarkLookup(b, false) })
+	b.Run("per-rpc", func(b *testing.B) { benchmarkLookup(b, true) })
+}
+
+func benchmarkLookup(b *testing.B, perRPC bool) {
+	nodes := startTestNodes(b, 30, func(d *DHT) {
+		d.SetTransport(slowTransport{})
+	})
+	defer func() {
+		for _, d := range nodes {
+			d.Stop()
+		}
+	}()
+	if perRPC {
+		// peers that don't multiplex get a connection per RPC
+		for _, d := range nodes {
+			for _, other := range nodes {
+				d.pool.legacy[loopbackContact(other).Addr()] = time.Now()
+			}
+		}
+	}
+
+	lookup := func(i int) {
+		pub, _, _ := ed25519.GenerateKey(nil)
+		nodes[i%len(nodes)].LookupNode(NodeIDFromPublicKey(pub))
+	}
+	if !perRPC {
+		// a running node has its busy connections open already
+		for i := 0; i < 3*len(nodes); i++ {
+			lookup(i)
+		}
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		lookup(i)
+	}
+}
@@ -9,9 +9,6 @@ import (
 	"time"
 )
 
-// peersFile is where we save known peers between sessions
-const peersFile = "peers.json"
-
 // savedPeer is the serializable form of a Contact
 type savedPeer struct {
 	ID   string `json:"id"`
@@ -19,8 +16,17 @@ type savedPeer struct {
 	Port int    `json:"port"`
 }
 
+// SetPeersFile sets where known peers are saved between sessions
+// without one, peers are neither saved nor restored
+func (d *DHT) SetPeersFile(path string) {
+	d.peersFile = path
+}
+
 // SavePeers writes all known contacts to disk
 func (d *DHT) SavePeers() error {
+	if d.peersFile == "" {
+		return nil
+	}
 	contacts := d.table.All()
 	if len(contacts) == 0 {
 		return nil
@@ -40,7 +46,7 @@ func (d *DHT) SavePeers() error {
 		return fmt.Errorf("failed to encode peers: %w", err)
 	}
 
-	if err := os.WriteFile(peersFile, data, 0644); err != nil {
+	if err := os.WriteFile(d.peersFile, data, 0644); err != nil {
 		return fmt.Errorf("failed to save peers: %w", err)
 	}
 
@@ -50,7 +56,10 @@ func (d *DHT) SavePeers() error {
 
 // LoadPeers reads saved peers from disk and pings each one
 func (d *DHT) LoadPeers() {
-	data, err := os.ReadFile(peersFile)
+	if d.peersFile == "" {
+		return
+	}
+	data, err := os.ReadFile(d.peersFile)
 	if err != nil {
 		return
 	}
@@ -94,6 +103,10 @@ func (d *DHT) BootstrapDHT() int {
 	if d.table.Size() > 0 {
 		return d.table.Size()
 	}
+	// the well-known nodes are all on the public network
+	if d.network != "" {
+		return 0
+	}
 
 	// no saved peers — try well-known bootstrap nodes
 	contacted := 0
@@ -137,6 +150,10 @@ type PeerInfo struct {
 	Port    int
 	Latency time.Duration
 	Alive   bool
+	// from the routing table, as of before this ping
+	LastSeen time.Time
+	RTT      time.Duration
+	Failures int
 }
 
 // PingAllPeers pings all known peers and returns their status
@@ -157,15 +174,23 @@ func (d *DHT) PingAllPeers() []PeerInfo {
 				Address: net.ParseIP(d.address),
 				Port:    d.port,
 			}
-			_, err := SendPing(addr, self)
+			_, err := d.SendPing(addr, self)
 			latency := time.Since(start)
+			if err == nil {
+				d.table.Seen(contact.ID, latency)
+			} else {
+				d.table.Failed(contact.ID)
+			}
 
 			results[idx] = PeerInfo{
-				ID:      contact.ID.String()[:16],
-				Addr:    contact.Address.String(),
-				Port:    contact.Port,
-				Latency: latency,
-				Alive:   err == nil,
+				ID:       contact.ID.String()[:16],
+				Addr:     contact.Address.String(),
+				Port:     contact.Port,
+				Latency:  latency,
+				Alive:    err == nil,
+				LastSeen: contact.LastSeen,
+				RTT:      contact.RTT,
+				Failures: contact.Failures,
 			}
 		}(i, c)
 	}
@@ -13,8 +13,13 @@ type RegisterOptions struct {
 	Address    string
 	Services   []string
 	GroupKey   string
+	Network    string // "" for the public network
 	PrivateKey ed25519.PrivateKey
 	TTL        time.Duration // optional — 0 means use default RecordTTL
+	Succession *Succession   // optional — proves PrivateKey inherited the name
+	// optional — numbers the record; nil numbers it by the clock, which
+	// only rises across records signed a second or more apart
+	Counter *SequenceCounter
 }
 
 func CreateRecord(opts RegisterOptions) (Record, error) {
@@ -36,13 +41,27 @@ func CreateRecord(opts RegisterOptions) (Record, error) {
 		ttl = RecordTTL
 	}
 
+	sequence := uint64(time.Now().Unix())
+	if opts.Counter != nil {
+		var err error
+		if sequence, err = opts.Counter.Next(); err != nil {
+			return Record{}, err
+		}
+	}
+
 	record := Record{
 		Name:      opts.Name,
 		Address:   opts.Address,
 		PublicKey: hex.EncodeToString(pubKey),
 		Services:  opts.Services,
 		GroupKey:  opts.GroupKey,
+		Network:   opts.Network,
 		Expires:   time.Now().Add(ttl).Unix(),
+		Sequence:  sequence,
+	}
+
+	if opts.Succession != nil && opts.Succession.NewKey == record.PublicKey {
+		record.Succession = opts.Succession
 	}
 
 	payload := record.SigningPayload()
@@ -0,0 +1,91 @@
+package dht
+
+import (
+	"sync"
+	"time"
+)
+
+// Records live on the K nodes closest to their ID, and those change as
+// nodes come and go. Every node holding a record sends it to the
+// current K closest once an hour, unless the record reached it in that
+// hour — then another holder has just done so. And a node that joins
+// our table among the K closest to a record we're responsible for gets
+// a copy straight away, so names outlive the nodes they were first
+// stored on.
+
+const republishInterval = time.Hour
+
+func (d *DHT) republishLoop() {
+	ticker := time.NewTicker(republishInterval / 6)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			d.republish()
+		case <-d.done:
+			return
+		}
+	}
+}
+
+// republish stores each record that's due at its current closest nodes
+func (d *DHT) republish() {
+	for _, r := range d.store.dueForRepublish(republishInterval) {
+		select {
+		case <-d.done:
+			return
+		default:
+		}
+		d.storeAtClosest(r)
+		d.store.republished(r.Name)
+	}
+}
+
+// storeAtClosest looks up the K nodes closest to r and stores r on them,
+// returning how many took it
+func (d *DHT) storeAtClosest(r Record) int {
+	closest := d.LookupNode(RecordID(r.Name))
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	stored := 0
+	for _, contact := range closest {
+		wg.Add(1)
+		go func(c Contact) {
+			defer wg.Done()
+			if d.SendStore(c.Addr(), r) == nil {
+				mu.Lock()
+				stored++
+				mu.Unlock()
+			}
+		}(contact)
+	}
+	wg.Wait()
+	return stored
+}
+
+// handoff gives a node that just entered our table the records it is
+// now among the K closest to, for those we're among the K closest to
+// as well
+func (d *DHT) handoff(c Contact) {
+	for _, r := range d.store.All() {
+		target := RecordID(r.Name)
+		closest := d.table.Closest(target, K)
+
+		newcomer := false
+		for _, cc := range closest {
+			if cc.ID == c.ID {
+				newcomer = true
+				break
+			}
+		}
+		if !newcomer {
+			continue
+		}
+		// with a full list, we must beat its farthest to be responsible
+		if len(closest) == K && closest[K-1].ID.Less(d.table.self, target) {
+			continue
+		}
+		d.SendStore(c.Addr(), r)
+	}
+}
@@ -0,0 +1,94 @@
+package dht
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestHandoffToNewcomer(t *testing.T) {
+	nodes := startTestNodes(t, 1, nil)
+	holder := nodes[0]
+	defer holder.Stop()
+	r, _ := testRecord(t, "alice")
+	if err := holder.Announce(r); err != nil {
+		t.Fatal(err)
+	}
+
+	newcomer := newTestNode(t)
+	if err := newcomer.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer newcomer.Stop()
+	meet(holder, newcomer)
+
+	waitFor(t, "handoff", func() bool {
+		_, ok := newcomer.store.GetPublic("alice")
+		return ok
+	})
+}
+
+// TestRecordsSurviveChurn replaces half the nodes each round, several
+// times over, so none of the nodes a record was first stored on is left
+// at the end. Handoff to the nodes that join and the hourly republish
+// keep every record findable.
+func TestRecordsSurviveChurn(t *testing.T) {
+	if testing.Short() {
+		t.Skip("churn simulation in -short mode")
+	}
+	const nodes, records, rounds = 30, 10, 4
+
+	live := startTestNodes(t, nodes, nil)
+	defer func() {
+		for _, d := range live {
+			d.Stop()
+		}
+	}()
+	for i := 0; i < records; i++ {
+		r, _ := testRecord(t, fmt.Sprintf("host%d", i))
+		if err := live[i%nodes].Announce(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for round := 0; round < rounds; round++ {
+		for _, d := range live[:nodes/2] {
+			d.Stop()
+		}
+		live = live[nodes/2:]
+
+		joined := startTestNodes(t, nodes/2, nil)
+		for _, d := range live {
+			for _, j := range joined {
+				meet(d, j)
+			}
+		}
+		live = append(live, joined...)
+		// let the handoffs to the newcomers land
+		time.Sleep(200 * time.Millisecond)
+
+		// an hour passes
+		for _, d := range live {
+			d.store.mu.Lock()
+			for name := range d.store.stored {
+				d.store.stored[name] = time.Now().Add(-2 * republishInterval)
+			}
+			d.store.mu.Unlock()
+		}
+		for _, d := range live {
+			d.republish()
+		}
+	}
+
+	asker := live[len(live)-1]
+	for i := 0; i < records; i++ {
+		name := fmt.Sprintf("host%d", i)
+		r, err := asker.LookupValue(name, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r == nil {
+			t.Errorf("%s lost to churn", name)
+		}
+	}
+}
@@ -7,10 +7,14 @@ import (
 	"net"
 	"sort"
 	"sync"
+	"time"
 )
 
 const K = 20
 
+// replacementSize bounds each bucket's replacement cache
+const replacementSize = K
+
 type NodeID [32]byte
 
 func NodeIDFromPublicKey(pub ed25519.PublicKey) NodeID {
@@ -72,16 +76,38 @@ type Contact struct {
 	ID      NodeID
 	Address net.IP
 	Port    int
+	// Network the contact said it belongs to, "" for the public one
+	Network string
+
+	// kept up by the table: when the contact last answered, its smoothed
+	// round trip time and how many RPCs to it failed since
+	LastSeen time.Time
+	RTT      time.Duration
+	Failures int
 }
 
 func (c Contact) Addr() string {
 	return fmt.Sprintf("[%s]:%d", c.Address.String(), c.Port)
 }
 
+// Pinger checks whether a contact still answers, nil meaning it does
+type Pinger func(c Contact) error
+
 type RoutingTable struct {
 	self    NodeID
+	network string
 	buckets [256][]Contact
-	mu      sync.RWMutex
+	// replacements are contacts seen while their bucket was full, most
+	// recently seen last, waiting for a slot
+	replacements [256][]Contact
+	// pinging marks buckets whose oldest contact is being checked
+	pinging [256]bool
+	pinger  Pinger
+	// onNew hears of each contact entering a bucket
+	onNew func(Contact)
+	// lookedUp is when a lookup last targeted each bucket's range
+	lookedUp [256]time.Time
+	mu       sync.RWMutex
 }
 
 func NewRoutingTable(self NodeID) *RoutingTable {
@@ -90,8 +116,26 @@ func NewRoutingTable(self NodeID) *RoutingTable {
 	}
 }
 
+// SetPinger sets how a full bucket's least recently seen contact is
+// checked before a newcomer may take its place. Without one newcomers
+// only wait in the replacement cache. Must be called before Add.
+func (rt *RoutingTable) SetPinger(p Pinger) {
+	rt.pinger = p
+}
+
+// SetOnNew sets fn to be called, in a goroutine of its own, for each
+// contact that enters a bucket. Must be called before Add.
+func (rt *RoutingTable) SetOnNew(fn func(Contact)) {
+	rt.onNew = fn
+}
+
+// Add inserts or refreshes c, most recently seen last. If c's bucket is
+// full, c waits in the bucket's replacement cache while the least
+// recently seen contact is pinged, and takes its slot if it doesn't
+// answer. Contacts from another network are refused so separate
+// deployments never share a table.
 func (rt *RoutingTable) Add(c Contact) {
-	if c.ID == rt.self {
+	if c.ID == rt.self || c.Network != rt.network {
 		return
 	}
 
@@ -103,9 +147,15 @@ func (rt *RoutingTable) Add(c Contact) {
 	rt.mu.Lock()
 	defer rt.mu.Unlock()
 
+	c.LastSeen = time.Now()
+	c.Failures = 0
+
 	bucket := rt.buckets[idx]
 	for i, existing := range bucket {
 		if existing.ID == c.ID {
+			if c.RTT == 0 {
+				c.RTT = existing.RTT
+			}
 			rt.buckets[idx] = append(
 				append(bucket[:i], bucket[i+1:]...),
 				c,
@@ -116,11 +166,109 @@ func (rt *RoutingTable) Add(c Contact) {
 
 	if len(bucket) < K {
 		rt.buckets[idx] = append(bucket, c)
+		rt.dropReplacementLocked(idx, c.ID)
+		rt.notifyLocked(c)
+		return
+	}
+
+	rt.addReplacementLocked(idx, c)
+	if rt.pinger == nil || rt.pinging[idx] {
 		return
 	}
+	rt.pinging[idx] = true
+	go rt.checkOldest(idx, bucket[0])
 }
 
-func (rt *RoutingTable) Remove(id NodeID) {
+// checkOldest pings a full bucket's least recently seen contact. One
+// that answers counts as just seen; one that doesn't is evicted for the
+// freshest replacement.
+func (rt *RoutingTable) checkOldest(idx int, oldest Contact) {
+	err := rt.pinger(oldest)
+
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	rt.pinging[idx] = false
+
+	bucket := rt.buckets[idx]
+	for i, c := range bucket {
+		if c.ID != oldest.ID {
+			continue
+		}
+		if err == nil {
+			c.LastSeen = time.Now()
+			c.Failures = 0
+			rt.buckets[idx] = append(append(bucket[:i], bucket[i+1:]...), c)
+		} else {
+			rt.removeLocked(idx, i)
+		}
+		return
+	}
+}
+
+// addReplacementLocked puts c at the fresh end of idx's replacement
+// cache, dropping the stalest entry if it's full, rt.mu held
+func (rt *RoutingTable) addReplacementLocked(idx int, c Contact) {
+	rt.dropReplacementLocked(idx, c.ID)
+	repl := rt.replacements[idx]
+	if len(repl) >= replacementSize {
+		repl = repl[1:]
+	}
+	rt.replacements[idx] = append(repl, c)
+}
+
+func (rt *RoutingTable) dropReplacementLocked(idx int, id NodeID) {
+	repl := rt.replacements[idx]
+	for i, c := range repl {
+		if c.ID == id {
+			rt.replacements[idx] = append(repl[:i], repl[i+1:]...)
+			return
+		}
+	}
+}
+
+// removeLocked takes the i'th contact out of bucket idx and promotes the
+// freshest replacement into the freed slot, rt.mu held
+func (rt *RoutingTable) removeLocked(idx, i int) {
+	bucket := rt.buckets[idx]
+	rt.buckets[idx] = append(bucket[:i], bucket[i+1:]...)
+
+	repl := rt.replacements[idx]
+	if len(repl) == 0 {
+		return
+	}
+	promoted := repl[len(repl)-1]
+	rt.buckets[idx] = append(rt.buckets[idx], promoted)
+	rt.replacements[idx] = repl[:len(repl)-1]
+	rt.notifyLocked(promoted)
+}
+
+func (rt *RoutingTable) notifyLocked(c Contact) {
+	if rt.onNew != nil {
+		go rt.onNew(c)
+	}
+}
+
+// Contains reports whether id is in the table
+func (rt *RoutingTable) Contains(id NodeID) bool {
+	idx := rt.self.bucketIndex(id)
+	if idx < 0 {
+		return false
+	}
+
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+
+	for _, c := range rt.buckets[idx] {
+		if c.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Seen records that id answered an RPC in rtt, making it the most
+// recently seen contact of its bucket
+func (rt *RoutingTable) Seen(id NodeID, rtt time.Duration) {
 	idx := rt.self.bucketIndex(id)
 	if idx < 0 {
 		return
@@ -131,11 +279,63 @@ func (rt *RoutingTable) Remove(id NodeID) {
 
 	bucket := rt.buckets[idx]
 	for i, c := range bucket {
+		if c.ID != id {
+			continue
+		}
+		c.LastSeen = time.Now()
+		c.Failures = 0
+		if c.RTT == 0 {
+			c.RTT = rtt
+		} else {
+			c.RTT = (7*c.RTT + rtt) / 8
+		}
+		rt.buckets[idx] = append(append(bucket[:i], bucket[i+1:]...), c)
+		return
+	}
+}
+
+// Failed records that an RPC to id failed. After maxFailures in a row
+// the contact is dropped for the freshest replacement.
+func (rt *RoutingTable) Failed(id NodeID) {
+	idx := rt.self.bucketIndex(id)
+	if idx < 0 {
+		return
+	}
+
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	for i := range rt.buckets[idx] {
+		c := &rt.buckets[idx][i]
+		if c.ID != id {
+			continue
+		}
+		c.Failures++
+		if c.Failures >= maxFailures {
+			rt.removeLocked(idx, i)
+		}
+		return
+	}
+}
+
+// Remove drops id from the table or its replacement cache. A slot it
+// frees goes to the freshest replacement.
+func (rt *RoutingTable) Remove(id NodeID) {
+	idx := rt.self.bucketIndex(id)
+	if idx < 0 {
+		return
+	}
+
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	for i, c := range rt.buckets[idx] {
 		if c.ID == id {
-			rt.buckets[idx] = append(bucket[:i], bucket[i+1:]...)
+			rt.removeLocked(idx, i)
 			return
 		}
 	}
+	rt.dropReplacementLocked(idx, id)
 }
 
 func (rt *RoutingTable) Closest(target NodeID, count int) []Contact {
@@ -0,0 +1,138 @@
+package dht
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// fullBucket returns a table whose bucket 0 holds K contacts, the first
+// of them least recently seen, and pings through pinger
+func fullBucket(t *testing.T, pinger Pinger) (*RoutingTable, []Contact) {
+	t.Helper()
+	rt := NewRoutingTable(NodeID{})
+	rt.SetPinger(pinger)
+	var contacts []Contact
+	for i := 0; i < K; i++ {
+		c := bucketContact(i)
+		rt.Add(c)
+		contacts = append(contacts, c)
+	}
+	return rt, contacts
+}
+
+// bucketContact is the i'th contact in bucket 0 of a table for NodeID{}
+func bucketContact(i int) Contact {
+	return Contact{ID: NodeID{0x80, byte(i)}, Address: net.ParseIP("200::1"), Port: 9000 + i}
+}
+
+// waitFor polls cond until it holds, failing the test after a second
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestFullBucketEvictsUnresponsive(t *testing.T) {
+	pinged := make(chan Contact, 1)
+	rt, contacts := fullBucket(t, func(c Contact) error {
+		pinged <- c
+		return errors.New("no answer")
+	})
+	promoted := make(chan Contact, 1)
+	rt.SetOnNew(func(c Contact) { promoted <- c })
+
+	newcomer := bucketContact(K)
+	rt.Add(newcomer)
+
+	if c := <-pinged; c.ID != contacts[0].ID {
+		t.Fatalf("pinged %s, want the least recently seen contact", c.ID)
+	}
+	waitFor(t, "eviction", func() bool { return !rt.Contains(contacts[0].ID) })
+	if !rt.Contains(newcomer.ID) {
+		t.Error("newcomer not promoted into the freed slot")
+	}
+	if c := <-promoted; c.ID != newcomer.ID {
+		t.Errorf("onNew heard of %s, want the newcomer", c.ID)
+	}
+	if rt.Size() != K {
+		t.Errorf("table holds %d contacts, want %d", rt.Size(), K)
+	}
+}
+
+func TestFullBucketKeepsResponsive(t *testing.T) {
+	pinged := make(chan Contact, 1)
+	rt, contacts := fullBucket(t, func(c Contact) error {
+		pinged <- c
+		return nil
+	})
+
+	newcomer := bucketContact(K)
+	rt.Add(newcomer)
+	<-pinged
+
+	// it answered, so it is now the most recently seen
+	waitFor(t, "refresh", func() bool {
+		rt.mu.RLock()
+		defer rt.mu.RUnlock()
+		bucket := rt.buckets[0]
+		return bucket[len(bucket)-1].ID == contacts[0].ID
+	})
+	if rt.Contains(newcomer.ID) {
+		t.Fatal("newcomer took the slot of a contact that answered")
+	}
+
+	// it waits in the replacement cache for the next free slot
+	rt.Remove(contacts[5].ID)
+	if !rt.Contains(newcomer.ID) {
+		t.Error("replacement not promoted when Remove freed a slot")
+	}
+}
+
+func TestFailedPromotesReplacement(t *testing.T) {
+	rt, contacts := fullBucket(t, nil)
+	newcomer := bucketContact(K)
+	rt.Add(newcomer)
+	if rt.Contains(newcomer.ID) {
+		t.Fatal("newcomer added to a full bucket")
+	}
+
+	for i := 0; i < maxFailures-1; i++ {
+		rt.Failed(contacts[3].ID)
+	}
+	if !rt.Contains(contacts[3].ID) {
+		t.Fatalf("contact dropped after %d failures, want %d", maxFailures-1, maxFailures)
+	}
+	rt.Failed(contacts[3].ID)
+	if rt.Contains(contacts[3].ID) || !rt.Contains(newcomer.ID) {
+		t.Error("failing contact not replaced by the cached newcomer")
+	}
+}
+
+func TestReplacementCacheBounded(t *testing.T) {
+	rt, _ := fullBucket(t, nil)
+	for i := 0; i < replacementSize+2; i++ {
+		rt.Add(bucketContact(K + i))
+	}
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+	repl := rt.replacements[0]
+	if len(repl) != replacementSize {
+		t.Fatalf("%d replacements cached, want %d", len(repl), replacementSize)
+	}
+	// the stalest went first
+	if repl[len(repl)-1].ID != bucketContact(K+replacementSize+1).ID {
+		t.Error("freshest replacement not kept last")
+	}
+	for _, c := range repl {
+		if c.ID == bucketContact(K).ID {
+			t.Error("stalest replacement kept")
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -19,30 +20,90 @@ const (
 	MsgFindValue
 	MsgFoundValue
 	MsgNotFound
+	MsgPingAck
+	MsgCacheStore
 )
 
 const readTimeout = 10 * time.Second
 
+// maxMessageSize bounds a message body
+const maxMessageSize = 1024 * 1024
+
+// ProtocolVersion is the DHT wire protocol version, advertised in NodeInfo
+// and in ping and pong. Version 2 adds the binary encoding in wire.go,
+// version 3 sequence numbers in records.
+const ProtocolVersion = 3
+
+// Message is one encoded message, Binary telling how Body is encoded
 type Message struct {
-	Type byte
-	Body json.RawMessage
+	Type   byte
+	Body   []byte
+	Binary bool
+}
+
+// newMessage encodes body, in the binary form if asked and the body has
+// one that round-trips, JSON otherwise
+func newMessage(typ byte, body interface{}, compact bool) (Message, error) {
+	if m, ok := body.(binaryMarshaler); ok && compact {
+		if data, err := marshalWire(m); err == nil {
+			return Message{Type: typ, Body: data, Binary: true}, nil
+		}
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return Message{}, fmt.Errorf("failed to encode message body: %w", err)
+	}
+	return Message{Type: typ, Body: data}, nil
+}
+
+// Decode decodes the body into v, whichever way it was encoded
+func (m Message) Decode(v interface{}) error {
+	if !m.Binary {
+		return json.Unmarshal(m.Body, v)
+	}
+	u, ok := v.(binaryUnmarshaler)
+	if !ok {
+		return fmt.Errorf("message type %d has no binary encoding", m.Type)
+	}
+	return unmarshalWire(m.Body, u)
 }
 
+// Network fields are left out on the public network, so nodes from
+// before network IDs existed still talk to it
+
 type PingBody struct {
 	SenderID   string `json:"sender_id"`
 	SenderAddr string `json:"sender_addr"`
 	SenderPort int    `json:"sender_port"`
+	Network    string `json:"network,omitempty"`
+	// Nonce is the challenge the pong must sign — see handshake.go
+	Nonce string `json:"nonce,omitempty"`
+	// Version and Features are left out by v1 nodes
+	Version  int    `json:"version,omitempty"`
+	Features uint64 `json:"features,omitempty"`
 }
 
 type PongBody struct {
 	SenderID   string `json:"sender_id"`
 	SenderAddr string `json:"sender_addr"`
 	SenderPort int    `json:"sender_port"`
+	Network    string `json:"network,omitempty"`
+	// Signature covers the ping's nonce, Nonce is our challenge back
+	Signature string `json:"signature,omitempty"`
+	Nonce     string `json:"nonce,omitempty"`
+	Version   int    `json:"version,omitempty"`
+	Features  uint64 `json:"features,omitempty"`
+}
+
+type PingAckBody struct {
+	// Signature covers the pong's nonce
+	Signature string `json:"signature"`
 }
 
 type FindNodeBody struct {
 	SenderID string `json:"sender_id"`
 	TargetID string `json:"target_id"`
+	Network  string `json:"network,omitempty"`
 }
 
 type FoundNodesBody struct {
@@ -50,100 +111,160 @@ type FoundNodesBody struct {
 }
 
 type ContactInfo struct {
-	ID   string `json:"id"`
-	Addr string `json:"addr"`
-	Port int    `json:"port"`
+	ID      string `json:"id"`
+	Addr    string `json:"addr"`
+	Port    int    `json:"port"`
+	Network string `json:"network,omitempty"`
 }
 
 type StoreBody struct {
 	Record Record `json:"record"`
 }
 
+// CacheStoreBody asks a node to keep a cached copy of a record for TTL
+// seconds, see spreadRecord
+type CacheStoreBody struct {
+	Record Record `json:"record"`
+	TTL    int64  `json:"ttl"`
+}
+
 type FindValueBody struct {
 	SenderID string `json:"sender_id"`
 	Name     string `json:"name"`
 	GroupKey string `json:"group_key"`
+	Network  string `json:"network,omitempty"`
 }
 
 type FoundValueBody struct {
 	Record Record `json:"record"`
 }
 
-func writeMessage(conn net.Conn, msg Message) error {
-	body, err := json.Marshal(msg.Body)
-	if err != nil {
-		return fmt.Errorf("failed to encode message body: %w", err)
-	}
+type NotFoundBody struct{}
 
+func writeMessage(conn net.Conn, msg Message) error {
 	w := bufio.NewWriter(conn)
 	if err := w.WriteByte(msg.Type); err != nil {
 		return fmt.Errorf("failed to write message type: %w", err)
 	}
 
 	lenBuf := make([]byte, 4)
-	binary.BigEndian.PutUint32(lenBuf, uint32(len(body)))
+	binary.BigEndian.PutUint32(lenBuf, uint32(len(msg.Body)))
 	if _, err := w.Write(lenBuf); err != nil {
 		return fmt.Errorf("failed to write message length: %w", err)
 	}
 
-	if _, err := w.Write(body); err != nil {
+	if _, err := w.Write(msg.Body); err != nil {
 		return fmt.Errorf("failed to write message body: %w", err)
 	}
 
 	return w.Flush()
 }
 
-func readMessage(conn net.Conn) (Message, error) {
-	conn.SetReadDeadline(time.Now().Add(readTimeout))
-
+// readMessage reads one message, the caller setting any deadline
+func readMessage(r io.Reader) (Message, error) {
 	typeBuf := make([]byte, 1)
-	if _, err := io.ReadFull(conn, typeBuf); err != nil {
+	if _, err := io.ReadFull(r, typeBuf); err != nil {
 		return Message{}, fmt.Errorf("failed to read message type: %w", err)
 	}
 
 	lenBuf := make([]byte, 4)
-	if _, err := io.ReadFull(conn, lenBuf); err != nil {
+	if _, err := io.ReadFull(r, lenBuf); err != nil {
 		return Message{}, fmt.Errorf("failed to read message length: %w", err)
 	}
 	bodyLen := binary.BigEndian.Uint32(lenBuf)
 
-	if bodyLen > 1024*1024 {
+	if bodyLen > maxMessageSize {
 		return Message{}, fmt.Errorf("message too large: %d bytes", bodyLen)
 	}
 
 	body := make([]byte, bodyLen)
-	if _, err := io.ReadFull(conn, body); err != nil {
+	if _, err := io.ReadFull(r, body); err != nil {
 		return Message{}, fmt.Errorf("failed to read message body: %w", err)
 	}
 
 	return Message{
 		Type: typeBuf[0],
-		Body: json.RawMessage(body),
+		Body: body,
 	}, nil
 }
 
-func SendPing(addr string, self Contact) (*PongBody, error) {
-	conn, err := net.DialTimeout("tcp", addr, readTimeout)
+// request sends a request and waits for the reply — over UDP when the
+// peer takes it, and again over TCP if the reply doesn't fit a datagram
+// or never comes
+func (d *DHT) request(addr string, typ byte, body interface{}) (Message, error) {
+	if conn := d.pool.openUDP(addr); conn != nil {
+		reply, err := roundTrip(conn, typ, body)
+		conn.Close()
+		if err == nil || !d.retryOverTCP(addr, err) {
+			return reply, err
+		}
+	}
+
+	conn, err := d.pool.open(addr)
+	if err != nil {
+		return Message{}, err
+	}
+	defer conn.Close()
+	return roundTrip(conn, typ, body)
+}
+
+func roundTrip(conn rpcConn, typ byte, body interface{}) (Message, error) {
+	if err := conn.Send(typ, body); err != nil {
+		return Message{}, err
+	}
+	return conn.Receive()
+}
+
+// retryOverTCP reports whether a UDP RPC that failed with err should be
+// asked again over TCP, keeping addr off UDP for a while if it's silent
+func (d *DHT) retryOverTCP(addr string, err error) bool {
+	switch {
+	case errors.Is(err, errTruncated):
+		return true
+	case errors.Is(err, errNoAnswer):
+		d.pool.udpFailed(addr)
+		return true
+	}
+	return false
+}
+
+func (d *DHT) SendPing(addr string, self Contact) (*PongBody, error) {
+	if conn := d.pool.openUDP(addr); conn != nil {
+		pong, err := d.ping(conn, addr, self)
+		conn.Close()
+		if err == nil || !d.retryOverTCP(addr, err) {
+			return pong, err
+		}
+	}
+
+	conn, err := d.pool.open(addr)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to %s: %w", addr, err)
+		return nil, err
 	}
 	defer conn.Close()
+	return d.ping(conn, addr, self)
+}
 
-	pingBody, _ := json.Marshal(PingBody{
+// ping runs the handshake in handshake.go on conn
+func (d *DHT) ping(conn rpcConn, addr string, self Contact) (*PongBody, error) {
+	nonce, err := newNonce()
+	if err != nil {
+		return nil, err
+	}
+	err = conn.Send(MsgPing, PingBody{
 		SenderID:   self.ID.String(),
 		SenderAddr: self.Address.String(),
 		SenderPort: self.Port,
-	})
-
-	err = writeMessage(conn, Message{
-		Type: MsgPing,
-		Body: pingBody,
+		Network:    d.network,
+		Nonce:      nonce,
+		Version:    ProtocolVersion,
+		Features:   d.features(),
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to send ping: %w", err)
 	}
 
-	response, err := readMessage(conn)
+	response, err := conn.Receive()
 	if err != nil {
 		return nil, fmt.Errorf("failed to read pong: %w", err)
 	}
@@ -152,30 +273,39 @@ func SendPing(addr string, self Contact) (*PongBody, error) {
 	}
 
 	var pong PongBody
-	if err := json.Unmarshal(response.Body, &pong); err != nil {
+	if err := response.Decode(&pong); err != nil {
 		return nil, fmt.Errorf("failed to decode pong: %w", err)
 	}
-	return &pong, nil
-}
+	if pong.Network != d.network {
+		return nil, fmt.Errorf("peer is on %s, not %s", networkName(pong.Network), networkName(d.network))
+	}
 
-func SendFindNode(addr string, senderID NodeID, targetID NodeID) ([]ContactInfo, error) {
-	conn, err := net.DialTimeout("tcp", addr, readTimeout)
+	id, err := NodeIDFromHex(pong.SenderID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect: %w", err)
+		return nil, fmt.Errorf("invalid sender ID: %w", err)
 	}
-	defer conn.Close()
+	err = d.verifyHandshake(conn, id, pongContext, nonce, pong.Signature)
+	d.handshakes.count(err)
+	if err != nil {
+		return nil, err
+	}
+	d.pool.setFeatures(addr, pong.Version, pong.Features)
 
-	body, _ := json.Marshal(FindNodeBody{
-		SenderID: senderID.String(),
-		TargetID: targetID.String(),
+	err = conn.Send(MsgPingAck, PingAckBody{
+		Signature: d.signHandshake(pingAckContext, pong.Nonce),
 	})
-
-	err = writeMessage(conn, Message{Type: MsgFindNode, Body: body})
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to send ping ack: %w", err)
 	}
+	return &pong, nil
+}
 
-	response, err := readMessage(conn)
+func (d *DHT) SendFindNode(addr string, senderID NodeID, targetID NodeID) ([]ContactInfo, error) {
+	response, err := d.request(addr, MsgFindNode, FindNodeBody{
+		SenderID: senderID.String(),
+		TargetID: targetID.String(),
+		Network:  d.network,
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -185,43 +315,41 @@ func SendFindNode(addr string, senderID NodeID, targetID NodeID) ([]ContactInfo,
 	}
 
 	var found FoundNodesBody
-	if err := json.Unmarshal(response.Body, &found); err != nil {
+	if err := response.Decode(&found); err != nil {
 		return nil, err
 	}
 
 	return found.Nodes, nil
 }
 
-func SendStore(addr string, record Record) error {
-	conn, err := net.DialTimeout("tcp", addr, readTimeout)
+func (d *DHT) SendStore(addr string, record Record) error {
+	conn, err := d.pool.open(addr)
 	if err != nil {
-		return fmt.Errorf("failed to connect: %w", err)
+		return err
 	}
 	defer conn.Close()
 
-	body, _ := json.Marshal(StoreBody{Record: record})
-	return writeMessage(conn, Message{Type: MsgStore, Body: body})
+	return conn.Send(MsgStore, StoreBody{Record: record})
 }
 
-func SendFindValue(addr string, senderID NodeID, name string, groupKey string) (*Record, []ContactInfo, error) {
-	conn, err := net.DialTimeout("tcp", addr, readTimeout)
+// SendCacheStore asks addr to keep a cached copy of record for ttl
+func (d *DHT) SendCacheStore(addr string, record Record, ttl time.Duration) error {
+	conn, err := d.pool.open(addr)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to connect: %w", err)
+		return err
 	}
 	defer conn.Close()
 
-	body, _ := json.Marshal(FindValueBody{
+	return conn.Send(MsgCacheStore, CacheStoreBody{Record: record, TTL: int64(ttl / time.Second)})
+}
+
+func (d *DHT) SendFindValue(addr string, senderID NodeID, name string, groupKey string) (*Record, []ContactInfo, error) {
+	response, err := d.request(addr, MsgFindValue, FindValueBody{
 		SenderID: senderID.String(),
 		Name:     name,
 		GroupKey: groupKey,
+		Network:  d.network,
 	})
-
-	err = writeMessage(conn, Message{Type: MsgFindValue, Body: body})
-	if err != nil {
-		return nil, nil, err
-	}
-
-	response, err := readMessage(conn)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -229,14 +357,14 @@ func SendFindValue(addr string, senderID NodeID, name string, groupKey string) (
 	switch response.Type {
 	case MsgFoundValue:
 		var found FoundValueBody
-		if err := json.Unmarshal(response.Body, &found); err != nil {
+		if err := response.Decode(&found); err != nil {
 			return nil, nil, err
 		}
 		return &found.Record, nil, nil
 
 	case MsgFoundNodes:
 		var nodes FoundNodesBody
-		if err := json.Unmarshal(response.Body, &nodes); err != nil {
+		if err := response.Decode(&nodes); err != nil {
 			return nil, nil, err
 		}
 		return nil, nodes.Nodes, nil
@@ -0,0 +1,63 @@
+package dht
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// SequenceCounter hands out record sequence numbers, saved to a file so
+// they keep rising across restarts. A number is never below the current
+// Unix time either, so a node that lost the file still outbids the
+// records it signed before.
+type SequenceCounter struct {
+	path string
+	mu   sync.Mutex
+	last uint64
+}
+
+type sequenceFile struct {
+	Sequence uint64 `json:"sequence"`
+}
+
+// LoadSequenceCounter reads the counter saved at path, starting a new
+// one if the file does not exist
+func LoadSequenceCounter(path string) (*SequenceCounter, error) {
+	c := &SequenceCounter{path: path}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return c, nil
+		}
+		return nil, fmt.Errorf("failed to read sequence counter: %w", err)
+	}
+	var f sequenceFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("failed to parse sequence counter: %w", err)
+	}
+	c.last = f.Sequence
+	return c, nil
+}
+
+// Next returns a sequence number higher than any handed out before,
+// saving it first so a crash can't hand it out twice
+func (c *SequenceCounter) Next() (uint64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	next := c.last + 1
+	if now := uint64(time.Now().Unix()); next < now {
+		next = now
+	}
+	data, err := json.MarshalIndent(sequenceFile{Sequence: next}, "", "  ")
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode sequence counter: %w", err)
+	}
+	if err := os.WriteFile(c.path, data, 0644); err != nil {
+		return 0, fmt.Errorf("failed to save sequence counter: %w", err)
+	}
+	c.last = next
+	return next, nil
+}
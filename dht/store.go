@@ -20,6 +20,15 @@ type Record struct {
 	GroupKey  string   `json:"group_key"`
 	Signature string   `json:"signature"`
 	Expires   int64    `json:"expires"`
+	// Sequence rises with every record the owner signs for a name, so an
+	// old copy replayed later loses to the current one
+	Sequence uint64 `json:"sequence,omitempty"`
+	// Network is signed so a record cannot be replayed into another network
+	Network string `json:"network,omitempty"`
+
+	// Succession proves a rotated key inherited this name
+	// self-authenticating, so not part of the signing payload
+	Succession *Succession `json:"succession,omitempty"`
 }
 
 func (r *Record) IsExpired() bool {
@@ -38,6 +47,8 @@ func (r *Record) SigningPayload() []byte {
 		Services  []string `json:"services"`
 		GroupKey  string   `json:"group_key"`
 		Expires   int64    `json:"expires"`
+		Sequence  uint64   `json:"sequence,omitempty"`
+		Network   string   `json:"network,omitempty"`
 	}{
 		Name:      r.Name,
 		Address:   r.Address,
@@ -45,6 +56,8 @@ func (r *Record) SigningPayload() []byte {
 		Services:  r.Services,
 		GroupKey:  r.GroupKey,
 		Expires:   r.Expires,
+		Sequence:  r.Sequence,
+		Network:   r.Network,
 	})
 
 	hash := sha256.Sum256(payload)
@@ -56,12 +69,19 @@ func (r *Record) Verify() error {
 	if err != nil {
 		return fmt.Errorf("invalid public key: %w", err)
 	}
+	// ed25519.Verify panics on a key of the wrong length
+	if len(pubKeyBytes) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid public key: %d bytes", len(pubKeyBytes))
+	}
 	pubKey := ed25519.PublicKey(pubKeyBytes)
 
 	sigBytes, err := hex.DecodeString(r.Signature)
 	if err != nil {
 		return fmt.Errorf("invalid signature: %w", err)
 	}
+	if len(sigBytes) != ed25519.SignatureSize {
+		return fmt.Errorf("invalid signature: %d bytes", len(sigBytes))
+	}
 
 	payload := r.SigningPayload()
 	if !ed25519.Verify(pubKey, payload, sigBytes) {
@@ -78,15 +98,26 @@ func RecordID(name string) NodeID {
 }
 
 type Store struct {
-	records map[string]Record
-	mu      sync.RWMutex
-	done    chan struct{}
+	network     string
+	records     map[string]Record
+	successions map[string]Succession // keyed by old key
+	// stored is when each record last arrived or went out again, which
+	// decides when it is due for republishing
+	stored map[string]time.Time
+	// cachedUntil holds the names we have only a cached copy of, from a
+	// lookup that passed by, and when each stops being served
+	cachedUntil map[string]time.Time
+	mu          sync.RWMutex
+	done        chan struct{}
 }
 
 func NewStore() *Store {
 	return &Store{
-		records: make(map[string]Record),
-		done:    make(chan struct{}),
+		records:     make(map[string]Record),
+		successions: make(map[string]Succession),
+		stored:      make(map[string]time.Time),
+		cachedUntil: make(map[string]time.Time),
+		done:        make(chan struct{}),
 	}
 }
 
@@ -99,6 +130,21 @@ func (s *Store) Stop() {
 }
 
 func (s *Store) Put(r Record) error {
+	return s.put(r, 0)
+}
+
+// PutCached keeps r as a cached copy for ttl: served to lookups, but
+// neither republished nor handed off. A record already held in full
+// stays held in full.
+func (s *Store) PutCached(r Record, ttl time.Duration) error {
+	return s.put(r, ttl)
+}
+
+// put stores r, as a cached copy for ttl unless ttl is 0
+func (s *Store) put(r Record, ttl time.Duration) error {
+	if r.Network != s.network {
+		return fmt.Errorf("record belongs to network %q", r.Network)
+	}
 	if r.IsExpired() {
 		return fmt.Errorf("record is already expired")
 	}
@@ -108,16 +154,113 @@ func (s *Store) Put(r Record) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	if r.Succession != nil {
+		if err := s.addSuccession(*r.Succession, r.PublicKey); err != nil {
+			return fmt.Errorf("invalid succession: %w", err)
+		}
+	}
+
+	if s.isSuperseded(r.PublicKey) {
+		return fmt.Errorf("key for %q has been rotated to a successor", r.Name)
+	}
+
+	if s.cacheExpired(r.Name) {
+		delete(s.records, r.Name)
+		delete(s.cachedUntil, r.Name)
+	}
 	existing, exists := s.records[r.Name]
 	if exists {
-		if existing.PublicKey != r.PublicKey {
+		if existing.PublicKey != r.PublicKey && !s.succeeds(existing.PublicKey, r.PublicKey) {
 			return fmt.Errorf("name %q is owned by a different key", r.Name)
 		}
+		// a replayed or republished copy may be older than one the owner
+		// sent since
+		if existing.PublicKey == r.PublicKey && r.Sequence < existing.Sequence {
+			return fmt.Errorf("record for %q has sequence %d, older than the stored %d",
+				r.Name, r.Sequence, existing.Sequence)
+		}
 	}
 	s.records[r.Name] = r
+	_, cached := s.cachedUntil[r.Name]
+	switch {
+	case ttl == 0:
+		delete(s.cachedUntil, r.Name)
+		s.stored[r.Name] = time.Now()
+	case !exists || cached:
+		s.cachedUntil[r.Name] = time.Now().Add(ttl)
+	}
+	return nil
+}
+
+// AddSuccession records a verified key rotation
+// later records from the new key may then replace the old key's
+func (s *Store) AddSuccession(succ Succession) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.addSuccession(succ, succ.NewKey)
+}
+
+func (s *Store) addSuccession(succ Succession, newKey string) error {
+	if succ.NewKey != newKey {
+		return fmt.Errorf("succession does not name this key")
+	}
+	if succ.Network != s.network {
+		return fmt.Errorf("succession belongs to network %q", succ.Network)
+	}
+	if err := succ.Verify(); err != nil {
+		return err
+	}
+	if !succ.InEffect() {
+		return fmt.Errorf("succession not in effect until %s", time.Unix(succ.Effective, 0))
+	}
+
+	// a key hands over once — the first handover seen wins so a stolen
+	// key cannot redirect names already passed to a successor
+	if known, ok := s.successions[succ.OldKey]; ok {
+		if known.NewKey == succ.NewKey {
+			return nil
+		}
+		return fmt.Errorf("key already handed over to %s...", known.NewKey[:16])
+	}
+	s.successions[succ.OldKey] = succ
 	return nil
 }
 
+// IsSuperseded reports whether key has rotated to a successor
+func (s *Store) IsSuperseded(key string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.isSuperseded(key)
+}
+
+func (s *Store) isSuperseded(key string) bool {
+	succ, ok := s.successions[key]
+	return ok && succ.InEffect()
+}
+
+// Succeeds reports whether newKey is reachable from oldKey
+// through a chain of in-effect successions
+func (s *Store) Succeeds(oldKey, newKey string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.succeeds(oldKey, newKey)
+}
+
+func (s *Store) succeeds(oldKey, newKey string) bool {
+	cur := oldKey
+	for i := 0; i < maxSuccessionChain; i++ {
+		succ, ok := s.successions[cur]
+		if !ok || !succ.InEffect() {
+			return false
+		}
+		if succ.NewKey == newKey {
+			return true
+		}
+		cur = succ.NewKey
+	}
+	return false
+}
+
 func (s *Store) Get(name string) (Record, bool) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -126,7 +269,11 @@ func (s *Store) Get(name string) (Record, bool) {
 	if !exists {
 		return Record{}, false
 	}
-	if r.IsExpired() {
+	if r.IsExpired() || s.cacheExpired(name) {
+		return Record{}, false
+	}
+	// the owner rotated keys — this copy is stale until the new one arrives
+	if s.isSuperseded(r.PublicKey) {
 		return Record{}, false
 	}
 	return r, true
@@ -158,15 +305,27 @@ func (s *Store) Delete(name string) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	delete(s.records, name)
+	delete(s.stored, name)
+	delete(s.cachedUntil, name)
+}
+
+// cacheExpired reports whether name is a cached copy past its time
+func (s *Store) cacheExpired(name string) bool {
+	until, cached := s.cachedUntil[name]
+	return cached && time.Now().After(until)
 }
 
+// All returns the records held in full, leaving out cached copies
 func (s *Store) All() []Record {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	var result []Record
-	for _, r := range s.records {
-		if !r.IsExpired() {
+	for name, r := range s.records {
+		if _, cached := s.cachedUntil[name]; cached {
+			continue
+		}
+		if !r.IsExpired() && !s.isSuperseded(r.PublicKey) {
 			result = append(result, r)
 		}
 	}
@@ -198,8 +357,10 @@ func (s *Store) cleanup() {
 
 	removed := 0
 	for name, r := range s.records {
-		if r.IsExpired() {
+		if r.IsExpired() || s.cacheExpired(name) {
 			delete(s.records, name)
+			delete(s.stored, name)
+			delete(s.cachedUntil, name)
 			removed++
 		}
 	}
@@ -207,3 +368,34 @@ func (s *Store) cleanup() {
 		fmt.Printf("DHT store: removed %d expired records \n", removed)
 	}
 }
+
+// dueForRepublish returns the records that neither arrived nor went out
+// for age — a record some other holder republished meanwhile isn't, and
+// neither is a cached copy
+func (s *Store) dueForRepublish(age time.Duration) []Record {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var due []Record
+	for name, r := range s.records {
+		if _, cached := s.cachedUntil[name]; cached {
+			continue
+		}
+		if r.IsExpired() || s.isSuperseded(r.PublicKey) {
+			continue
+		}
+		if time.Since(s.stored[name]) > age {
+			due = append(due, r)
+		}
+	}
+	return due
+}
+
+// republished restarts name's republish timer
+func (s *Store) republished(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.records[name]; ok {
+		s.stored[name] = time.Now()
+	}
+}
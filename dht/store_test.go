@@ -0,0 +1,103 @@
+package dht
+
+import (
+	"crypto/ed25519"
+	"testing"
+	"time"
+)
+
+func testRecord(t testing.TB, name string) (Record, ed25519.PrivateKey) {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := CreateRecord(RegisterOptions{Name: name, Address: "200::1", PrivateKey: priv})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r, priv
+}
+
+func TestVerifyRejectsBadLengths(t *testing.T) {
+	r, _ := testRecord(t, "alice")
+
+	short := r
+	short.PublicKey = "00"
+	if err := short.Verify(); err == nil {
+		t.Error("record with a 1 byte key verified")
+	}
+	short = r
+	short.Signature = "00"
+	if err := short.Verify(); err == nil {
+		t.Error("record with a 1 byte signature verified")
+	}
+
+	_, old, _ := ed25519.GenerateKey(nil)
+	newPub, _, _ := ed25519.GenerateKey(nil)
+	s, err := CreateSuccession(old, newPub, time.Now(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Signature = "00"
+	if err := s.Verify(); err == nil {
+		t.Error("succession with a 1 byte signature verified")
+	}
+}
+
+func TestSuccessionBoundToNetwork(t *testing.T) {
+	_, old, _ := ed25519.GenerateKey(nil)
+	newPub, _, _ := ed25519.GenerateKey(nil)
+	s, err := CreateSuccession(old, newPub, time.Now(), "lab")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := NewStore()
+	if err := store.AddSuccession(s); err == nil {
+		t.Error("succession from network \"lab\" accepted by the public network")
+	}
+	store.network = "lab"
+	if err := store.AddSuccession(s); err != nil {
+		t.Errorf("succession for its own network refused: %v", err)
+	}
+
+	moved := s
+	moved.Network = ""
+	if err := moved.Verify(); err == nil {
+		t.Error("succession verified after its network was changed")
+	}
+}
+
+func TestCachedCopyNotRepublished(t *testing.T) {
+	s := NewStore()
+	r, _ := testRecord(t, "alice")
+	if err := s.PutCached(r, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.Get("alice"); !ok {
+		t.Error("cached copy not served")
+	}
+	if len(s.All()) != 0 || len(s.dueForRepublish(0)) != 0 {
+		t.Error("cached copy up for handoff or republishing")
+	}
+
+	s.cachedUntil["alice"] = time.Now().Add(-time.Second)
+	if _, ok := s.Get("alice"); ok {
+		t.Error("cached copy served past its time")
+	}
+
+	// a full STORE makes it a record of our own
+	if err := s.PutCached(r, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(r); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.PutCached(r, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.dueForRepublish(0)) != 1 {
+		t.Error("record held in full became a cached copy")
+	}
+}
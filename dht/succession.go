@@ -0,0 +1,139 @@
+package dht
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// maxSuccessionChain bounds how many rotations we follow when checking
+// whether one key has handed its names to another
+const maxSuccessionChain = 8
+
+// Succession hands name ownership from OldKey to NewKey.
+// It is signed by the old key, so only the current owner can rotate.
+type Succession struct {
+	OldKey    string `json:"old_key"`
+	NewKey    string `json:"new_key"`
+	Effective int64  `json:"effective"`
+	Signature string `json:"signature"`
+	// Network is signed so a rotation cannot be replayed into another network
+	Network string `json:"network,omitempty"`
+}
+
+// SigningPayload returns the hash the old key signs
+// the domain tag keeps a succession signature from ever verifying as a record
+func (s *Succession) SigningPayload() []byte {
+	payload, _ := json.Marshal(struct {
+		Domain    string `json:"domain"`
+		OldKey    string `json:"old_key"`
+		NewKey    string `json:"new_key"`
+		Effective int64  `json:"effective"`
+		Network   string `json:"network,omitempty"`
+	}{
+		Domain:    "meshnet-succession",
+		OldKey:    s.OldKey,
+		NewKey:    s.NewKey,
+		Effective: s.Effective,
+		Network:   s.Network,
+	})
+
+	hash := sha256.Sum256(payload)
+	return hash[:]
+}
+
+// Verify checks the old key's signature
+func (s *Succession) Verify() error {
+	oldKey, err := hex.DecodeString(s.OldKey)
+	if err != nil || len(oldKey) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid old key")
+	}
+	newKey, err := hex.DecodeString(s.NewKey)
+	if err != nil || len(newKey) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid new key")
+	}
+	if s.OldKey == s.NewKey {
+		return fmt.Errorf("succession to the same key")
+	}
+
+	sig, err := hex.DecodeString(s.Signature)
+	if err != nil {
+		return fmt.Errorf("invalid signature: %w", err)
+	}
+	if len(sig) != ed25519.SignatureSize {
+		return fmt.Errorf("invalid signature: %d bytes", len(sig))
+	}
+	if !ed25519.Verify(ed25519.PublicKey(oldKey), s.SigningPayload(), sig) {
+		return fmt.Errorf("succession signature verification failed")
+	}
+	return nil
+}
+
+// InEffect reports whether the succession's effective time has passed
+func (s *Succession) InEffect() bool {
+	return !time.Now().Before(time.Unix(s.Effective, 0))
+}
+
+// CreateSuccession signs a handover of oldKey's names to newKey on
+// network, "" being the public one
+func CreateSuccession(oldKey ed25519.PrivateKey, newKey ed25519.PublicKey, effective time.Time, network string) (Succession, error) {
+	if oldKey == nil {
+		return Succession{}, fmt.Errorf("old key cannot be nil")
+	}
+	if len(newKey) != ed25519.PublicKeySize {
+		return Succession{}, fmt.Errorf("new key must be %d bytes", ed25519.PublicKeySize)
+	}
+
+	s := Succession{
+		OldKey:    hex.EncodeToString(oldKey.Public().(ed25519.PublicKey)),
+		NewKey:    hex.EncodeToString(newKey),
+		Effective: effective.Unix(),
+		Network:   network,
+	}
+	s.Signature = hex.EncodeToString(ed25519.Sign(oldKey, s.SigningPayload()))
+	return s, nil
+}
+
+// SaveSuccession writes a succession to disk so a restarted node can
+// attach it to the records it announces under the new key
+func SaveSuccession(path string, s Succession) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode succession: %w", err)
+	}
+	// through a temporary file, so a failed write leaves the old one
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to save succession: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to save succession: %w", err)
+	}
+	return nil
+}
+
+// LoadSuccession reads a saved succession
+// returns nil, nil if the file does not exist
+func LoadSuccession(path string) (*Succession, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read succession: %w", err)
+	}
+
+	var s Succession
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("failed to parse succession: %w", err)
+	}
+	if err := s.Verify(); err != nil {
+		return nil, fmt.Errorf("invalid succession: %w", err)
+	}
+	return &s, nil
+}
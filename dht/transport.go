@@ -0,0 +1,81 @@
+package dht
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/address"
+)
+
+// Transport carries DHT connections between nodes
+// the OS network stack when a TUN adapter routes 200::/7, otherwise
+// something that goes through the embedded Yggdrasil core
+type Transport interface {
+	// Listen accepts connections on the DHT port
+	Listen(port int) (net.Listener, error)
+	// Dial connects to a node at "[address]:port"
+	Dial(addr string, timeout time.Duration) (net.Conn, error)
+}
+
+// TCPTransport uses plain TCP through the OS — needs a TUN adapter for
+// mesh addresses to be reachable
+type TCPTransport struct{}
+
+func (TCPTransport) Listen(port int) (net.Listener, error) {
+	listenAddr := fmt.Sprintf("[::]:%d", port)
+	listener, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", listenAddr, err)
+	}
+	return listener, nil
+}
+
+func (TCPTransport) Dial(addr string, timeout time.Duration) (net.Conn, error) {
+	return net.DialTimeout("tcp", addr, timeout)
+}
+
+// ListenPacket opens the UDP socket. Only Yggdrasil addresses are
+// answered — the socket is on every interface, and a lookup reply is
+// larger than the request that asks for it.
+func (TCPTransport) ListenPacket(port int) (net.PacketConn, error) {
+	listenAddr := fmt.Sprintf("[::]:%d", port)
+	pc, err := net.ListenPacket("udp", listenAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on udp %s: %w", listenAddr, err)
+	}
+	return meshOnlyPacketConn{pc}, nil
+}
+
+// meshOnlyPacketConn drops datagrams from outside 200::/7
+type meshOnlyPacketConn struct {
+	net.PacketConn
+}
+
+func (c meshOnlyPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		n, from, err := c.PacketConn.ReadFrom(b)
+		if err != nil {
+			return n, from, err
+		}
+		if ua, ok := from.(*net.UDPAddr); ok && isMeshIP(ua.IP) {
+			return n, from, nil
+		}
+	}
+}
+
+func isMeshIP(ip net.IP) bool {
+	ip = ip.To16()
+	if ip == nil || ip.To4() != nil {
+		return false
+	}
+	var addr address.Address
+	copy(addr[:], ip)
+	return addr.IsValid()
+}
+
+// SetTransport replaces the default TCP transport — call before Start
+func (d *DHT) SetTransport(t Transport) {
+	d.transport = t
+	d.pool = newConnPool(t)
+}
@@ -0,0 +1,550 @@
+package dht
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Pings and lookups can also travel as datagrams on the DHT port, to
+// peers whose pong advertised FeatureUDP. Each datagram is
+//
+//	flags (1) | type (1) | request ID (4) | body
+//
+// and the messages of one RPC share a request ID, as on a multiplexed
+// connection. IDs count up from a random start, and a reply only counts
+// if it comes from the address the request went to. Both sides resend their last message when the answer is
+// late, on a timer that follows the measured round trip time, and
+// answer a repeat of what they last received with a repeat of what
+// they last sent.
+//
+// A reply that doesn't fit a datagram is sent as an empty truncated
+// one and the RPC is asked again over TCP. So is one the peer doesn't
+// answer at all, and the peer then gets TCP for a while.
+//
+// The first byte never has its two top bits set: on the mesh datagrams
+// share the core with QUIC, which claims every packet that does.
+
+// datagram flags
+const (
+	// udpFromDialer marks messages from the side that started the RPC
+	udpFromDialer = 1 << iota
+	udpBinary
+	// udpTruncated is an empty reply standing in for one too large
+	udpTruncated
+)
+
+const udpHeaderSize = 6
+
+// udpMaxPayload keeps a datagram within the IPv6 minimum MTU
+const udpMaxPayload = 1280 - 40 - 8 - udpHeaderSize
+
+// retransmission tuning, after RFC 6298
+const (
+	udpInitialRTO = time.Second
+	udpMinRTO     = 200 * time.Millisecond
+	udpMaxRTO     = 4 * time.Second
+	// udpMaxSends is how many times a message goes out before giving up
+	udpMaxSends = 3
+	// udpLinger keeps a finished RPC around to answer late repeats
+	udpLinger = readTimeout
+	// udpRTTExpiry forgets the round trip time of peers not heard from
+	udpRTTExpiry = 10 * time.Minute
+)
+
+var (
+	errTruncated = errors.New("reply too large for a datagram")
+	errNoAnswer  = errors.New("no answer over UDP")
+)
+
+// PacketTransport is a Transport that can also carry datagrams
+type PacketTransport interface {
+	Transport
+	// ListenPacket opens the datagram socket on the DHT port
+	ListenPacket(port int) (net.PacketConn, error)
+}
+
+// UDPStats counts the datagram RPCs we started, for /status
+type UDPStats struct {
+	Enabled     bool   `json:"enabled"`
+	Requests    uint64 `json:"requests"`
+	Retransmits uint64 `json:"retransmits"`
+	Truncated   uint64 `json:"truncated"`
+	Unanswered  uint64 `json:"unanswered"`
+}
+
+// udpKey identifies an RPC — ours by ID alone, a peer's by its address
+// as well
+type udpKey struct {
+	peer string
+	id   uint32
+}
+
+// udpMux serves and dials RPCs on one datagram socket
+type udpMux struct {
+	pc    net.PacketConn
+	serve func(rpcConn, Message)
+
+	mu        sync.Mutex
+	exchanges map[udpKey]*udpExchange
+	// recent are finished RPCs, kept to answer late repeats
+	recent   map[udpKey]*udpExchange
+	nextID   uint32
+	rtt      map[string]*rttEstimate
+	inflight chan struct{}
+	handlers sync.WaitGroup
+
+	requests, retransmits, truncated, unanswered atomic.Uint64
+
+	done     chan struct{}
+	doneOnce sync.Once
+}
+
+func newUDPMux(pc net.PacketConn, serve func(rpcConn, Message)) *udpMux {
+	var start [4]byte
+	rand.Read(start[:])
+	return &udpMux{
+		nextID:    binary.BigEndian.Uint32(start[:]),
+		pc:        pc,
+		serve:     serve,
+		exchanges: make(map[udpKey]*udpExchange),
+		recent:    make(map[udpKey]*udpExchange),
+		rtt:       make(map[string]*rttEstimate),
+		inflight:  make(chan struct{}, muxMaxInflight),
+		done:      make(chan struct{}),
+	}
+}
+
+// Start reads datagrams and cleans up in the background
+func (m *udpMux) Start() {
+	go m.readLoop()
+	go m.loop()
+}
+
+func (m *udpMux) readLoop() {
+	defer m.Close()
+	buf := make([]byte, udpHeaderSize+udpMaxPayload)
+	for {
+		n, from, err := m.pc.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-m.done:
+				return
+			default:
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		if n < udpHeaderSize {
+			continue
+		}
+		m.dispatch(append([]byte(nil), buf[:n]...), from)
+	}
+}
+
+// dispatch routes one datagram to its RPC, starting one for a new request
+func (m *udpMux) dispatch(datagram []byte, from net.Addr) {
+	flags := datagram[0]
+	id := binary.BigEndian.Uint32(datagram[2:6])
+	key := udpKey{id: id}
+	if flags&udpFromDialer != 0 {
+		key.peer = from.String()
+	}
+
+	m.mu.Lock()
+	ex := m.exchanges[key]
+	if ex == nil {
+		ex = m.recent[key]
+	}
+	if ex != nil {
+		m.mu.Unlock()
+		// a peer's RPC is keyed by its address already, ours isn't
+		if key.peer == "" && !sameSource(from, ex.addr) {
+			return
+		}
+		ex.deliver(datagram)
+		return
+	}
+
+	msg := Message{
+		Type:   datagram[1],
+		Body:   datagram[udpHeaderSize:],
+		Binary: flags&udpBinary != 0,
+	}
+	if key.peer == "" || !udpServes(msg.Type) {
+		m.mu.Unlock()
+		return
+	}
+	select {
+	case m.inflight <- struct{}{}:
+	default:
+		// too busy — the dialer resends or tries TCP
+		m.mu.Unlock()
+		return
+	}
+	ex = m.register(key, from, msg.Binary)
+	ex.lastRecv = datagram
+	m.mu.Unlock()
+
+	m.handlers.Add(1)
+	go func() {
+		defer m.handlers.Done()
+		defer func() { <-m.inflight }()
+		defer ex.Close()
+		m.serve(ex, msg)
+	}()
+}
+
+// sameSource reports whether a datagram from came from the address to
+// that we sent to. The mesh gives no source port, only the address the
+// sender's key owns, so a missing port matches any.
+func sameSource(from, to net.Addr) bool {
+	f, ok1 := from.(*net.UDPAddr)
+	t, ok2 := to.(*net.UDPAddr)
+	if !ok1 || !ok2 {
+		return from.String() == to.String()
+	}
+	return f.IP.Equal(t.IP) && (f.Port == 0 || f.Port == t.Port)
+}
+
+// udpServes reports whether a request may come in over UDP — stores
+// have no reply to confirm them, so they stay on TCP
+func udpServes(typ byte) bool {
+	switch typ {
+	case MsgPing, MsgFindNode, MsgFindValue:
+		return true
+	}
+	return false
+}
+
+// register adds an exchange, m.mu held
+func (m *udpMux) register(key udpKey, addr net.Addr, compact bool) *udpExchange {
+	ex := &udpExchange{
+		m:       m,
+		key:     key,
+		addr:    addr,
+		compact: compact,
+		replies: make(chan udpReply, 4),
+	}
+	m.exchanges[key] = ex
+	return ex
+}
+
+// open starts an outgoing RPC to addr
+func (m *udpMux) open(addr net.Addr, compact bool) (*udpExchange, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	select {
+	case <-m.done:
+		return nil, net.ErrClosed
+	default:
+	}
+	m.nextID++
+	m.requests.Add(1)
+	return m.register(udpKey{id: m.nextID}, addr, compact), nil
+}
+
+func (m *udpMux) finish(ex *udpExchange) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.exchanges[ex.key] == ex {
+		delete(m.exchanges, ex.key)
+		ex.finished = time.Now()
+		m.recent[ex.key] = ex
+	}
+}
+
+// loop forgets finished RPCs and stale round trip times
+func (m *udpMux) loop() {
+	ticker := time.NewTicker(udpLinger / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-m.done:
+			return
+		}
+		m.mu.Lock()
+		for key, ex := range m.recent {
+			if time.Since(ex.finished) > udpLinger {
+				delete(m.recent, key)
+			}
+		}
+		for peer, est := range m.rtt {
+			if time.Since(est.updated) > udpRTTExpiry {
+				delete(m.rtt, peer)
+			}
+		}
+		m.mu.Unlock()
+	}
+}
+
+// rto is how long to wait for an answer from peer before resending
+func (m *udpMux) rto(peer string) time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if est := m.rtt[peer]; est != nil {
+		return est.rto()
+	}
+	return udpInitialRTO
+}
+
+// observe feeds a round trip time into peer's estimate
+func (m *udpMux) observe(peer string, sample time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	est := m.rtt[peer]
+	if est == nil {
+		est = &rttEstimate{}
+		m.rtt[peer] = est
+	}
+	est.add(sample)
+}
+
+func (m *udpMux) Stats() UDPStats {
+	return UDPStats{
+		Enabled:     true,
+		Requests:    m.requests.Load(),
+		Retransmits: m.retransmits.Load(),
+		Truncated:   m.truncated.Load(),
+		Unanswered:  m.unanswered.Load(),
+	}
+}
+
+// Close stops reading and waits for the requests being served
+func (m *udpMux) Close() error {
+	m.doneOnce.Do(func() {
+		close(m.done)
+		m.pc.Close()
+	})
+	m.handlers.Wait()
+	return nil
+}
+
+// rttEstimate is a smoothed round trip time and its variation
+type rttEstimate struct {
+	srtt, rttvar time.Duration
+	updated      time.Time
+}
+
+func (e *rttEstimate) add(sample time.Duration) {
+	if e.srtt == 0 {
+		e.srtt = sample
+		e.rttvar = sample / 2
+	} else {
+		diff := e.srtt - sample
+		if diff < 0 {
+			diff = -diff
+		}
+		e.rttvar = (3*e.rttvar + diff) / 4
+		e.srtt = (7*e.srtt + sample) / 8
+	}
+	e.updated = time.Now()
+}
+
+func (e *rttEstimate) rto() time.Duration {
+	rto := e.srtt + 4*e.rttvar
+	if rto < udpMinRTO {
+		return udpMinRTO
+	}
+	if rto > udpMaxRTO {
+		return udpMaxRTO
+	}
+	return rto
+}
+
+// ── exchange ─────────────────────────────────────────────────────────────────
+
+// udpReply is a message for an exchange, or word that it didn't fit
+type udpReply struct {
+	msg       Message
+	truncated bool
+}
+
+// udpExchange is one RPC on a udpMux
+type udpExchange struct {
+	m       *udpMux
+	key     udpKey
+	addr    net.Addr
+	compact bool
+	replies chan udpReply
+
+	mu       sync.Mutex
+	lastSent []byte
+	lastRecv []byte
+	sentAt   time.Time
+	resent   bool
+	finished time.Time
+}
+
+// deliver hands the exchange a datagram, or answers a repeat of the
+// last one with what we sent in reply
+func (ex *udpExchange) deliver(datagram []byte) {
+	ex.mu.Lock()
+	if bytes.Equal(datagram, ex.lastRecv) {
+		again := ex.lastSent
+		ex.mu.Unlock()
+		if again != nil {
+			ex.m.pc.WriteTo(again, ex.addr)
+		}
+		return
+	}
+	ex.lastRecv = datagram
+	ex.mu.Unlock()
+
+	reply := udpReply{
+		msg: Message{
+			Type:   datagram[1],
+			Body:   datagram[udpHeaderSize:],
+			Binary: datagram[0]&udpBinary != 0,
+		},
+		truncated: datagram[0]&udpTruncated != 0,
+	}
+	select {
+	case ex.replies <- reply:
+	default:
+	}
+}
+
+func (ex *udpExchange) Send(typ byte, body interface{}) error {
+	msg, err := newMessage(typ, body, ex.compact && typ != MsgPing)
+	if err != nil {
+		return err
+	}
+
+	var flags byte
+	if ex.key.peer == "" {
+		flags |= udpFromDialer
+	}
+	if msg.Binary {
+		flags |= udpBinary
+	}
+	if len(msg.Body) > udpMaxPayload {
+		if ex.key.peer == "" {
+			// our own request is too large — the caller goes to TCP
+			ex.m.truncated.Add(1)
+			return errTruncated
+		}
+		flags |= udpTruncated
+		msg.Body = nil
+	}
+
+	datagram := make([]byte, udpHeaderSize, udpHeaderSize+len(msg.Body))
+	datagram[0] = flags
+	datagram[1] = msg.Type
+	binary.BigEndian.PutUint32(datagram[2:6], ex.key.id)
+	datagram = append(datagram, msg.Body...)
+
+	ex.mu.Lock()
+	ex.lastSent = datagram
+	ex.sentAt = time.Now()
+	ex.resent = false
+	ex.mu.Unlock()
+
+	if _, err := ex.m.pc.WriteTo(datagram, ex.addr); err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	return nil
+}
+
+// Receive waits for the next message, resending ours when it's late
+func (ex *udpExchange) Receive() (Message, error) {
+	peer := ex.addr.String()
+	wait := ex.m.rto(peer)
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	for sends := 1; ; sends++ {
+		select {
+		case reply := <-ex.replies:
+			ex.mu.Lock()
+			// only answers to a message sent once say how long the trip took
+			if !ex.resent && ex.lastSent != nil {
+				ex.m.observe(peer, time.Since(ex.sentAt))
+			}
+			ex.mu.Unlock()
+			if reply.truncated {
+				ex.m.truncated.Add(1)
+				return Message{}, errTruncated
+			}
+			return reply.msg, nil
+
+		case <-ex.m.done:
+			return Message{}, fmt.Errorf("connection closed")
+
+		case <-timer.C:
+			ex.mu.Lock()
+			again := ex.lastSent
+			ex.resent = true
+			ex.mu.Unlock()
+			if sends >= udpMaxSends || again == nil {
+				ex.m.unanswered.Add(1)
+				return Message{}, errNoAnswer
+			}
+			ex.m.retransmits.Add(1)
+			ex.m.pc.WriteTo(again, ex.addr)
+			wait *= 2
+			if wait > udpMaxRTO {
+				wait = udpMaxRTO
+			}
+			timer.Reset(wait)
+		}
+	}
+}
+
+func (ex *udpExchange) RemoteAddr() net.Addr {
+	return ex.addr
+}
+
+func (ex *udpExchange) Close() error {
+	ex.m.finish(ex)
+	return nil
+}
+
+// ── DHT ──────────────────────────────────────────────────────────────────────
+
+// SetUDP turns datagram RPCs on or off, off by default. Must be called
+// before Start.
+func (d *DHT) SetUDP(enabled bool) {
+	d.udpEnabled = enabled
+}
+
+// startUDP opens the datagram socket. A transport without one, or a
+// port already taken, leaves the node on TCP alone.
+func (d *DHT) startUDP() error {
+	pt, ok := d.transport.(PacketTransport)
+	if !ok {
+		return fmt.Errorf("transport has no datagram support")
+	}
+	pc, err := pt.ListenPacket(d.port)
+	if err != nil {
+		return err
+	}
+	d.pool.udp = newUDPMux(pc, d.handleRequest)
+	d.pool.udp.Start()
+	return nil
+}
+
+// features returns the feature bits we advertise
+func (d *DHT) features() uint64 {
+	if d.pool.udp != nil {
+		return localFeatures | FeatureUDP
+	}
+	return localFeatures
+}
+
+// UDPStats returns the datagram RPC counters
+func (d *DHT) UDPStats() UDPStats {
+	if d.pool.udp == nil {
+		return UDPStats{}
+	}
+	return d.pool.udp.Stats()
+}
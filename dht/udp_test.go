@@ -0,0 +1,172 @@
+package dht
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// lossyConn drops the first drop datagrams written
+type lossyConn struct {
+	net.PacketConn
+	drop atomic.Int32
+}
+
+func (c *lossyConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	if c.drop.Add(-1) >= 0 {
+		return len(b), nil
+	}
+	return c.PacketConn.WriteTo(b, addr)
+}
+
+func listenLoopback(t *testing.T) net.PacketConn {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "[::1]:0")
+	if err != nil {
+		t.Skip("no IPv6 loopback:", err)
+	}
+	return pc
+}
+
+// startUDPPair returns a client mux and the address of a server mux
+// answering FindNode with serve
+func startUDPPair(t *testing.T, client net.PacketConn, serve func(rpcConn, Message)) (*udpMux, net.Addr) {
+	t.Helper()
+	server := newUDPMux(listenLoopback(t), serve)
+	server.Start()
+	t.Cleanup(func() { server.Close() })
+
+	m := newUDPMux(client, nil)
+	m.Start()
+	t.Cleanup(func() { m.Close() })
+	return m, server.pc.LocalAddr()
+}
+
+func answerWith(nodes []ContactInfo) func(rpcConn, Message) {
+	return func(conn rpcConn, msg Message) {
+		conn.Send(MsgFoundNodes, FoundNodesBody{Nodes: nodes})
+	}
+}
+
+func findNode(t *testing.T, m *udpMux, addr net.Addr) (Message, error) {
+	t.Helper()
+	ex, err := m.open(addr, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ex.Close()
+	if err := ex.Send(MsgFindNode, FindNodeBody{TargetID: NodeID{}.String()}); err != nil {
+		return Message{}, err
+	}
+	return ex.Receive()
+}
+
+func TestUDPRoundTrip(t *testing.T) {
+	nodes := []ContactInfo{{ID: NodeID{1}.String(), Addr: "200::1", Port: 9001}}
+	m, addr := startUDPPair(t, listenLoopback(t), answerWith(nodes))
+
+	msg, err := findNode(t, m, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found FoundNodesBody
+	if err := msg.Decode(&found); err != nil {
+		t.Fatal(err)
+	}
+	if len(found.Nodes) != 1 || found.Nodes[0].Addr != "200::1" {
+		t.Errorf("got %+v", found.Nodes)
+	}
+}
+
+func TestUDPRetransmit(t *testing.T) {
+	lossy := &lossyConn{PacketConn: listenLoopback(t)}
+	lossy.drop.Store(1)
+	m, addr := startUDPPair(t, lossy, answerWith(nil))
+
+	if _, err := findNode(t, m, addr); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.Stats().Retransmits; got != 1 {
+		t.Errorf("%d retransmits, want 1", got)
+	}
+}
+
+func TestUDPNoAnswer(t *testing.T) {
+	m := newUDPMux(listenLoopback(t), nil)
+	m.Start()
+	defer m.Close()
+	// a socket that never answers
+	silent := listenLoopback(t)
+	defer silent.Close()
+
+	if _, err := findNode(t, m, silent.LocalAddr()); !errors.Is(err, errNoAnswer) {
+		t.Fatalf("got %v, want errNoAnswer", err)
+	}
+	if got := m.Stats().Unanswered; got != 1 {
+		t.Errorf("%d unanswered, want 1", got)
+	}
+}
+
+func TestUDPTruncated(t *testing.T) {
+	var nodes []ContactInfo
+	for i := 0; i < 40; i++ {
+		nodes = append(nodes, ContactInfo{ID: NodeID{byte(i)}.String(), Addr: "200::1", Port: 9001, Network: strings.Repeat("n", 8)})
+	}
+	m, addr := startUDPPair(t, listenLoopback(t), answerWith(nodes))
+
+	if _, err := findNode(t, m, addr); !errors.Is(err, errTruncated) {
+		t.Fatalf("got %v, want errTruncated", err)
+	}
+}
+
+func TestUDPIgnoresSpoofedReply(t *testing.T) {
+	slow := func(conn rpcConn, msg Message) {
+		time.Sleep(300 * time.Millisecond)
+		answerWith(nil)(conn, msg)
+	}
+	m, addr := startUDPPair(t, listenLoopback(t), slow)
+
+	ex, err := m.open(addr, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ex.Close()
+	if err := ex.Send(MsgFindNode, FindNodeBody{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// another host answers first, with the right ID
+	attacker := listenLoopback(t)
+	defer attacker.Close()
+	spoof := make([]byte, udpHeaderSize)
+	spoof[1] = MsgFoundNodes
+	binary.BigEndian.PutUint32(spoof[2:6], ex.key.id)
+	spoof = append(spoof, `{"nodes":[{"id":"evil"}]}`...)
+	attacker.WriteTo(spoof, m.pc.LocalAddr())
+
+	msg, err := ex.Receive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found FoundNodesBody
+	if err := msg.Decode(&found); err != nil {
+		t.Fatal(err)
+	}
+	if len(found.Nodes) != 0 {
+		t.Errorf("spoofed reply accepted: %+v", found.Nodes)
+	}
+}
+
+func TestUDPRandomStartID(t *testing.T) {
+	a := newUDPMux(listenLoopback(t), nil)
+	b := newUDPMux(listenLoopback(t), nil)
+	defer a.Close()
+	defer b.Close()
+	if a.nextID == b.nextID {
+		t.Errorf("both muxes start at ID %d", a.nextID)
+	}
+}
@@ -0,0 +1,398 @@
+package dht
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+)
+
+// Protocol v2 bodies use a compact binary encoding instead of JSON:
+// keys, signatures and nonces travel as raw bytes, addresses as 16
+// bytes, and numbers and lengths as varints. Fields are written in
+// declaration order with no names or tags, so any change to a body is
+// a new protocol version.
+//
+// The encoding must round-trip exactly — records are signed over their
+// JSON form — so a value that wouldn't (upper-case hex, an IPv4 address
+// written the long way) fails to encode and the message goes as JSON.
+
+// Feature bits advertised in ping and pong
+const (
+	// FeatureMux is pooled connections with request IDs, see mux.go
+	FeatureMux uint64 = 1 << iota
+	// FeatureBinary is the compact body encoding of version 2, records
+	// without a sequence number. No longer advertised, so version 2 peers
+	// send us JSON
+	FeatureBinary
+	// FeatureUDP is lookups over datagrams, see udp.go — only advertised
+	// while our UDP socket is open
+	FeatureUDP
+	// FeatureBinarySeq is the compact body encoding of version 3, whose
+	// records carry a sequence number
+	FeatureBinarySeq
+)
+
+// localFeatures is everything this node always speaks
+const localFeatures = FeatureMux | FeatureBinarySeq
+
+// binaryMarshaler is a body with a v2 encoding
+type binaryMarshaler interface {
+	marshalWire(w *wireWriter)
+}
+
+// binaryUnmarshaler is the decoding side, on a pointer
+type binaryUnmarshaler interface {
+	unmarshalWire(r *wireReader)
+}
+
+// wireWriter appends fields, keeping the first error
+type wireWriter struct {
+	buf []byte
+	err error
+}
+
+func (w *wireWriter) fail(format string, args ...interface{}) {
+	if w.err == nil {
+		w.err = fmt.Errorf(format, args...)
+	}
+}
+
+func (w *wireWriter) uvarint(v uint64) {
+	w.buf = binary.AppendUvarint(w.buf, v)
+}
+
+func (w *wireWriter) varint(v int64) {
+	w.buf = binary.AppendVarint(w.buf, v)
+}
+
+func (w *wireWriter) bytes(b []byte) {
+	w.uvarint(uint64(len(b)))
+	w.buf = append(w.buf, b...)
+}
+
+func (w *wireWriter) string(s string) {
+	w.bytes([]byte(s))
+}
+
+// strings writes a list, keeping nil apart from empty — it shows in
+// a record's signed JSON as null rather than []
+func (w *wireWriter) strings(list []string) {
+	if list == nil {
+		w.uvarint(0)
+		return
+	}
+	w.uvarint(uint64(len(list)) + 1)
+	for _, s := range list {
+		w.string(s)
+	}
+}
+
+// hex writes a hex string as the bytes it encodes
+func (w *wireWriter) hex(s string) {
+	b, err := hex.DecodeString(s)
+	if err != nil || hex.EncodeToString(b) != s {
+		w.fail("%q is not lower-case hex", s)
+		return
+	}
+	w.bytes(b)
+}
+
+// ip writes an address in its 16 byte form, "" as nothing
+func (w *wireWriter) ip(s string) {
+	if s == "" {
+		w.bytes(nil)
+		return
+	}
+	ip := net.ParseIP(s)
+	if ip == nil || ip.String() != s {
+		w.fail("%q is not a canonical IP address", s)
+		return
+	}
+	w.bytes(ip.To16())
+}
+
+// wireReader reads fields back, keeping the first error
+type wireReader struct {
+	buf []byte
+	err error
+}
+
+func (r *wireReader) fail(format string, args ...interface{}) {
+	if r.err == nil {
+		r.err = fmt.Errorf(format, args...)
+	}
+}
+
+func (r *wireReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.buf)
+	if n <= 0 {
+		r.fail("truncated varint")
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return v
+}
+
+func (r *wireReader) varint() int64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(r.buf)
+	if n <= 0 {
+		r.fail("truncated varint")
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return v
+}
+
+func (r *wireReader) bytes() []byte {
+	n := r.uvarint()
+	if r.err != nil {
+		return nil
+	}
+	if n > uint64(len(r.buf)) {
+		r.fail("field of %d bytes overruns the body", n)
+		return nil
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func (r *wireReader) string() string {
+	return string(r.bytes())
+}
+
+func (r *wireReader) strings() []string {
+	n := r.uvarint()
+	if n == 0 {
+		return nil
+	}
+	n--
+	// every string takes at least a byte — don't trust n further than that
+	if n > uint64(len(r.buf)) {
+		r.fail("list of %d strings overruns the body", n)
+		return nil
+	}
+	list := []string{}
+	for i := uint64(0); i < n && r.err == nil; i++ {
+		list = append(list, r.string())
+	}
+	return list
+}
+
+func (r *wireReader) hex() string {
+	return hex.EncodeToString(r.bytes())
+}
+
+func (r *wireReader) ip() string {
+	b := r.bytes()
+	switch len(b) {
+	case 0:
+		return ""
+	case net.IPv6len:
+		return net.IP(b).String()
+	}
+	r.fail("address of %d bytes", len(b))
+	return ""
+}
+
+func marshalWire(body binaryMarshaler) ([]byte, error) {
+	w := &wireWriter{}
+	body.marshalWire(w)
+	return w.buf, w.err
+}
+
+func unmarshalWire(data []byte, body binaryUnmarshaler) error {
+	r := &wireReader{buf: data}
+	body.unmarshalWire(r)
+	if r.err == nil && len(r.buf) > 0 {
+		r.fail("%d bytes left over", len(r.buf))
+	}
+	if r.err != nil {
+		return fmt.Errorf("failed to decode message body: %w", r.err)
+	}
+	return nil
+}
+
+// ── bodies ───────────────────────────────────────────────────────────────────
+
+func (b PingBody) marshalWire(w *wireWriter) {
+	w.hex(b.SenderID)
+	w.ip(b.SenderAddr)
+	w.uvarint(uint64(b.SenderPort))
+	w.string(b.Network)
+	w.hex(b.Nonce)
+	w.uvarint(uint64(b.Version))
+	w.uvarint(b.Features)
+}
+
+func (b *PingBody) unmarshalWire(r *wireReader) {
+	b.SenderID = r.hex()
+	b.SenderAddr = r.ip()
+	b.SenderPort = int(r.uvarint())
+	b.Network = r.string()
+	b.Nonce = r.hex()
+	b.Version = int(r.uvarint())
+	b.Features = r.uvarint()
+}
+
+func (b PongBody) marshalWire(w *wireWriter) {
+	w.hex(b.SenderID)
+	w.ip(b.SenderAddr)
+	w.uvarint(uint64(b.SenderPort))
+	w.string(b.Network)
+	w.hex(b.Signature)
+	w.hex(b.Nonce)
+	w.uvarint(uint64(b.Version))
+	w.uvarint(b.Features)
+}
+
+func (b *PongBody) unmarshalWire(r *wireReader) {
+	b.SenderID = r.hex()
+	b.SenderAddr = r.ip()
+	b.SenderPort = int(r.uvarint())
+	b.Network = r.string()
+	b.Signature = r.hex()
+	b.Nonce = r.hex()
+	b.Version = int(r.uvarint())
+	b.Features = r.uvarint()
+}
+
+func (b PingAckBody) marshalWire(w *wireWriter) {
+	w.hex(b.Signature)
+}
+
+func (b *PingAckBody) unmarshalWire(r *wireReader) {
+	b.Signature = r.hex()
+}
+
+func (b FindNodeBody) marshalWire(w *wireWriter) {
+	w.hex(b.SenderID)
+	w.hex(b.TargetID)
+	w.string(b.Network)
+}
+
+func (b *FindNodeBody) unmarshalWire(r *wireReader) {
+	b.SenderID = r.hex()
+	b.TargetID = r.hex()
+	b.Network = r.string()
+}
+
+func (b FoundNodesBody) marshalWire(w *wireWriter) {
+	w.uvarint(uint64(len(b.Nodes)))
+	for _, c := range b.Nodes {
+		w.hex(c.ID)
+		w.ip(c.Addr)
+		w.uvarint(uint64(c.Port))
+		w.string(c.Network)
+	}
+}
+
+func (b *FoundNodesBody) unmarshalWire(r *wireReader) {
+	n := r.uvarint()
+	if n > uint64(len(r.buf)) {
+		r.fail("list of %d nodes overruns the body", n)
+		return
+	}
+	for i := uint64(0); i < n && r.err == nil; i++ {
+		b.Nodes = append(b.Nodes, ContactInfo{
+			ID:      r.hex(),
+			Addr:    r.ip(),
+			Port:    int(r.uvarint()),
+			Network: r.string(),
+		})
+	}
+}
+
+func (b StoreBody) marshalWire(w *wireWriter) {
+	b.Record.marshalWire(w)
+}
+
+func (b *StoreBody) unmarshalWire(r *wireReader) {
+	b.Record.unmarshalWire(r)
+}
+
+func (b CacheStoreBody) marshalWire(w *wireWriter) {
+	b.Record.marshalWire(w)
+	w.varint(b.TTL)
+}
+
+func (b *CacheStoreBody) unmarshalWire(r *wireReader) {
+	b.Record.unmarshalWire(r)
+	b.TTL = r.varint()
+}
+
+func (b FindValueBody) marshalWire(w *wireWriter) {
+	w.hex(b.SenderID)
+	w.string(b.Name)
+	w.string(b.GroupKey)
+	w.string(b.Network)
+}
+
+func (b *FindValueBody) unmarshalWire(r *wireReader) {
+	b.SenderID = r.hex()
+	b.Name = r.string()
+	b.GroupKey = r.string()
+	b.Network = r.string()
+}
+
+func (b FoundValueBody) marshalWire(w *wireWriter) {
+	b.Record.marshalWire(w)
+}
+
+func (b *FoundValueBody) unmarshalWire(r *wireReader) {
+	b.Record.unmarshalWire(r)
+}
+
+func (NotFoundBody) marshalWire(*wireWriter) {}
+
+func (*NotFoundBody) unmarshalWire(*wireReader) {}
+
+func (rec Record) marshalWire(w *wireWriter) {
+	w.string(rec.Name)
+	w.ip(rec.Address)
+	w.hex(rec.PublicKey)
+	w.strings(rec.Services)
+	w.string(rec.GroupKey)
+	w.hex(rec.Signature)
+	w.varint(rec.Expires)
+	w.uvarint(rec.Sequence)
+	w.string(rec.Network)
+	if rec.Succession == nil {
+		w.uvarint(0)
+		return
+	}
+	w.uvarint(1)
+	w.hex(rec.Succession.OldKey)
+	w.hex(rec.Succession.NewKey)
+	w.varint(rec.Succession.Effective)
+	w.hex(rec.Succession.Signature)
+	w.string(rec.Succession.Network)
+}
+
+func (rec *Record) unmarshalWire(r *wireReader) {
+	rec.Name = r.string()
+	rec.Address = r.ip()
+	rec.PublicKey = r.hex()
+	rec.Services = r.strings()
+	rec.GroupKey = r.string()
+	rec.Signature = r.hex()
+	rec.Expires = r.varint()
+	rec.Sequence = r.uvarint()
+	rec.Network = r.string()
+	if r.uvarint() == 1 {
+		rec.Succession = &Succession{
+			OldKey:    r.hex(),
+			NewKey:    r.hex(),
+			Effective: r.varint(),
+			Signature: r.hex(),
+			Network:   r.string(),
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package dht
+
+import (
+	"reflect"
+	"testing"
+)
+
+// wireCase is a reply as nodes send it, with a fresh body to decode into
+type wireCase struct {
+	name  string
+	typ   byte
+	body  interface{}
+	fresh func() interface{}
+}
+
+// wireCases are typical replies: a found record and a full bucket
+func wireCases(tb testing.TB) []wireCase {
+	r, _ := testRecord(tb, "alice")
+	r.Services = []string{"http", "ssh"}
+
+	var nodes []ContactInfo
+	for i := 0; i < K; i++ {
+		nodes = append(nodes, ContactInfo{ID: NodeID{byte(i), 0xab}.String(), Addr: "200:1234:5678::1", Port: DHTPort})
+	}
+	return []wireCase{
+		{"FoundValue", MsgFoundValue, &FoundValueBody{Record: r}, func() interface{} { return &FoundValueBody{} }},
+		{"FoundNodes", MsgFoundNodes, &FoundNodesBody{Nodes: nodes}, func() interface{} { return &FoundNodesBody{} }},
+	}
+}
+
+func TestWireRoundTrip(t *testing.T) {
+	for _, c := range wireCases(t) {
+		msg, err := newMessage(c.typ, c.body, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !msg.Binary {
+			t.Errorf("%s: sent as JSON", c.name)
+		}
+		got := c.fresh()
+		if err := msg.Decode(got); err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if !reflect.DeepEqual(got, c.body) {
+			t.Errorf("%s: got %+v, want %+v", c.name, got, c.body)
+		}
+	}
+}
+
+// encodings are the two a body goes out in, by whether the peer
+// advertised FeatureBinarySeq
+var encodings = []struct {
+	name    string
+	compact bool
+}{{"json", false}, {"wire", true}}
+
+func BenchmarkEncode(b *testing.B) {
+	for _, c := range wireCases(b) {
+		for _, enc := range encodings {
+			b.Run(c.name+"/"+enc.name, func(b *testing.B) {
+				var msg Message
+				for i := 0; i < b.N; i++ {
+					msg, _ = newMessage(c.typ, c.body, enc.compact)
+				}
+				b.ReportMetric(float64(len(msg.Body)), "bytes/msg")
+			})
+		}
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+	for _, c := range wireCases(b) {
+		for _, enc := range encodings {
+			b.Run(c.name+"/"+enc.name, func(b *testing.B) {
+				msg, err := newMessage(c.typ, c.body, enc.compact)
+				if err != nil {
+					b.Fatal(err)
+				}
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if err := msg.Decode(c.fresh()); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,388 @@
+package hjson
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Parse decodes an HJSON (or plain JSON) document — the format
+// Yggdrasil writes its config in, and the one MeshNet's follows. Supported: #, // and /* */ comments,
+// quoteless keys and values, optional commas, triple-quoted multi-line
+// strings, single-quoted strings and an optional root brace.
+//
+// Unlike HJSON, a comment set off by whitespace ends a quoteless value:
+// "PrivateKey: abc # mine" reads as "abc", as whoever wrote it meant.
+//
+// Objects decode to map[string]interface{}, arrays to []interface{},
+// numbers to float64 — the same shapes encoding/json produces.
+func Parse(data []byte) (interface{}, error) {
+	p := &parser{src: []rune(string(data)), line: 1}
+	p.skipWhitespace()
+
+	var value interface{}
+	var err error
+	if p.peek() == '{' || p.peek() == '[' {
+		value, err = p.parseValue()
+	} else {
+		// HJSON allows the root object braces to be omitted
+		value, err = p.parseMembers(false)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	p.skipWhitespace()
+	if !p.eof() {
+		return nil, p.errorf("unexpected %q after document", p.peek())
+	}
+	return value, nil
+}
+
+type parser struct {
+	src  []rune
+	pos  int
+	line int
+}
+
+// SyntaxError reports where a document failed to parse
+type SyntaxError struct {
+	Line int
+	Msg  string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return &SyntaxError{Line: p.line, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *parser) eof() bool {
+	return p.pos >= len(p.src)
+}
+
+func (p *parser) peek() rune {
+	if p.eof() {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+func (p *parser) peekAt(offset int) rune {
+	if p.pos+offset >= len(p.src) {
+		return 0
+	}
+	return p.src[p.pos+offset]
+}
+
+func (p *parser) next() rune {
+	r := p.src[p.pos]
+	p.pos++
+	if r == '\n' {
+		p.line++
+	}
+	return r
+}
+
+// skipWhitespace skips spaces, newlines and all three comment styles
+func (p *parser) skipWhitespace() {
+	for !p.eof() {
+		r := p.peek()
+		switch {
+		case r == ' ' || r == '\t' || r == '\r' || r == '\n':
+			p.next()
+		case r == '#' || (r == '/' && p.peekAt(1) == '/'):
+			for !p.eof() && p.peek() != '\n' {
+				p.next()
+			}
+		case r == '/' && p.peekAt(1) == '*':
+			p.next()
+			p.next()
+			for !p.eof() && !(p.peek() == '*' && p.peekAt(1) == '/') {
+				p.next()
+			}
+			if !p.eof() {
+				p.next()
+				p.next()
+			}
+		default:
+			return
+		}
+	}
+}
+
+// skipSeparator consumes an optional comma between members/elements
+func (p *parser) skipSeparator() {
+	p.skipWhitespace()
+	if p.peek() == ',' {
+		p.next()
+		p.skipWhitespace()
+	}
+}
+
+func (p *parser) parseValue() (interface{}, error) {
+	p.skipWhitespace()
+	if p.eof() {
+		return nil, p.errorf("unexpected end of input, expected value")
+	}
+
+	switch r := p.peek(); r {
+	case '{':
+		p.next()
+		return p.parseMembers(true)
+	case '[':
+		p.next()
+		return p.parseElements()
+	case '"':
+		return p.parseQuoted('"')
+	case '\'':
+		if p.peekAt(1) == '\'' && p.peekAt(2) == '\'' {
+			return p.parseMultiline()
+		}
+		return p.parseQuoted('\'')
+	case ',', ':', ']', '}':
+		return nil, p.errorf("unexpected %q, expected value", r)
+	default:
+		return p.parseQuoteless(), nil
+	}
+}
+
+// parseMembers reads key: value pairs until '}' (braced) or end of input
+func (p *parser) parseMembers(braced bool) (map[string]interface{}, error) {
+	obj := make(map[string]interface{})
+	for {
+		p.skipWhitespace()
+		if p.eof() {
+			if braced {
+				return nil, p.errorf("unexpected end of input, expected '}'")
+			}
+			return obj, nil
+		}
+		if p.peek() == '}' {
+			if !braced {
+				return nil, p.errorf("unexpected '}'")
+			}
+			p.next()
+			return obj, nil
+		}
+
+		key, err := p.parseKey()
+		if err != nil {
+			return nil, err
+		}
+
+		p.skipWhitespace()
+		if p.peek() != ':' {
+			return nil, p.errorf("expected ':' after key %q", key)
+		}
+		p.next()
+
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		obj[key] = value
+		p.skipSeparator()
+	}
+}
+
+func (p *parser) parseElements() ([]interface{}, error) {
+	arr := []interface{}{}
+	for {
+		p.skipWhitespace()
+		if p.eof() {
+			return nil, p.errorf("unexpected end of input, expected ']'")
+		}
+		if p.peek() == ']' {
+			p.next()
+			return arr, nil
+		}
+
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		arr = append(arr, value)
+		p.skipSeparator()
+	}
+}
+
+func (p *parser) parseKey() (string, error) {
+	switch p.peek() {
+	case '"', '\'':
+		return p.parseQuoted(p.peek())
+	}
+
+	start := p.pos
+	for !p.eof() {
+		r := p.peek()
+		if r == ':' || r == ',' || r == '{' || r == '}' || r == '[' || r == ']' ||
+			r == ' ' || r == '\t' || r == '\r' || r == '\n' {
+			break
+		}
+		p.next()
+	}
+	if p.pos == start {
+		return "", p.errorf("expected key, found %q", p.peek())
+	}
+	return string(p.src[start:p.pos]), nil
+}
+
+func (p *parser) parseQuoted(quote rune) (string, error) {
+	p.next() // opening quote
+	var b strings.Builder
+	for {
+		if p.eof() {
+			return "", p.errorf("unterminated string")
+		}
+		if p.peek() == '\n' {
+			return "", p.errorf("newline in quoted string")
+		}
+		r := p.next()
+		switch r {
+		case quote:
+			return b.String(), nil
+		case '\\':
+			if p.eof() {
+				return "", p.errorf("unterminated escape")
+			}
+			esc := p.next()
+			switch esc {
+			case '"', '\'', '\\', '/':
+				b.WriteRune(esc)
+			case 'b':
+				b.WriteRune('\b')
+			case 'f':
+				b.WriteRune('\f')
+			case 'n':
+				b.WriteRune('\n')
+			case 'r':
+				b.WriteRune('\r')
+			case 't':
+				b.WriteRune('\t')
+			case 'u':
+				if p.pos+4 > len(p.src) {
+					return "", p.errorf("short unicode escape")
+				}
+				code, err := strconv.ParseUint(string(p.src[p.pos:p.pos+4]), 16, 32)
+				if err != nil {
+					return "", p.errorf("invalid unicode escape")
+				}
+				p.pos += 4
+				b.WriteRune(rune(code))
+			default:
+				return "", p.errorf("invalid escape '\\%c'", esc)
+			}
+		default:
+			b.WriteRune(r)
+		}
+	}
+}
+
+// parseMultiline reads a triple-quoted string, stripping the indentation
+// of the opening quotes from every line as the HJSON spec requires
+func (p *parser) parseMultiline() (string, error) {
+	indent := 0
+	for i := p.pos - 1; i >= 0 && p.src[i] != '\n'; i-- {
+		indent++
+	}
+	p.pos += 3
+
+	// skip the rest of the opening line if it is blank
+	for !p.eof() && (p.peek() == ' ' || p.peek() == '\t' || p.peek() == '\r') {
+		p.next()
+	}
+	if p.peek() == '\n' {
+		p.next()
+	}
+
+	var b strings.Builder
+	col := 0
+	for {
+		if p.eof() {
+			return "", p.errorf("unterminated multi-line string")
+		}
+		if p.peek() == '\'' && p.peekAt(1) == '\'' && p.peekAt(2) == '\'' {
+			p.pos += 3
+			s := b.String()
+			s = strings.TrimSuffix(s, "\n")
+			return strings.TrimSuffix(s, "\r"), nil
+		}
+		r := p.next()
+		if r == '\n' {
+			b.WriteRune(r)
+			col = 0
+			continue
+		}
+		if col < indent && (r == ' ' || r == '\t') {
+			col++
+			continue
+		}
+		col = indent
+		b.WriteRune(r)
+	}
+}
+
+// parseQuoteless reads a bare value to the end of the line or a comment
+// after whitespace. Literals and numbers may also be followed by a comma
+// or a comment; anything else is a quoteless string and keeps the rest,
+// as in HJSON.
+func (p *parser) parseQuoteless() interface{} {
+	start := p.pos
+	for !p.eof() && p.peek() != '\n' && !p.atTrailingComment(start) {
+		p.next()
+	}
+	line := strings.TrimSpace(string(p.src[start:p.pos]))
+
+	// try a literal/number terminated by , ] } or a comment
+	end := len(line)
+	for i, r := range line {
+		if r == ',' || r == ']' || r == '}' || r == '#' ||
+			(r == '/' && i+1 < len(line) && (line[i+1] == '/' || line[i+1] == '*')) {
+			end = i
+			break
+		}
+	}
+	token := strings.TrimSpace(line[:end])
+	if value, ok := literal(token); ok {
+		// rewind so the terminator is handled by the caller
+		p.pos = start + len([]rune(token))
+		return value
+	}
+	return line
+}
+
+// atTrailingComment reports whether a comment starts here, set off by
+// whitespace from the value begun at start
+func (p *parser) atTrailingComment(start int) bool {
+	if p.pos == start {
+		return false
+	}
+	if prev := p.src[p.pos-1]; prev != ' ' && prev != '\t' {
+		return false
+	}
+	r := p.peek()
+	return r == '#' || (r == '/' && (p.peekAt(1) == '/' || p.peekAt(1) == '*'))
+}
+
+func literal(token string) (interface{}, bool) {
+	switch token {
+	case "true":
+		return true, true
+	case "false":
+		return false, true
+	case "null":
+		return nil, true
+	}
+	if token == "" {
+		return nil, false
+	}
+	if r := token[0]; r == '-' || (r >= '0' && r <= '9') {
+		if f, err := strconv.ParseFloat(token, 64); err == nil {
+			return f, true
+		}
+	}
+	return nil, false
+}
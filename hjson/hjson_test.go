@@ -0,0 +1,193 @@
+package hjson
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testKey = "c1f5a0b8e3d2c1f5a0b8e3d2c1f5a0b8e3d2c1f5a0b8e3d2c1f5a0b8e3d2c1f5a0b8e3d2c1f5a0b8e3d2c1f5a0b8e3d2c1f5a0b8e3d2c1f5a0b8e3d20badf00d"
+
+// genconf is what "yggdrasil -genconf" prints on Linux
+const genconf = `{
+  # Your private key. DO NOT share this with anyone!
+  PrivateKey: c1f5a0b8e3d2c1f5a0b8e3d2c1f5a0b8e3d2c1f5a0b8e3d2c1f5a0b8e3d2c1f5a0b8e3d2c1f5a0b8e3d2c1f5a0b8e3d2c1f5a0b8e3d2c1f5a0b8e3d20badf00d
+
+  # List of outbound peer connection strings (e.g. tls://a.b.c.d:e or
+  # socks://a.b.c.d:e/f.g.h.i:j). Connection strings can contain options,
+  # see https://yggdrasil-network.github.io/configurationref.html#peers.
+  # Yggdrasil has no concept of bootstrap nodes - all network traffic
+  # will transit peer connections. Therefore make sure to only peer with
+  # nearby nodes that have good connectivity and low latency. Avoid adding
+  # peers to this list from distant countries as this will worsen your
+  # node's connectivity and performance considerably.
+  Peers: []
+
+  # List of connection strings for outbound peer connections in URI format,
+  # arranged by source interface, e.g. { "eth0": [ "tls://a.b.c.d:e" ] }.
+  # You should only use this option if your machine is multi-homed and you
+  # want to establish outbound peer connections on different interfaces.
+  # Otherwise you should use "Peers".
+  InterfacePeers: {}
+
+  # Listen addresses for incoming connections. You will need to add
+  # listeners in order to accept incoming peerings from non-local nodes.
+  # This is not required if you wish to establish outbound peerings only.
+  # Multicast peer discovery will work regardless of any listeners set
+  # here. Each listener should be specified in URI format as above, e.g.
+  # tls://0.0.0.0:0 or tls://[::]:0 to listen on all interfaces.
+  Listen: []
+
+  # Listen address for admin connections. Default is to listen for local
+  # connections either on TCP/9001 or a UNIX socket depending on your
+  # platform. Use this value for yggdrasilctl -endpoint=X. To disable
+  # the admin socket, use the value "none" instead.
+  AdminListen: unix:///var/run/yggdrasil.sock
+
+  # Configuration for which interfaces multicast peer discovery should be
+  # enabled on. Regex is a regular expression which is matched against an
+  # interface name, and interfaces use the first configuration that they
+  # match against. Beacon controls whether or not your node advertises its
+  # presence to others, whereas Listen controls whether or not your node
+  # listens out for and tries to connect to other advertising nodes. See
+  # https://yggdrasil-network.github.io/configurationref.html#multicastinterfaces
+  # for more supported options.
+  MulticastInterfaces: [
+    {
+      Regex: .*
+      Beacon: true
+      Listen: true
+      Port: 0
+      Priority: 0
+      Password: ""
+    }
+  ]
+
+  # List of peer public keys to allow incoming peering connections
+  # from. If left empty/undefined then all connections will be allowed
+  # by default. This does not affect outgoing peerings, nor does it
+  # affect link-local peers discovered via multicast.
+  # WARNING: THIS IS NOT A FIREWALL and DOES NOT limit who can reach
+  # open ports or services running on your machine!
+  AllowedPublicKeys: []
+
+  # Local network interface name for TUN adapter, or "auto" to select
+  # an interface automatically, or "none" to run without TUN.
+  IfName: auto
+
+  # Maximum Transmission Unit (MTU) size for your local TUN interface.
+  # Default is the largest supported size for your platform. The lowest
+  # possible value is 1280.
+  IfMTU: 65535
+
+  # By default, nodeinfo contains some defaults including the platform,
+  # architecture and Yggdrasil version. These can help when surveying
+  # the network and diagnosing network routing problems. Enabling
+  # nodeinfo privacy prevents this, so that only items specified in
+  # "NodeInfo" are sent back if specified.
+  NodeInfoPrivacy: false
+
+  # Optional nodeinfo. This must be a { "key": "value", ... } map
+  # or set as null. This is entirely optional but, if set, is visible
+  # to the whole network on request.
+  NodeInfo: {}
+}
+`
+
+// edited sets genconf's private key line to line
+func edited(line string) string {
+	return strings.Replace(genconf, "PrivateKey: "+testKey, line, 1)
+}
+
+func TestParseGenconf(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+	}{
+		{"as generated", genconf},
+		{"hash comment", edited("PrivateKey: " + testKey + " # the old laptop's key")},
+		{"slash comment", edited("PrivateKey: " + testKey + "\t// the old laptop's key")},
+		{"block comment", edited("PrivateKey: " + testKey + " /* the old laptop's key */")},
+		{"block comment over lines", edited("PrivateKey: " + testKey + " /* the old\n  laptop's key */")},
+		{"quoted", edited(`PrivateKey: "` + testKey + `" # the old laptop's key`)},
+		{"no root braces", strings.TrimSuffix(strings.TrimPrefix(genconf, "{"), "}\n")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := Parse([]byte(tt.doc))
+			if err != nil {
+				t.Fatal(err)
+			}
+			doc := v.(map[string]interface{})
+			want := map[string]interface{}{
+				"PrivateKey":  testKey,
+				"AdminListen": "unix:///var/run/yggdrasil.sock",
+				"IfName":      "auto",
+				"IfMTU":       float64(65535),
+				"Peers":       []interface{}{},
+				"NodeInfo":    map[string]interface{}{},
+			}
+			for key, value := range want {
+				if !reflect.DeepEqual(doc[key], value) {
+					t.Errorf("%s = %#v, want %#v", key, doc[key], value)
+				}
+			}
+			multicast := doc["MulticastInterfaces"].([]interface{})[0].(map[string]interface{})
+			if multicast["Regex"] != ".*" || multicast["Beacon"] != true || multicast["Password"] != "" {
+				t.Errorf("MulticastInterfaces = %#v", multicast)
+			}
+		})
+	}
+}
+
+func TestParseValues(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		want interface{}
+	}{
+		{"URI keeps its slashes", "Listen: [\n  tls://[::]:0\n]", []interface{}{"tls://[::]:0"}},
+		{"URI then comment", "Listen: [\n  tls://[::]:0 # all interfaces\n]", []interface{}{"tls://[::]:0"}},
+		{"hash inside a value", "Listen: a#b", "a#b"},
+		{"number then comment", "Listen: 12 # twelve", float64(12)},
+		{"number then comma", "Listen: [1, 2]", []interface{}{float64(1), float64(2)}},
+		{"multi-line string", "Listen:\n  '''\n  one\n  two\n  '''", "one\ntwo"},
+		{"JSON", `{"Listen": "tls://[::]:0"}`, "tls://[::]:0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := Parse([]byte(tt.doc))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := v.(map[string]interface{})["Listen"]; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		line int
+	}{
+		{"unterminated string", "{\n  PrivateKey: \"abc\n}", 2},
+		{"missing colon", "{\n  PrivateKey\n}", 3},
+		{"unclosed object", "{\n  PrivateKey: abc\n", 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.doc))
+			var syntax *SyntaxError
+			if !errors.As(err, &syntax) {
+				t.Fatalf("got %v, want a syntax error", err)
+			}
+			if syntax.Line != tt.line {
+				t.Errorf("error on line %d, want %d: %v", syntax.Line, tt.line, err)
+			}
+		})
+	}
+}
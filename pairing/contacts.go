@@ -4,12 +4,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"sort"
 	"sync"
 	"time"
 )
 
-const contactsFile = "contacts.json"
-
 // Contact represents a paired device
 type Contact struct {
 	Name      string    `json:"name"`
@@ -21,17 +20,19 @@ type Contact struct {
 // ContactBook manages the local list of paired devices
 type ContactBook struct {
 	mu       sync.RWMutex
+	path     string
 	contacts map[string]Contact // keyed by public key
 }
 
-// LoadContacts reads contacts from disk
+// LoadContacts reads contacts from path
 // returns empty book if file doesn't exist
-func LoadContacts() (*ContactBook, error) {
+func LoadContacts(path string) (*ContactBook, error) {
 	book := &ContactBook{
+		path:     path,
 		contacts: make(map[string]Contact),
 	}
 
-	data, err := os.ReadFile(contactsFile)
+	data, err := os.ReadFile(path)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return book, nil
@@ -66,7 +67,7 @@ func (b *ContactBook) Save() error {
 		return fmt.Errorf("failed to encode contacts: %w", err)
 	}
 
-	return os.WriteFile(contactsFile, data, 0600)
+	return os.WriteFile(b.path, data, 0600)
 }
 
 // Add adds or updates a contact
@@ -76,6 +77,31 @@ func (b *ContactBook) Add(c Contact) {
 	b.contacts[c.PublicKey] = c
 }
 
+// Remove deletes the contact with the given public key
+// returns false if there was none
+func (b *ContactBook) Remove(publicKey string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.contacts[publicKey]; !ok {
+		return false
+	}
+	delete(b.contacts, publicKey)
+	return true
+}
+
+// Keys returns the public key of every contact, sorted
+func (b *ContactBook) Keys() []string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	keys := make([]string, 0, len(b.contacts))
+	for k := range b.contacts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 // All returns all contacts
 func (b *ContactBook) All() []Contact {
 	b.mu.RLock()
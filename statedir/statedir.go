@@ -0,0 +1,293 @@
+package statedir
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+)
+
+// DefaultProfile is used when no --profile is given
+const DefaultProfile = "default"
+
+// default ports of the default profile — other profiles are offset from these
+const (
+	DefaultDHTPort = 9001
+	DefaultAPIPort = 9099
+	// DefaultYggAdminPort is the admin socket of the TUN subprocess
+	DefaultYggAdminPort = 9091
+)
+
+// profileFile records the ports a profile was assigned so every command
+// run against the profile agrees on where its node listens
+const profileFile = "profile.json"
+
+// legacyFiles are the state files older releases kept in the working
+// directory — copied into the default profile the first time it is used
+var legacyFiles = []string{"identity.json", "peers.json", "contacts.json"}
+
+// legacyMarker is written to the default profile once the working
+// directory has been checked for legacy files, so it is checked only once
+const legacyMarker = ".legacy-checked"
+
+var profileNameRe = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_-]{0,31}$`)
+
+// Dir is the state directory of one profile
+// every file a node reads or writes lives under it
+type Dir struct {
+	Root    string // the directory holding all profiles
+	Profile string
+	Path    string // Root/Profile
+	DHTPort int
+	APIPort int
+	// Offset is how far the profile's ports are from the default
+	// profile's, and numbers its TUN interface
+	Offset       int
+	YggAdminPort int
+}
+
+type profileInfo struct {
+	DHTPort int `json:"dht_port"`
+	APIPort int `json:"api_port"`
+}
+
+// DefaultRoot returns the platform directory MeshNet keeps its state in:
+// $XDG_DATA_HOME/meshnet (~/.local/share/meshnet) on Linux and BSD,
+// ~/Library/Application Support/MeshNet on macOS, %AppData%\MeshNet on Windows
+func DefaultRoot() (string, error) {
+	switch runtime.GOOS {
+	case "windows", "darwin":
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return "", fmt.Errorf("failed to locate config directory: %w", err)
+		}
+		return filepath.Join(dir, "MeshNet"), nil
+	}
+
+	if xdg := os.Getenv("XDG_DATA_HOME"); xdg != "" && filepath.IsAbs(xdg) {
+		return filepath.Join(xdg, "meshnet"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate home directory: %w", err)
+	}
+	return filepath.Join(home, ".local", "share", "meshnet"), nil
+}
+
+// Open resolves the state directory for profile under root, creating it
+// and assigning ports on first use. An empty root means DefaultRoot,
+// an empty profile means DefaultProfile.
+func Open(root, profile string) (*Dir, error) {
+	if root == "" {
+		var err error
+		if root, err = DefaultRoot(); err != nil {
+			return nil, err
+		}
+	}
+	if profile == "" {
+		profile = DefaultProfile
+	}
+	if !profileNameRe.MatchString(profile) {
+		return nil, fmt.Errorf("invalid profile name %q — use letters, digits, - and _", profile)
+	}
+
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, fmt.Errorf("invalid data directory: %w", err)
+	}
+
+	d := &Dir{
+		Root:    root,
+		Profile: profile,
+		Path:    filepath.Join(root, profile),
+	}
+	if err := os.MkdirAll(d.Path, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	if err := d.loadPorts(); err != nil {
+		return nil, err
+	}
+
+	if profile == DefaultProfile {
+		if err := d.adoptLegacyFiles(); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// File returns the path of a named file inside the profile
+func (d *Dir) File(name string) string {
+	return filepath.Join(d.Path, name)
+}
+
+func (d *Dir) IdentityFile() string        { return d.File("identity.json") }
+func (d *Dir) PeersFile() string           { return d.File("peers.json") }
+func (d *Dir) ContactsFile() string        { return d.File("contacts.json") }
+func (d *Dir) SuccessionFile() string      { return d.File("succession.json") }
+func (d *Dir) SequenceFile() string        { return d.File("sequence.json") }
+func (d *Dir) YggdrasilConfigFile() string { return d.File("yggdrasil-meshnet.conf") }
+func (d *Dir) YggdrasilLogFile() string    { return d.File("yggdrasil.log") }
+func (d *Dir) AdminSocketFile() string     { return d.File("yggdrasil.sock") }
+
+// loadPorts reads the profile's ports, assigning the next free pair
+// if this is a new profile
+func (d *Dir) loadPorts() error {
+	path := d.File(profileFile)
+	data, err := os.ReadFile(path)
+	if err == nil {
+		var info profileInfo
+		if err := json.Unmarshal(data, &info); err != nil {
+			return fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		if info.DHTPort != 0 && info.APIPort != 0 {
+			d.DHTPort, d.APIPort = info.DHTPort, info.APIPort
+			d.Offset = info.offset()
+			d.YggAdminPort = DefaultYggAdminPort + d.Offset
+			return nil
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	offset := 0
+	if d.Profile != DefaultProfile {
+		offset = d.nextFreeOffset()
+	}
+	d.DHTPort = DefaultDHTPort + offset
+	d.APIPort = DefaultAPIPort + offset
+	d.Offset = offset
+	d.YggAdminPort = DefaultYggAdminPort + offset
+
+	data, err = json.MarshalIndent(profileInfo{DHTPort: d.DHTPort, APIPort: d.APIPort}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode profile: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("failed to save profile: %w", err)
+	}
+	return nil
+}
+
+// offset is how far a profile's ports are from the default profile's
+// the subprocess admin port isn't stored, it follows from the DHT port
+func (info profileInfo) offset() int {
+	return info.DHTPort - DefaultDHTPort
+}
+
+// ports returns every port a profile listens on
+func (info profileInfo) ports() []int {
+	return []int{info.DHTPort, info.APIPort, DefaultYggAdminPort + info.offset()}
+}
+
+func profileAt(offset int) profileInfo {
+	return profileInfo{DHTPort: DefaultDHTPort + offset, APIPort: DefaultAPIPort + offset}
+}
+
+// nextFreeOffset finds the smallest port offset whose ports no other
+// profile listens on. Offset 0 is reserved for the default profile.
+// Each profile's ports lie close together, so one far enough along
+// lands on another's: the DHT at offset 98 is the default API port.
+func (d *Dir) nextFreeOffset() int {
+	used := make(map[int]bool)
+	for _, port := range profileAt(0).ports() {
+		used[port] = true
+	}
+	entries, _ := os.ReadDir(d.Root)
+	for _, e := range entries {
+		if !e.IsDir() || e.Name() == d.Profile {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(d.Root, e.Name(), profileFile))
+		if err != nil {
+			continue
+		}
+		var info profileInfo
+		if json.Unmarshal(data, &info) == nil && info.DHTPort != 0 {
+			for _, port := range info.ports() {
+				used[port] = true
+			}
+		}
+	}
+
+	offset := 1
+	for overlaps(profileAt(offset).ports(), used) {
+		offset++
+	}
+	return offset
+}
+
+func overlaps(ports []int, used map[int]bool) bool {
+	for _, port := range ports {
+		if used[port] {
+			return true
+		}
+	}
+	return false
+}
+
+// adoptLegacyFiles copies state files left in the working directory by
+// older releases into the default profile — otherwise upgrading would
+// silently generate a new identity and a new address. It runs on the
+// profile's first use only, and leaves the originals where they are, so
+// a later run from another directory never takes files that belong to
+// another checkout.
+func (d *Dir) adoptLegacyFiles() error {
+	marker := d.File(legacyMarker)
+	if _, err := os.Stat(marker); err == nil {
+		return nil
+	}
+
+	cwd, err := os.Getwd()
+	if err == nil && cwd != d.Path {
+		for _, name := range legacyFiles {
+			src := filepath.Join(cwd, name)
+			dst := d.File(name)
+			if _, err := os.Stat(src); err != nil {
+				continue
+			}
+			if _, err := os.Stat(dst); err == nil {
+				continue
+			}
+			if err := copyFile(src, dst); err != nil {
+				fmt.Printf("Warning: failed to copy %s into %s: %v\n", name, d.Path, err)
+				continue
+			}
+			fmt.Printf("Copied %s into %s — the original can be deleted\n", name, d.Path)
+		}
+	}
+
+	if err := os.WriteFile(marker, nil, 0600); err != nil {
+		return fmt.Errorf("failed to save %s: %w", marker, err)
+	}
+	return nil
+}
+
+// copyFile copies src to a new file dst
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package statedir
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLegacyFilesCopiedOnce(t *testing.T) {
+	root := t.TempDir()
+	old := t.TempDir()
+	t.Chdir(old)
+	for _, name := range []string{"identity.json", "succession.json"} {
+		if err := os.WriteFile(name, []byte(name), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	d, err := Open(root, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(d.IdentityFile()); err != nil || string(data) != "identity.json" {
+		t.Errorf("identity not copied into the profile: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(old, "identity.json")); err != nil {
+		t.Error("original identity moved away")
+	}
+	if _, err := os.Stat(d.SuccessionFile()); err == nil {
+		t.Error("succession.json adopted — no release kept it in the working directory")
+	}
+
+	// a later run, from another checkout, leaves its files alone
+	other := t.TempDir()
+	t.Chdir(other)
+	if err := os.WriteFile("peers.json", []byte("other"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(root, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(d.PeersFile()); err == nil {
+		t.Error("peers.json adopted on a later run")
+	}
+}
+
+func TestOtherProfilesIgnoreLegacyFiles(t *testing.T) {
+	root := t.TempDir()
+	t.Chdir(t.TempDir())
+	if err := os.WriteFile("identity.json", nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := Open(root, "work")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(d.IdentityFile()); err == nil {
+		t.Error("identity adopted into a named profile")
+	}
+}
+
+func TestProfilePortsNeverOverlap(t *testing.T) {
+	root := t.TempDir()
+	t.Chdir(t.TempDir())
+
+	owner := make(map[int]string)
+	claim := func(d *Dir) {
+		for _, port := range []int{d.DHTPort, d.APIPort, d.YggAdminPort} {
+			if other, ok := owner[port]; ok {
+				t.Fatalf("profile %s (offset %d) got port %d of profile %s", d.Profile, d.Offset, port, other)
+			}
+			owner[port] = d.Profile
+		}
+	}
+
+	d, err := Open(root, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	claim(d)
+	// enough profiles to pass offset 98, where the DHT would take the
+	// default API port
+	for i := 1; i <= 110; i++ {
+		d, err := Open(root, fmt.Sprintf("p%d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d.Offset == 8 || d.Offset == 90 || d.Offset == 98 {
+			t.Errorf("profile given offset %d", d.Offset)
+		}
+		claim(d)
+	}
+
+	// reopening keeps the ports
+	again, err := Open(root, "p8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if owner[again.DHTPort] != "p8" || owner[again.YggAdminPort] != "p8" {
+		t.Errorf("reopened profile moved to %d/%d", again.DHTPort, again.YggAdminPort)
+	}
+}
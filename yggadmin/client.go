@@ -0,0 +1,166 @@
+package yggadmin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultTimeout bounds each request when Dial is given no timeout
+const DefaultTimeout = 5 * time.Second
+
+// Client talks to a Yggdrasil admin socket over TCP or a unix socket.
+// One connection is kept open with keepalive and reused for every
+// request; a Client is safe for concurrent use.
+type Client struct {
+	network string
+	addr    string
+	timeout time.Duration
+
+	mu   sync.Mutex
+	conn net.Conn
+	dec  *json.Decoder
+	enc  *json.Encoder
+}
+
+// Error is a failure reported by Yggdrasil itself rather than the transport
+type Error struct {
+	Request string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("yggdrasil %s: %s", e.Request, e.Message)
+}
+
+type request struct {
+	Name      string      `json:"request"`
+	Arguments interface{} `json:"arguments,omitempty"`
+	KeepAlive bool        `json:"keepalive"`
+}
+
+type response struct {
+	Status   string          `json:"status"`
+	Error    string          `json:"error"`
+	Response json.RawMessage `json:"response"`
+}
+
+// ParseEndpoint splits an admin endpoint as Yggdrasil writes it —
+// unix:///var/run/yggdrasil.sock, tcp://localhost:9001 or localhost:9001
+func ParseEndpoint(endpoint string) (network, addr string, err error) {
+	if !strings.Contains(endpoint, "://") {
+		return "tcp", endpoint, nil
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid admin endpoint %q: %w", endpoint, err)
+	}
+	switch u.Scheme {
+	case "unix":
+		return "unix", u.Path, nil
+	case "tcp":
+		return "tcp", u.Host, nil
+	}
+	return "", "", fmt.Errorf("admin endpoint %q: unsupported scheme %q", endpoint, u.Scheme)
+}
+
+// Dial connects to the admin socket at endpoint
+func Dial(endpoint string, timeout time.Duration) (*Client, error) {
+	network, addr, err := ParseEndpoint(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	c := &Client{network: network, addr: addr, timeout: timeout}
+	if err := c.connect(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Client) connect() error {
+	conn, err := net.DialTimeout(c.network, c.addr, c.timeout)
+	if err != nil {
+		return fmt.Errorf("cannot reach yggdrasil admin at %s: %w", c.addr, err)
+	}
+	c.conn = conn
+	c.dec = json.NewDecoder(conn)
+	c.enc = json.NewEncoder(conn)
+	return nil
+}
+
+// Close closes the connection
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
+
+// Call sends one request and decodes its response into out (which may
+// be nil). Exported for handlers this package has no wrapper for.
+func (c *Client) Call(name string, args, out interface{}) error {
+	return c.call(name, args, out, c.timeout)
+}
+
+func (c *Client) call(name string, args, out interface{}, timeout time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil {
+		// the server dropped us after an error — reconnect once
+		if err := c.connect(); err != nil {
+			return err
+		}
+	}
+
+	raw, err := c.roundTrip(name, args, timeout)
+	if err != nil {
+		// a broken connection is useless for the next call too
+		c.conn.Close()
+		c.conn = nil
+		return err
+	}
+
+	if raw.Status != "success" {
+		msg := raw.Error
+		if msg == "" {
+			msg = "status " + raw.Status
+		}
+		return &Error{Request: name, Message: msg}
+	}
+	if out == nil || len(raw.Response) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(raw.Response, out); err != nil {
+		return fmt.Errorf("failed to decode %s response: %w", name, err)
+	}
+	return nil
+}
+
+func (c *Client) roundTrip(name string, args interface{}, timeout time.Duration) (*response, error) {
+	c.conn.SetDeadline(time.Now().Add(timeout))
+	defer c.conn.SetDeadline(time.Time{})
+
+	if err := c.enc.Encode(request{Name: name, Arguments: args, KeepAlive: true}); err != nil {
+		return nil, fmt.Errorf("failed to send %s: %w", name, err)
+	}
+
+	// the decoder reads as much as the response needs — no fixed buffer
+	var raw response
+	if err := c.dec.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("failed to read %s response: %w", name, err)
+	}
+	return &raw, nil
+}
@@ -0,0 +1,164 @@
+package yggadmin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"meshnet/yggadmin/yggadmintest"
+)
+
+func dialFake(t *testing.T, endpoint string) *Client {
+	t.Helper()
+	c, err := Dial(endpoint, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestParseEndpoint(t *testing.T) {
+	tests := []struct {
+		endpoint, network, addr string
+	}{
+		{"unix:///var/run/yggdrasil.sock", "unix", "/var/run/yggdrasil.sock"},
+		{"tcp://localhost:9001", "tcp", "localhost:9001"},
+		{"localhost:9001", "tcp", "localhost:9001"},
+	}
+	for _, tt := range tests {
+		network, addr, err := ParseEndpoint(tt.endpoint)
+		if err != nil || network != tt.network || addr != tt.addr {
+			t.Errorf("ParseEndpoint(%q) = %q, %q, %v", tt.endpoint, network, addr, err)
+		}
+	}
+	if _, _, err := ParseEndpoint("udp://localhost:9001"); err == nil {
+		t.Error("udp endpoint accepted")
+	}
+}
+
+func TestGetSelf(t *testing.T) {
+	for _, network := range []string{"tcp", "unix"} {
+		endpoint := yggadmintest.Serve(t, network, func(name string, _ json.RawMessage) (interface{}, error) {
+			if name != "getSelf" {
+				return nil, fmt.Errorf("unknown request %s", name)
+			}
+			return map[string]interface{}{"key": "abcd", "address": "200::1", "routing_entries": 7}, nil
+		})
+		self, err := dialFake(t, endpoint).GetSelf()
+		if err != nil {
+			t.Fatalf("%s: %v", network, err)
+		}
+		if self.PublicKey != "abcd" || self.Address != "200::1" || self.RoutingEntries != 7 {
+			t.Errorf("%s: got %+v", network, self)
+		}
+	}
+}
+
+// the old client read one 4096 byte buffer and lost the rest
+func TestLargeResponse(t *testing.T) {
+	var peers []Peer
+	for i := 0; i < 200; i++ {
+		peers = append(peers, Peer{URI: fmt.Sprintf("tls://peer%d.example:443", i), Up: true, PublicKey: fmt.Sprintf("%064x", i)})
+	}
+	endpoint := yggadmintest.Serve(t, "tcp", func(string, json.RawMessage) (interface{}, error) {
+		return map[string]interface{}{"peers": peers}, nil
+	})
+
+	got, err := dialFake(t, endpoint).GetPeers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(peers) || got[199].URI != peers[199].URI {
+		t.Errorf("got %d peers, want %d", len(got), len(peers))
+	}
+}
+
+func TestErrorPropagation(t *testing.T) {
+	endpoint := yggadmintest.Serve(t, "tcp", func(name string, args json.RawMessage) (interface{}, error) {
+		if name == "addPeer" {
+			var peer struct {
+				URI string `json:"uri"`
+			}
+			json.Unmarshal(args, &peer)
+			return nil, fmt.Errorf("bad peer %s", peer.URI)
+		}
+		return map[string]string{"key": "abcd"}, nil
+	})
+	c := dialFake(t, endpoint)
+
+	err := c.AddPeer("tls://nowhere:1", "")
+	var yerr *Error
+	if !errors.As(err, &yerr) {
+		t.Fatalf("got %v, want *Error", err)
+	}
+	if yerr.Request != "addPeer" || yerr.Message != "bad peer tls://nowhere:1" {
+		t.Errorf("got %+v", yerr)
+	}
+
+	// an error from Yggdrasil leaves the connection usable
+	if _, err := c.GetSelf(); err != nil {
+		t.Errorf("call after an error failed: %v", err)
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	var calls atomic.Int32
+	endpoint := yggadmintest.Serve(t, "tcp", func(string, json.RawMessage) (interface{}, error) {
+		if calls.Add(1) == 1 {
+			return nil, yggadmintest.ErrHangUp
+		}
+		return map[string]string{"key": "abcd"}, nil
+	})
+	c := dialFake(t, endpoint)
+
+	if _, err := c.GetSelf(); err == nil {
+		t.Fatal("no error when the server hung up")
+	}
+	// the broken connection is dropped and the next call dials again
+	if _, err := c.GetSelf(); err != nil {
+		t.Errorf("no reconnect after the server hung up: %v", err)
+	}
+}
+
+func TestGetNodeInfo(t *testing.T) {
+	endpoint := yggadmintest.Serve(t, "tcp", func(name string, args json.RawMessage) (interface{}, error) {
+		var req struct {
+			Key string `json:"key"`
+		}
+		json.Unmarshal(args, &req)
+		return map[string]interface{}{req.Key: map[string]string{"name": "alice"}}, nil
+	})
+	c := dialFake(t, endpoint)
+
+	info, err := c.GetNodeInfo("abcd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info["name"] != "alice" {
+		t.Errorf("got %v", info)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	endpoint := yggadmintest.Serve(t, "tcp", func(string, json.RawMessage) (interface{}, error) {
+		time.Sleep(500 * time.Millisecond)
+		return nil, nil
+	})
+	c, err := Dial(endpoint, 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	start := time.Now()
+	if _, err := c.GetSelf(); err == nil {
+		t.Fatal("no error from a server that never answered in time")
+	}
+	if time.Since(start) > 400*time.Millisecond {
+		t.Errorf("call took %s with a 100ms timeout", time.Since(start))
+	}
+}
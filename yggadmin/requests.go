@@ -0,0 +1,178 @@
+package yggadmin
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Self is the getSelf response
+type Self struct {
+	BuildName      string `json:"build_name"`
+	BuildVersion   string `json:"build_version"`
+	PublicKey      string `json:"key"`
+	Address        string `json:"address"`
+	Subnet         string `json:"subnet"`
+	RoutingEntries uint64 `json:"routing_entries"`
+}
+
+// Peer is one entry of getPeers
+type Peer struct {
+	URI           string        `json:"remote"`
+	Up            bool          `json:"up"`
+	Inbound       bool          `json:"inbound"`
+	Address       string        `json:"address"`
+	PublicKey     string        `json:"key"`
+	Port          uint64        `json:"port"`
+	Priority      uint64        `json:"priority"`
+	Cost          uint64        `json:"cost"`
+	RXBytes       uint64        `json:"bytes_recvd"`
+	TXBytes       uint64        `json:"bytes_sent"`
+	RXRate        uint64        `json:"rate_recvd"`
+	TXRate        uint64        `json:"rate_sent"`
+	Uptime        float64       `json:"uptime"` // seconds
+	Latency       time.Duration `json:"latency"`
+	LastErrorTime time.Duration `json:"last_error_time"`
+	LastError     string        `json:"last_error"`
+}
+
+// Session is one entry of getSessions
+type Session struct {
+	Address   string  `json:"address"`
+	PublicKey string  `json:"key"`
+	RXBytes   uint64  `json:"bytes_recvd"`
+	TXBytes   uint64  `json:"bytes_sent"`
+	Uptime    float64 `json:"uptime"` // seconds
+}
+
+// Path is one entry of getPaths
+type Path struct {
+	Address   string   `json:"address"`
+	PublicKey string   `json:"key"`
+	Path      []uint64 `json:"path"`
+	Sequence  uint64   `json:"sequence"`
+}
+
+// TreeEntry is one entry of getTree
+type TreeEntry struct {
+	Address   string `json:"address"`
+	PublicKey string `json:"key"`
+	Parent    string `json:"parent"`
+	Sequence  uint64 `json:"sequence"`
+}
+
+// GetSelf returns this node's key, address and build
+func (c *Client) GetSelf() (*Self, error) {
+	var self Self
+	if err := c.Call("getSelf", nil, &self); err != nil {
+		return nil, err
+	}
+	return &self, nil
+}
+
+// GetPeers lists directly connected peers
+func (c *Client) GetPeers() ([]Peer, error) {
+	var res struct {
+		Peers []Peer `json:"peers"`
+	}
+	if err := c.Call("getPeers", nil, &res); err != nil {
+		return nil, err
+	}
+	return res.Peers, nil
+}
+
+// GetSessions lists open end-to-end sessions
+func (c *Client) GetSessions() ([]Session, error) {
+	var res struct {
+		Sessions []Session `json:"sessions"`
+	}
+	if err := c.Call("getSessions", nil, &res); err != nil {
+		return nil, err
+	}
+	return res.Sessions, nil
+}
+
+// GetPaths lists known source routes
+func (c *Client) GetPaths() ([]Path, error) {
+	var res struct {
+		Paths []Path `json:"paths"`
+	}
+	if err := c.Call("getPaths", nil, &res); err != nil {
+		return nil, err
+	}
+	return res.Paths, nil
+}
+
+// GetTree lists the spanning-tree entries this node knows
+func (c *Client) GetTree() ([]TreeEntry, error) {
+	var res struct {
+		Tree []TreeEntry `json:"tree"`
+	}
+	if err := c.Call("getTree", nil, &res); err != nil {
+		return nil, err
+	}
+	return res.Tree, nil
+}
+
+// MulticastInterface is one entry of getMulticastInterfaces
+type MulticastInterface struct {
+	Name     string `json:"name"`
+	Address  string `json:"address"` // multicast peering listener, "-" if none
+	Beacon   bool   `json:"beacon"`
+	Listen   bool   `json:"listen"`
+	Password bool   `json:"password"`
+}
+
+// GetMulticastInterfaces lists the interfaces LAN discovery runs on
+// fails with *Error when the multicast module is not loaded
+func (c *Client) GetMulticastInterfaces() ([]MulticastInterface, error) {
+	var res struct {
+		Interfaces []MulticastInterface `json:"multicast_interfaces"`
+	}
+	if err := c.Call("getMulticastInterfaces", nil, &res); err != nil {
+		return nil, err
+	}
+	return res.Interfaces, nil
+}
+
+type peerArgs struct {
+	URI       string `json:"uri"`
+	Interface string `json:"interface,omitempty"`
+}
+
+// AddPeer connects to a peer URI, optionally through a named interface
+func (c *Client) AddPeer(uri, intf string) error {
+	return c.Call("addPeer", peerArgs{URI: uri, Interface: intf}, nil)
+}
+
+// RemovePeer disconnects a peer previously added with AddPeer
+func (c *Client) RemovePeer(uri, intf string) error {
+	return c.Call("removePeer", peerArgs{URI: uri, Interface: intf}, nil)
+}
+
+// nodeInfoTimeout covers Yggdrasil's own 6 second wait for the remote reply
+const nodeInfoTimeout = 10 * time.Second
+
+// GetNodeInfo asks the node with the given public key (hex) for its
+// NodeInfo. The reply travels over the mesh, so it can take a while.
+func (c *Client) GetNodeInfo(key string) (map[string]interface{}, error) {
+	timeout := c.timeout
+	if timeout < nodeInfoTimeout {
+		timeout = nodeInfoTimeout
+	}
+
+	var res map[string]json.RawMessage
+	if err := c.call("getNodeInfo", map[string]string{"key": key}, &res, timeout); err != nil {
+		return nil, err
+	}
+
+	raw, ok := res[key]
+	if !ok {
+		return nil, fmt.Errorf("getNodeInfo: no answer for %s", key)
+	}
+	var info map[string]interface{}
+	if err := json.Unmarshal(raw, &info); err != nil {
+		return nil, fmt.Errorf("failed to decode nodeinfo: %w", err)
+	}
+	return info, nil
+}
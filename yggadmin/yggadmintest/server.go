@@ -0,0 +1,69 @@
+// Package yggadmintest serves the Yggdrasil admin protocol on a local
+// socket, so code that talks to an admin socket can be tested without
+// a running Yggdrasil
+package yggadmintest
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+// Handler answers one admin request, as the response body or an error
+type Handler func(name string, args json.RawMessage) (interface{}, error)
+
+// ErrHangUp makes the server close the connection without answering
+var ErrHangUp = errors.New("hang up")
+
+// Serve serves the admin protocol on a local socket until the test
+// ends and returns the endpoint to dial. Like Yggdrasil, it keeps a
+// keepalive connection open after a failed request.
+func Serve(tb testing.TB, network string, handle Handler) string {
+	tb.Helper()
+	addr := "127.0.0.1:0"
+	if network == "unix" {
+		addr = filepath.Join(tb.TempDir(), "admin.sock")
+	}
+	l, err := net.Listen(network, addr)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serve(conn, handle)
+		}
+	}()
+	return network + "://" + l.Addr().String()
+}
+
+func serve(conn net.Conn, handle Handler) {
+	defer conn.Close()
+	dec := json.NewDecoder(conn)
+	enc := json.NewEncoder(conn)
+	for {
+		var req struct {
+			Name      string          `json:"request"`
+			Arguments json.RawMessage `json:"arguments"`
+		}
+		if err := dec.Decode(&req); err != nil {
+			return
+		}
+		res, err := handle(req.Name, req.Arguments)
+		if err == ErrHangUp {
+			return
+		}
+		if err != nil {
+			enc.Encode(map[string]string{"status": "error", "error": err.Error()})
+			continue
+		}
+		enc.Encode(map[string]interface{}{"status": "success", "response": res})
+	}
+}